@@ -48,6 +48,12 @@ func main() {
 	cameraHandler := handler.NewCameraHandler(userStorageManager)
 	sharedAlbumHandler := handler.NewSharedAlbumHandler(userStorageManager)
 	villageHandler := handler.NewVillageHandler(userStorageManager)
+	shareLinkHandler := handler.NewShareLinkHandler(userStorageManager)
+	activityHandler := handler.NewActivityHandler(userStorageManager)
+	memoryHandler := handler.NewMemoryHandler(userStorageManager)
+	statsHandler := handler.NewStatsHandler(userStorageManager)
+	adminHandler := handler.NewAdminHandler(userStorageManager)
+	hiddenHandler := handler.NewHiddenHandler(userStorageManager)
 
 	// Handler Gin router
 	router := createRouter(
@@ -59,7 +65,13 @@ func main() {
 		personHandler,
 		searchHandler,
 		pinnedHandler,
-		cameraHandler)
+		cameraHandler,
+		shareLinkHandler,
+		activityHandler,
+		memoryHandler,
+		statsHandler,
+		adminHandler,
+		hiddenHandler)
 
 	// Start server
 	startServer(router)
@@ -115,6 +127,12 @@ func createRouter(
 	searchHandler *handler.SearchHandler,
 	pinnedHandler *handler.PinnedHandler,
 	cameraHandler *handler.CameraHandler,
+	shareLinkHandler *handler.ShareLinkHandler,
+	activityHandler *handler.ActivityHandler,
+	memoryHandler *handler.MemoryHandler,
+	statsHandler *handler.StatsHandler,
+	adminHandler *handler.AdminHandler,
+	hiddenHandler *handler.HiddenHandler,
 ) *gin.Engine {
 
 	// Set Gin mode
@@ -135,10 +153,22 @@ func createRouter(
 		api.POST("/assets/create", assetHandler.Create)
 		api.POST("/assets", assetHandler.Upload)
 		api.GET("/assets/:id", assetHandler.Get)
+		api.GET("/assets/:id/video", assetHandler.GetVideo)
+		api.GET("/assets/:id/thumbnail", assetHandler.Thumbnail)
+		api.GET("/assets/:id/details", assetHandler.GetDetails)
+		api.GET("/assets/:id/metadata", assetHandler.GetMetadata)
+		api.GET("/assets/:id/edit", assetHandler.GetEdit)
+		api.POST("/assets/:id/edit", assetHandler.Edit)
+		api.POST("/assets/:id/revert", assetHandler.RevertEdit)
+		api.GET("/assets/:id/rendered", assetHandler.Rendered)
+		api.GET("/assets/:id/resources", assetHandler.Resources)
+		api.GET("/assets/:id/download", assetHandler.DownloadResource)
 		api.POST("/assets/update", assetHandler.Update)
 		api.POST("/assets/update_all", assetHandler.UpdateAll)
+		api.GET("/assets/keywords", assetHandler.Keywords)
 		api.POST("/assets/delete", assetHandler.Delete)
 		api.POST("/assets/filters", assetHandler.Filters)
+		api.POST("/timeline", assetHandler.Timeline)
 
 		//http://localhost:8080/api/v1/assets/download/thumbnail/map_270.jpg
 		api.GET("/assets/download/:filename", assetHandler.OriginalDownload)
@@ -156,16 +186,42 @@ func createRouter(
 		api.POST("/shared_album/update", sharedAlbumHandler.Update)
 		api.POST("/shared_album/delete", sharedAlbumHandler.Delete)
 		api.POST("/shared_album/list", sharedAlbumHandler.GetList)
+		api.POST("/shared_album/assets", sharedAlbumHandler.GetAssets)
+		api.POST("/shared_album/assets/add", sharedAlbumHandler.AddAssets)
+		api.POST("/shared_album/assets/remove", sharedAlbumHandler.RemoveAssets)
+		api.POST("/shared_album/invite", sharedAlbumHandler.Invite)
+		api.POST("/shared_album/link", sharedAlbumHandler.CreateLink)
+		api.POST("/shared_album/join", sharedAlbumHandler.Join)
+		api.POST("/shared_album/member/remove", sharedAlbumHandler.RemoveMember)
+		api.POST("/shared_album/feedback", sharedAlbumHandler.GetFeedback)
+		api.POST("/shared_album/comment/add", sharedAlbumHandler.AddComment)
+		api.POST("/shared_album/comment/delete", sharedAlbumHandler.DeleteComment)
+		api.POST("/shared_album/reaction/add", sharedAlbumHandler.AddReaction)
+		api.POST("/shared_album/reaction/delete", sharedAlbumHandler.DeleteReaction)
 
 		api.POST("/trip/create", tripHandler.Create)
 		api.POST("/trip/update", tripHandler.Update)
 		api.POST("/trip/delete", tripHandler.Delete)
 		api.POST("/trip/list", tripHandler.GetCollectionList)
+		api.POST("/trip/detail", tripHandler.GetDetail)
+		api.POST("/trip/suggestion/list", tripHandler.GetSuggestions)
+		api.POST("/trip/suggestion/accept", tripHandler.AcceptSuggestion)
+		api.POST("/trip/suggestion/dismiss", tripHandler.DismissSuggestion)
 
 		api.POST("/person/create", personHandler.Create)
 		api.POST("/person/update", personHandler.Update)
 		api.POST("/person/delete", personHandler.Delete)
 		api.POST("/person/list", personHandler.GetCollectionList)
+		api.POST("/person/merge", personHandler.Merge)
+		api.POST("/person/split", personHandler.Split)
+		api.POST("/person/avatar", personHandler.SetAvatar)
+		api.POST("/person/face/list", personHandler.GetFaces)
+		api.POST("/person/face/tag", personHandler.TagFace)
+		api.POST("/person/face/untag", personHandler.UntagFace)
+		api.POST("/person/face/scan", personHandler.ScanFaces)
+		api.POST("/person/suggestion/list", personHandler.GetSuggestions)
+		api.POST("/person/suggestion/confirm", personHandler.ConfirmSuggestion)
+		api.POST("/person/suggestion/dismiss", personHandler.DismissSuggestion)
 
 		api.POST("/pinned/create", pinnedHandler.Create)
 		api.POST("/pinned/update", pinnedHandler.Update)
@@ -177,6 +233,32 @@ func createRouter(
 		//api.POST("/camera/delete", cameraHandler.Delete)
 		api.POST("/camera/list", cameraHandler.GetList)
 
+		api.POST("/share_link/create", shareLinkHandler.Create)
+		api.POST("/share_link/delete", shareLinkHandler.Delete)
+		api.POST("/share_link/list", shareLinkHandler.GetList)
+
+		api.GET("/activity", activityHandler.GetList)
+
+		api.GET("/memories", memoryHandler.GetList)
+		api.GET("/memories/:id", memoryHandler.GetAssets)
+
+		api.GET("/stats", statsHandler.Get)
+
+		api.POST("/admin/quota", adminHandler.SetQuota)
+
+		api.POST("/hidden/pin", hiddenHandler.SetPin)
+		api.POST("/hidden/unlock", hiddenHandler.Unlock)
+		api.POST("/hidden/assets", hiddenHandler.GetAssets)
+
+	}
+
+	// Public share link routes, no userID required
+	share := router.Group("/share")
+	{
+		share.GET("/:token", shareLinkHandler.View)
+		share.POST("/:token/unlock", shareLinkHandler.Unlock)
+		share.GET("/:token/thumbnail/:filename", shareLinkHandler.TinyImageDownload)
+		share.GET("/:token/download/:filename", shareLinkHandler.OriginalDownload)
 	}
 
 	// Health check endpoint
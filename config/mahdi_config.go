@@ -18,3 +18,17 @@ func GetUserPath(phone string, file string) string {
 	fmt.Println(pp)
 	return pp
 }
+
+// ThumbnailSizes are the widths and heights, in pixels, thumbnails are generated at on request
+var ThumbnailSizes = []int{90, 135, 180, 270, 360, 540, 720, 1080}
+
+// DerivativeCacheBytes caps the disk used by the generated thumbnails of each user
+const DerivativeCacheBytes = 1 << 30
+
+// XMPSidecars writes metadata edits to an XMP file next to each original, as Lightroom
+// and digiKam read them
+var XMPSidecars = true
+
+// XMPEmbedJPEG also writes metadata edits into the XMP segment of JPEG originals,
+// which rewrites the files
+var XMPEmbedJPEG = false
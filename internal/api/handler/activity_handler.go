@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"github.com/mahdi-cpp/photocloud_v2/internal/storage"
+	"net/http"
+)
+
+type ActivityHandler struct {
+	userStorageManager *storage.UserStorageManager
+}
+
+func NewActivityHandler(userStorageManager *storage.UserStorageManager) *ActivityHandler {
+	return &ActivityHandler{
+		userStorageManager: userStorageManager,
+	}
+}
+
+func (handler *ActivityHandler) GetList(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var with model.ActivityFetchOptions
+	if err := c.ShouldBindQuery(&with); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	items, total, err := handler.userStorageManager.FetchActivity(userID, with)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := common_models.PHFetchResult[*model.Activity]{
+		Items:  items,
+		Total:  total,
+		Limit:  with.FetchLimit,
+		Offset: with.FetchOffset,
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"github.com/mahdi-cpp/photocloud_v2/internal/storage"
+	"net/http"
+)
+
+// AdminHandler serves the operations reserved to the server admin, requests carry
+// the key of data/admin.key in the adminKey header
+type AdminHandler struct {
+	userStorageManager *storage.UserStorageManager
+}
+
+func NewAdminHandler(userStorageManager *storage.UserStorageManager) *AdminHandler {
+	return &AdminHandler{
+		userStorageManager: userStorageManager,
+	}
+}
+
+func (handler *AdminHandler) SetQuota(c *gin.Context) {
+
+	if !handler.userStorageManager.VerifyAdminKey(c.GetHeader("adminKey")) {
+		c.JSON(http.StatusForbidden, model.NewErrorResponse(model.ErrForbidden, ""))
+		return
+	}
+
+	var request model.QuotaRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	quota, err := handler.userStorageManager.SetQuota(request)
+	if err != nil {
+		c.JSON(quotaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, quota)
+}
+
+func quotaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -45,7 +45,7 @@ func (handler *AlbumHandler) Create(c *gin.Context) {
 	}
 
 	update := common_models.AssetUpdate{AssetIds: request.AssetIds, AddAlbums: []int{newItem.ID}}
-	_, err = userStorage.UpdateAsset(update)
+	_, err = userStorage.UpdateAsset(model.AssetUpdate{AssetUpdate: update})
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -73,6 +73,11 @@ func (handler *AlbumHandler) Update(c *gin.Context) {
 		return
 	}
 
+	if itemHandler.IsHidden != nil && !*itemHandler.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": storage.ErrHiddenToken.Error()})
+		return
+	}
+
 	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
@@ -135,7 +140,10 @@ func (handler *AlbumHandler) GetCollectionList(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 	}
 
-	item2, err := userStorage.AlbumManager.GetAll()
+	unlocked := hiddenUnlocked(c, handler.userStorageManager, userID)
+	item2, err := userStorage.AlbumManager.GetList(func(a *model.Album) bool {
+		return unlocked || !a.IsHidden
+	})
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 		return
@@ -170,6 +178,17 @@ func (handler *AlbumHandler) GetListV2(c *gin.Context) {
 		return
 	}
 
+	// Hidden albums are listed only while the hidden album is unlocked
+	if !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		visible := items[:0:0]
+		for _, item := range items {
+			if !item.IsHidden {
+				visible = append(visible, item)
+			}
+		}
+		items = visible
+	}
+
 	result := common_models.PHCollectionList[*model.Album]{
 		Collections: make([]*common_models.PHCollection[*model.Album], len(items)),
 	}
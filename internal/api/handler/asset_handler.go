@@ -1,8 +1,11 @@
 package handler
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
 	"github.com/mahdi-cpp/photocloud_v2/internal/storage"
 	"log"
 	"net/http"
@@ -40,28 +43,24 @@ func (handler *AssetHandler) Upload(c *gin.Context) {
 	}
 	defer file.Close()
 
-	// Handler asset metadata
-	asset := &common_models.PHAsset{
-		UserID:   userID,
-		Filename: header.Filename,
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	//userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
-	//if err != nil {
-	//	c.JSON(http.StatusBadRequest, gin.H{"error": err})
-	//}
-
-	//asset, err = userStorage.UploadAsset(asset.UserID, file, header)
-	//if err != nil {
-	//	c.JSON(http.StatusInternalServerError, gin.H{"error": "Processing failed"})
-	//	return
-	//}
+	asset, err := userStorage.UploadAsset(userID, file, header)
+	if err != nil {
+		var appErr *model.AppError
+		if errors.As(err, &appErr) {
+			c.JSON(appErr.HTTPStatus, model.NewErrorResponse(appErr, ""))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Processing failed"})
+		return
+	}
 
-	//asset, err := handler.userStorageManager.Upload(c, userID, file, header)
-	//if err != nil {
-	//	c.JSON(http.StatusInternalServerError, gin.H{"error": "Processing failed"})
-	//	return
-	//}
+	userStorage.UpdateCollections()
 
 	c.JSON(http.StatusCreated, asset)
 }
@@ -76,12 +75,18 @@ func (handler *AssetHandler) Update(c *gin.Context) {
 		return
 	}
 
-	var update common_models.AssetUpdate
+	var update model.AssetUpdate
 	if err := c.ShouldBindJSON(&update); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
+	// Anyone may hide assets, taking them out of the hidden album needs the PIN
+	if update.IsHidden != nil && !*update.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": storage.ErrHiddenToken.Error()})
+		return
+	}
+
 	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
@@ -89,7 +94,7 @@ func (handler *AssetHandler) Update(c *gin.Context) {
 
 	asset, err := userStorage.UpdateAsset(update)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(updateErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -111,25 +116,28 @@ func (handler *AssetHandler) UpdateAll(c *gin.Context) {
 		return
 	}
 
-	var update common_models.AssetUpdate
+	var update model.AssetUpdate
 	if err := c.ShouldBindJSON(&update); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
+	// Anyone may hide assets, taking them out of the hidden album needs the PIN
+	if update.IsHidden != nil && !*update.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": storage.ErrHiddenToken.Error()})
+		return
+	}
+
 	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 	}
 
-	allAssets := userStorage.GetAllAssets()
-	for _, asset := range allAssets {
-		update.AssetIds = append(update.AssetIds, asset.ID)
-	}
+	update.AssetIds = append(update.AssetIds, userStorage.GetAssetIds()...)
 
 	asset, err := userStorage.UpdateAsset(update)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(updateErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -142,6 +150,19 @@ func (handler *AssetHandler) UpdateAll(c *gin.Context) {
 	c.JSON(http.StatusCreated, asset)
 }
 
+func updateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrVillageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrInvalidUpdate),
+		errors.Is(err, storage.ErrInvalidLocation),
+		errors.Is(err, storage.ErrInvalidDateEdit):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (handler *AssetHandler) Get(c *gin.Context) {
 
 	userIDStr := c.Query("userID")
@@ -162,8 +183,8 @@ func (handler *AssetHandler) Get(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 	}
 
-	asset, exists := userStorage.GetAsset(id)
-	if !exists {
+	asset, exists := userStorage.GetAssetCopy(id)
+	if !exists || (asset.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID)) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
 		return
 	}
@@ -257,19 +278,24 @@ func (handler *AssetHandler) Filters(c *gin.Context) {
 		return
 	}
 
-	var with common_models.PHFetchOptions
+	var with model.AssetFetchOptions
 	if err := c.ShouldBindJSON(&with); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		fmt.Println("Invalid request")
 		return
 	}
 
+	if wantsHidden(with.PHFetchOptions) && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": storage.ErrHiddenToken.Error()})
+		return
+	}
+
 	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 	}
 
-	items, total, err := userStorage.FetchAssets(with)
+	items, total, err := userStorage.FilterAssets(with)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed user FetchAssets"})
 		return
@@ -308,21 +334,18 @@ func (handler *AssetHandler) OriginalDownload(c *gin.Context) {
 
 	//filepathTiny := filepath.Join("mahdi_abdolmaleki/assets", filename)
 
-	imgData, err := handler.userStorageManager.RepositoryGetOriginalImage(userID, filename)
+	ownerID, err := handler.fileOwner(c, userID, filename)
 	if err != nil {
-		c.AbortWithStatusJSON(404, gin.H{"error": "File not found"})
-	} else {
-		c.Data(http.StatusOK, "image/jpeg", imgData)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
 	}
 
-	//c.Header("Content-Type", "mage/jpeg")
-	//c.Header("Content-Encoding", "identity") // Disable compression
-	//c.Next()
-	//c.Header("Content-Length", fmt.Sprintf("%d", fileSize))
+	if ownerID == userID && handler.userStorageManager.IsHiddenFile(userID, filename) && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": storage.ErrHiddenToken.Error()})
+		return
+	}
 
-	//c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	//c.Header("Accept-Ranges", "bytes")
-	//c.File(filepath2)
+	serveOriginal(c, handler.userStorageManager, ownerID, filename)
 }
 
 func (handler *AssetHandler) TinyImageDownload(c *gin.Context) {
@@ -335,29 +358,489 @@ func (handler *AssetHandler) TinyImageDownload(c *gin.Context) {
 
 	filename := c.Param("filename")
 	if strings.Contains(filename, "png") {
-		imgData, err := handler.userStorageManager.RepositoryGetIcon(filename)
-		if err != nil {
-			fmt.Println("icon read error: ", err.Error())
-		} else {
-			c.Data(http.StatusOK, "image/png", imgData) // Adjust MIME type as necessary
-		}
+		serveIcon(c, handler.userStorageManager, filename)
 		return
 	}
 
 	//filepathTiny := filepath.Join("mahdi_abdolmaleki/thumbnails", filename)
 
-	imgData, err := handler.userStorageManager.RepositoryGetTinyImage(userID, filename)
+	ownerID, err := handler.fileOwner(c, userID, filename)
 	if err != nil {
-		c.AbortWithStatusJSON(404, gin.H{"error": "File not found"})
-	} else {
-		c.Data(http.StatusOK, "image/jpeg", imgData)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	if ownerID == userID && handler.userStorageManager.IsHiddenFile(userID, filename) && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": storage.ErrHiddenToken.Error()})
+		return
 	}
+
+	serveThumbnail(c, handler.userStorageManager, ownerID, filename)
 }
 
 func (handler *AssetHandler) IconDownload(c *gin.Context) {
-	filename := c.Param("filename")
-	imgData, err := handler.userStorageManager.RepositoryGetIcon(filename)
+	serveIcon(c, handler.userStorageManager, c.Param("filename"))
+}
+
+// fileOwner returns the user whose storage serves a download: the requester itself,
+// or the user who added the asset when it is requested through a shared album.
+func (handler *AssetHandler) fileOwner(c *gin.Context, userID int, filename string) (int, error) {
+
+	sharedAlbumID := c.Query("sharedAlbumID")
+	if sharedAlbumID == "" {
+		return userID, nil
+	}
+
+	id, err := strconv.Atoi(sharedAlbumID)
 	if err != nil {
-		c.Data(http.StatusOK, "image/png", imgData) // Adjust MIME type as necessary
+		return 0, err
+	}
+
+	return handler.userStorageManager.ResolveSharedAlbumFile(userID, id, filename)
+}
+
+func (handler *AssetHandler) Timeline(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var with model.AssetFetchOptions
+	if err := c.ShouldBindJSON(&with); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if wantsHidden(with.PHFetchOptions) && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": storage.ErrHiddenToken.Error()})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": userStorage.GetTimeline(with)})
+}
+
+func (handler *AssetHandler) GetVideo(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if asset, exists := userStorage.GetAssetCopy(assetID); exists && asset.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	video, err := userStorage.GetVideoMetadata(assetID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, video)
+}
+
+func (handler *AssetHandler) GetDetails(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if asset, exists := userStorage.GetAssetCopy(assetID); exists && asset.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	details, err := userStorage.GetAssetDetails(assetID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, details)
+}
+
+func (handler *AssetHandler) GetMetadata(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if asset, exists := userStorage.GetAssetCopy(assetID); exists && asset.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	metadata, err := userStorage.GetRawMetadata(assetID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
+func (handler *AssetHandler) Thumbnail(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	w, _ := strconv.Atoi(c.Query("w"))
+	h, _ := strconv.Atoi(c.Query("h"))
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	asset, exists := userStorage.GetAssetCopy(assetID)
+	if !exists || (asset.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+	cacheControl := derivativeCacheControl(c, asset)
+
+	format := handler.userStorageManager.NegotiateFormat(c.GetHeader("Accept"))
+
+	data, format, modTime, err := userStorage.GetThumbnail(assetID, w, h, c.Query("fit"), format)
+	if err != nil {
+		c.JSON(thumbnailErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Vary", "Accept")
+	serveContent(c, storage.FormatFilename("thumbnail.jpg", format), modTime, bytes.NewReader(data), storage.ContentETag(data), cacheControl)
+}
+
+// Keywords returns the keyword tree of the assets with their counts
+func (handler *AssetHandler) Keywords(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, userStorage.GetKeywordTree())
+}
+
+// GetEdit returns the edit stack of an asset
+func (handler *AssetHandler) GetEdit(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if asset, exists := userStorage.GetAssetCopy(assetID); exists && asset.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	edit, err := userStorage.GetAssetEdit(assetID)
+	if err != nil {
+		c.JSON(editErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, edit)
+}
+
+// Edit replaces or extends the edit stack of a photo
+func (handler *AssetHandler) Edit(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	var request model.AssetEditRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if asset, exists := userStorage.GetAssetCopy(assetID); exists && asset.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	edit, err := userStorage.EditAsset(assetID, request)
+	if err != nil {
+		c.JSON(editErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, edit)
+}
+
+// RevertEdit drops the edit stack of an asset
+func (handler *AssetHandler) RevertEdit(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if asset, exists := userStorage.GetAssetCopy(assetID); exists && asset.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	if err := userStorage.RevertAsset(assetID); err != nil {
+		c.JSON(editErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, "Reverted asset with id:"+strconv.Itoa(assetID))
+}
+
+// Rendered serves a photo with its edits applied, at full size or scaled to size
+func (handler *AssetHandler) Rendered(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	size, _ := strconv.Atoi(c.Query("size"))
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	asset, exists := userStorage.GetAssetCopy(assetID)
+	if !exists || (asset.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+	cacheControl := derivativeCacheControl(c, asset)
+
+	format := handler.userStorageManager.NegotiateFormat(c.GetHeader("Accept"))
+
+	data, format, modTime, err := userStorage.GetRendered(assetID, size, format)
+	if err != nil {
+		c.JSON(thumbnailErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Vary", "Accept")
+	serveContent(c, storage.FormatFilename("rendered.jpg", format), modTime, bytes.NewReader(data), storage.ContentETag(data), cacheControl)
+}
+
+func editErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrAssetNotFound), errors.Is(err, storage.ErrEditNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrInvalidEdit):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Resources lists the files of an asset: the original, and the paired video of a
+// Live Photo or the RAW of a RAW+JPEG pair
+func (handler *AssetHandler) Resources(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if asset, exists := userStorage.GetAssetCopy(assetID); exists && asset.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	resources, err := userStorage.GetResources(assetID)
+	if err != nil {
+		c.JSON(resourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resources)
+}
+
+// DownloadResource serves one file of an asset, the original when no resource type is given
+func (handler *AssetHandler) DownloadResource(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if asset, exists := userStorage.GetAssetCopy(assetID); exists && asset.IsHidden && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	filename, err := userStorage.ResourceFilename(assetID, c.Query("resource"))
+	if err != nil {
+		c.JSON(resourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	serveOriginal(c, handler.userStorageManager, userID, filename)
+}
+
+func resourceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrAssetNotFound), errors.Is(err, storage.ErrResourceNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func thumbnailErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrInvalidThumbnail):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrAssetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrUnsupportedFormat), errors.Is(err, storage.ErrVideoProcessing):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusInternalServerError
 	}
 }
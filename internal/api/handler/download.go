@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/storage"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Originals and thumbnails are revalidated with their strong ETag. Thumbnail names
+	// do not change with their content: posters and replaced originals rewrite them.
+	cacheControlOriginal  = "private, no-cache"
+	cacheControlThumbnail = "private, no-cache"
+	cacheControlVersioned = "private, max-age=31536000, immutable"
+	cacheControlIcon      = "public, max-age=31536000, immutable"
+)
+
+// derivativeCacheControl caches the thumbnail or rendering of an asset for good only
+// when its URL names the version it shows, ?v= the Unix modification time of the
+// asset, which edits change; other URLs are revalidated
+func derivativeCacheControl(c *gin.Context, asset *common_models.PHAsset) string {
+	if version := c.Query("v"); version != "" && version == strconv.FormatInt(asset.ModificationDate.Unix(), 10) {
+		return cacheControlVersioned
+	}
+	return cacheControlThumbnail
+}
+
+// serveContent answers with the semantics of http.ServeContent: byte ranges,
+// 304 on If-None-Match and If-Modified-Since, 412 on failed preconditions
+func serveContent(c *gin.Context, filename string, modTime time.Time, content io.ReadSeeker, etag string, cacheControl string) {
+	c.Header("Content-Type", storage.ContentType(filename))
+	c.Header("Cache-Control", cacheControl)
+	c.Header("ETag", etag)
+	http.ServeContent(c.Writer, c.Request, filename, modTime, content)
+}
+
+// serveOriginal serves an original of a user: videos are streamed from disk,
+// images go through the loader cache
+func serveOriginal(c *gin.Context, userStorageManager *storage.UserStorageManager, userID int, filename string) {
+
+	if storage.IsVideoFile(filename) {
+		file, stat, err := userStorageManager.OpenOriginal(userID, filename)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		defer file.Close()
+
+		etag, err := userStorageManager.FileETag(file, stat)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+
+		serveContent(c, filename, stat.ModTime(), file, etag, cacheControlOriginal)
+		return
+	}
+
+	stat, err := userStorageManager.OriginalInfo(userID, filename)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	data, err := userStorageManager.RepositoryGetOriginalImage(userID, filename)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	serveContent(c, filename, stat.ModTime(), bytes.NewReader(data), storage.ContentETag(data), cacheControlOriginal)
+}
+
+// serveThumbnail serves a pre-generated thumbnail, converted to WebP or AVIF when
+// the client accepts it and an encoder is available, made again when the photo is edited
+func serveThumbnail(c *gin.Context, userStorageManager *storage.UserStorageManager, userID int, filename string) {
+
+	c.Header("Vary", "Accept")
+
+	format := userStorageManager.NegotiateFormat(c.GetHeader("Accept"))
+	if format != storage.FormatJPEG || userStorageManager.IsEditedFile(userID, filename) {
+		data, modTime, err := userStorageManager.ConvertThumbnail(userID, filename, format)
+		if err == nil {
+			serveContent(c, storage.FormatFilename(filename, format), modTime, bytes.NewReader(data), storage.ContentETag(data), cacheControlThumbnail)
+			return
+		}
+		// The JPEG is still good
+		log.Printf("failed to convert thumbnail %s to %s: %v", filename, format, err)
+	}
+
+	stat, err := userStorageManager.ThumbnailInfo(userID, filename)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	data, err := userStorageManager.RepositoryGetTinyImage(userID, filename)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	serveContent(c, filename, stat.ModTime(), bytes.NewReader(data), storage.ContentETag(data), cacheControlThumbnail)
+}
+
+func serveIcon(c *gin.Context, userStorageManager *storage.UserStorageManager, filename string) {
+
+	data, err := userStorageManager.RepositoryGetIcon(filename)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	serveContent(c, filename, time.Time{}, bytes.NewReader(data), storage.ContentETag(data), cacheControlIcon)
+}
+
+// continuesDownload reports whether a request resumes or seeks in a download
+// rather than starting it
+func continuesDownload(c *gin.Context) bool {
+	rangeHeader := c.GetHeader("Range")
+	return rangeHeader != "" && !strings.HasPrefix(rangeHeader, "bytes=0-")
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"github.com/mahdi-cpp/photocloud_v2/internal/storage"
+	"net/http"
+)
+
+type HiddenHandler struct {
+	userStorageManager *storage.UserStorageManager
+}
+
+func NewHiddenHandler(userStorageManager *storage.UserStorageManager) *HiddenHandler {
+	return &HiddenHandler{
+		userStorageManager: userStorageManager,
+	}
+}
+
+func (handler *HiddenHandler) SetPin(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var request model.HiddenPinRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := handler.userStorageManager.SetHiddenPin(userID, request); err != nil {
+		c.JSON(hiddenErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, "hidden album pin saved")
+}
+
+func (handler *HiddenHandler) Unlock(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var request model.HiddenPinRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	unlock, err := handler.userStorageManager.UnlockHidden(userID, request.Pin)
+	if err != nil {
+		c.JSON(hiddenErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, unlock)
+}
+
+// GetAssets lists the Hidden album, the other fetch options still apply
+func (handler *HiddenHandler) GetAssets(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var with common_models.PHFetchOptions
+	if err := c.ShouldBindJSON(&with); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": storage.ErrHiddenToken.Error()})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	isHidden := true
+	with.IsHidden = &isHidden
+
+	items, total, err := userStorage.FetchAssets(with)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := common_models.PHFetchResult[*common_models.PHAsset]{
+		Items:  items,
+		Total:  total,
+		Limit:  with.FetchLimit,
+		Offset: with.FetchOffset,
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func hiddenErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrHiddenPinFormat):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrHiddenPinNotSet):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrHiddenPinWrong):
+		return http.StatusUnauthorized
+	case errors.Is(err, storage.ErrHiddenLocked):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/storage"
+	"net/http"
+	"time"
+)
+
+type MemoryHandler struct {
+	userStorageManager *storage.UserStorageManager
+}
+
+func NewMemoryHandler(userStorageManager *storage.UserStorageManager) *MemoryHandler {
+	return &MemoryHandler{
+		userStorageManager: userStorageManager,
+	}
+}
+
+func (handler *MemoryHandler) GetList(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": userStorage.GetMemories(time.Now())})
+}
+
+func (handler *MemoryHandler) GetAssets(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	items, err := userStorage.GetMemoryAssets(c.Param("id"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := common_models.PHFetchResult[*common_models.PHAsset]{
+		Items: items,
+		Total: len(items),
+		Limit: len(items),
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
 	"github.com/mahdi-cpp/photocloud_v2/internal/storage"
 	"net/http"
 )
@@ -24,19 +25,24 @@ func (handler *SearchHandler) Filters(c *gin.Context) {
 		return
 	}
 
-	var with common_models.PHFetchOptions
+	var with model.AssetFetchOptions
 	if err := c.ShouldBindJSON(&with); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		fmt.Println("Invalid request")
 		return
 	}
 
+	if wantsHidden(with.PHFetchOptions) && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": storage.ErrHiddenToken.Error()})
+		return
+	}
+
 	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 	}
 
-	items, total, err := userStorage.FetchAssets(with)
+	items, total, err := userStorage.FilterAssets(with)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
 		return
@@ -61,18 +67,23 @@ func (handler *SearchHandler) Search(c *gin.Context) {
 		return
 	}
 
-	var with common_models.PHFetchOptions
+	var with model.AssetFetchOptions
 	if err := c.ShouldBindJSON(&with); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
+	if wantsHidden(with.PHFetchOptions) && !hiddenUnlocked(c, handler.userStorageManager, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": storage.ErrHiddenToken.Error()})
+		return
+	}
+
 	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err})
 	}
 
-	items, total, err := userStorage.FetchAssets(with)
+	items, total, err := userStorage.FilterAssets(with)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
 		return
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"github.com/mahdi-cpp/photocloud_v2/internal/storage"
+	"net/http"
+	"strconv"
+)
+
+type ShareLinkHandler struct {
+	userStorageManager *storage.UserStorageManager
+}
+
+func NewShareLinkHandler(userStorageManager *storage.UserStorageManager) *ShareLinkHandler {
+	return &ShareLinkHandler{
+		userStorageManager: userStorageManager,
+	}
+}
+
+func (handler *ShareLinkHandler) Create(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var request model.ShareLinkRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	link, err := handler.userStorageManager.CreateShareLink(userID, request)
+	if err != nil {
+		c.JSON(shareLinkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, model.NewShareLinkInfo(link))
+}
+
+func (handler *ShareLinkHandler) Delete(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var item model.ShareLink
+	if err := c.ShouldBindJSON(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	err = handler.userStorageManager.DeleteShareLink(userID, item.ID)
+	if err != nil {
+		c.JSON(shareLinkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, "Delete item with id:"+strconv.Itoa(item.ID))
+}
+
+func (handler *ShareLinkHandler) GetList(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	items, err := handler.userStorageManager.GetShareLinks(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]model.ShareLinkInfo, len(items))
+	for i, item := range items {
+		result[i] = model.NewShareLinkInfo(item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+//----------------------------------------
+// Public routes, authorized by the link token instead of a userID
+
+func (handler *ShareLinkHandler) View(c *gin.Context) {
+
+	link, assets, err := handler.userStorageManager.ViewShareLink(c.Param("token"), c.Query("access"))
+	if err != nil {
+		c.JSON(shareLinkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	result := common_models.PHFetchResult[model.ShareLinkAsset]{
+		Items: assets,
+		Total: len(assets),
+	}
+
+	c.JSON(http.StatusOK, gin.H{"link": model.NewShareLinkInfo(link), "data": result})
+}
+
+func (handler *ShareLinkHandler) Unlock(c *gin.Context) {
+
+	var request struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	access, err := handler.userStorageManager.UnlockShareLink(c.Param("token"), request.Password)
+	if err != nil {
+		c.JSON(shareLinkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"access": access})
+}
+
+func (handler *ShareLinkHandler) TinyImageDownload(c *gin.Context) {
+
+	ownerID, err := handler.userStorageManager.ShareLinkThumbnail(c.Param("token"), c.Query("access"), c.Param("filename"))
+	if err != nil {
+		c.AbortWithStatusJSON(shareLinkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	serveThumbnail(c, handler.userStorageManager, ownerID, c.Param("filename"))
+}
+
+func (handler *ShareLinkHandler) OriginalDownload(c *gin.Context) {
+
+	ownerID, err := handler.userStorageManager.ShareLinkOriginal(c.Param("token"), c.Query("access"), c.Param("filename"), !continuesDownload(c))
+	if err != nil {
+		c.AbortWithStatusJSON(shareLinkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	serveOriginal(c, handler.userStorageManager, ownerID, c.Param("filename"))
+}
+
+func shareLinkErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrShareLinkNotFound), errors.Is(err, storage.ErrAssetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrShareLinkExpired):
+		return http.StatusGone
+	case errors.Is(err, storage.ErrShareLinkPassword):
+		return http.StatusUnauthorized
+	case errors.Is(err, storage.ErrShareLinkDownload):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
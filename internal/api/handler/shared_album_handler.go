@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/mahdi-cpp/api-go-pkg/common_models"
 	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
@@ -32,14 +33,9 @@ func (handler *SharedAlbumHandler) Create(c *gin.Context) {
 		return
 	}
 
-	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	item2, err := handler.userStorageManager.CreateSharedAlbum(userID, &item)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
-	}
-
-	item2, err := userStorage.SharedAlbumManager.Create(&item)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -60,28 +56,92 @@ func (handler *SharedAlbumHandler) Update(c *gin.Context) {
 		return
 	}
 
-	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	item2, err := handler.userStorageManager.UpdateSharedAlbum(userID, itemHandler)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
 
-	item, err := userStorage.SharedAlbumManager.Get(itemHandler.ID)
+	c.JSON(http.StatusCreated, item2)
+}
+
+func (handler *SharedAlbumHandler) Delete(c *gin.Context) {
+
+	userID, err := getUserId(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
 	}
 
-	model.UpdateSharedAlbum(item, itemHandler)
+	var item model.SharedAlbum
+	if err := c.ShouldBindJSON(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
 
-	item2, err := userStorage.SharedAlbumManager.Update(item)
+	err = handler.userStorageManager.DeleteSharedAlbum(userID, item.ID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, item2)
+	c.JSON(http.StatusCreated, "delete ok")
 }
 
-func (handler *SharedAlbumHandler) Delete(c *gin.Context) {
+func (handler *SharedAlbumHandler) GetList(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	items, err := handler.userStorageManager.GetSharedAlbums(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := common_models.PHCollectionList[*model.SharedAlbum]{
+		Collections: make([]*common_models.PHCollection[*model.SharedAlbum], len(items)),
+	}
+
+	for i, item := range items {
+		// Invited users see the album but not its assets until they join
+		assets, _, _ := handler.userStorageManager.FetchSharedAlbumAssets(userID, item.ID, 0, 6)
+		result.Collections[i] = &common_models.PHCollection[*model.SharedAlbum]{
+			Item:   item,
+			Assets: assets,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+func (handler *SharedAlbumHandler) Invite(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var invite model.SharedAlbumInvite
+	if err := c.ShouldBindJSON(&invite); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	item, err := handler.userStorageManager.InviteSharedAlbumMembers(userID, invite)
+	if err != nil {
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, item)
+}
+
+func (handler *SharedAlbumHandler) CreateLink(c *gin.Context) {
 
 	userID, err := getUserId(c)
 	if err != nil {
@@ -95,21 +155,39 @@ func (handler *SharedAlbumHandler) Delete(c *gin.Context) {
 		return
 	}
 
-	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	item2, err := handler.userStorageManager.CreateSharedAlbumLink(userID, item.ID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
 
-	err = userStorage.SharedAlbumManager.Delete(item.ID)
+	c.JSON(http.StatusCreated, gin.H{"id": item2.ID, "inviteToken": item2.InviteToken})
+}
+
+func (handler *SharedAlbumHandler) Join(c *gin.Context) {
+
+	userID, err := getUserId(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, "delete ok")
+	var join model.SharedAlbumJoin
+	if err := c.ShouldBindJSON(&join); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	item, err := handler.userStorageManager.JoinSharedAlbum(userID, join)
+	if err != nil {
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, item)
 }
 
-func (handler *SharedAlbumHandler) GetList(c *gin.Context) {
+func (handler *SharedAlbumHandler) RemoveMember(c *gin.Context) {
 
 	userID, err := getUserId(c)
 	if err != nil {
@@ -117,28 +195,227 @@ func (handler *SharedAlbumHandler) GetList(c *gin.Context) {
 		return
 	}
 
-	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	var request struct {
+		ID     int `json:"id"`
+		UserID int `json:"userID"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	item, err := handler.userStorageManager.RemoveSharedAlbumMember(userID, request.ID, request.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
 
-	items, err := userStorage.SharedAlbumManager.GetAll()
+	c.JSON(http.StatusCreated, item)
+}
+
+func (handler *SharedAlbumHandler) AddAssets(c *gin.Context) {
+
+	userID, err := getUserId(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
 		return
 	}
 
-	result := common_models.PHCollectionList[*model.SharedAlbum]{
-		Collections: make([]*common_models.PHCollection[*model.SharedAlbum], len(items)),
+	var request model.SharedAlbumAssets
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
 	}
 
-	for i, item := range items {
-		assets, _ := userStorage.SharedAlbumManager.GetItemAssets(item.ID)
-		result.Collections[i] = &common_models.PHCollection[*model.SharedAlbum]{
-			Item:   item,
-			Assets: assets,
-		}
+	item, err := handler.userStorageManager.AddSharedAlbumAssets(userID, request)
+	if err != nil {
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, item)
+}
+
+func (handler *SharedAlbumHandler) RemoveAssets(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var request model.SharedAlbumAssets
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	item, err := handler.userStorageManager.RemoveSharedAlbumAssets(userID, request)
+	if err != nil {
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, item)
+}
+
+func (handler *SharedAlbumHandler) GetAssets(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var request struct {
+		ID          int `json:"id"`
+		FetchOffset int `json:"fetchOffset"`
+		FetchLimit  int `json:"fetchLimit"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	items, total, err := handler.userStorageManager.FetchSharedAlbumAssets(userID, request.ID, request.FetchOffset, request.FetchLimit)
+	if err != nil {
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	result := common_models.PHFetchResult[*common_models.PHAsset]{
+		Items:  items,
+		Total:  total,
+		Limit:  request.FetchLimit,
+		Offset: request.FetchOffset,
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (handler *SharedAlbumHandler) GetFeedback(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var request model.SharedAlbumFeedbackRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	result, err := handler.userStorageManager.GetSharedAlbumFeedback(userID, request)
+	if err != nil {
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"data": result})
 }
+
+func (handler *SharedAlbumHandler) AddComment(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var request model.SharedAlbumFeedbackRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	item, err := handler.userStorageManager.AddSharedAlbumComment(userID, request)
+	if err != nil {
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, item)
+}
+
+func (handler *SharedAlbumHandler) DeleteComment(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var item model.SharedAlbumComment
+	if err := c.ShouldBindJSON(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	err = handler.userStorageManager.DeleteSharedAlbumComment(userID, item.ID)
+	if err != nil {
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, "delete ok")
+}
+
+func (handler *SharedAlbumHandler) AddReaction(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var request model.SharedAlbumFeedbackRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	item, err := handler.userStorageManager.SetSharedAlbumReaction(userID, request)
+	if err != nil {
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, item)
+}
+
+func (handler *SharedAlbumHandler) DeleteReaction(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var request model.SharedAlbumFeedbackRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	err = handler.userStorageManager.DeleteSharedAlbumReaction(userID, request)
+	if err != nil {
+		c.JSON(sharedAlbumErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, "delete ok")
+}
+
+func sharedAlbumErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrSharedAlbumNotFound), errors.Is(err, storage.ErrUserNotFound),
+		errors.Is(err, storage.ErrAssetNotFound), errors.Is(err, storage.ErrFeedbackNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrSharedAlbumForbidden), errors.Is(err, storage.ErrInvalidInviteToken):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
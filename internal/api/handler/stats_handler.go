@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mahdi-cpp/photocloud_v2/internal/storage"
+	"net/http"
+)
+
+type StatsHandler struct {
+	userStorageManager *storage.UserStorageManager
+}
+
+func NewStatsHandler(userStorageManager *storage.UserStorageManager) *StatsHandler {
+	return &StatsHandler{
+		userStorageManager: userStorageManager,
+	}
+}
+
+func (handler *StatsHandler) Get(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	stats, err := handler.userStorageManager.GetStats(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": stats})
+}
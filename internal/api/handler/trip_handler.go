@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/mahdi-cpp/api-go-pkg/common_models"
 	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
@@ -45,7 +46,7 @@ func (handler *TripHandler) Create(c *gin.Context) {
 	}
 
 	update := common_models.AssetUpdate{AssetIds: request.AssetIds, AddTrips: []int{newItem.ID}}
-	_, err = userStorage.UpdateAsset(update)
+	_, err = userStorage.UpdateAsset(model.AssetUpdate{AssetUpdate: update})
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -201,3 +202,124 @@ func (handler *TripHandler) GetCollectionListWith(c *gin.Context) {
 
 	c.JSON(http.StatusCreated, items)
 }
+
+func (handler *TripHandler) GetDetail(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var item model.Trip
+	if err := c.ShouldBindJSON(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	detail, err := userStorage.GetTripDetail(item.ID)
+	if err != nil {
+		c.JSON(tripErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": detail})
+}
+
+func (handler *TripHandler) GetSuggestions(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	items, err := userStorage.DetectTrips()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": items})
+}
+
+func (handler *TripHandler) AcceptSuggestion(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var request model.TripSuggestionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	trip, err := userStorage.AcceptTripSuggestion(request)
+	if err != nil {
+		c.JSON(tripErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, common_models.CollectionResponse{
+		ID:    trip.ID,
+		Title: trip.Title,
+	})
+}
+
+func (handler *TripHandler) DismissSuggestion(c *gin.Context) {
+
+	userID, err := getUserId(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "userID must be an integer"})
+		return
+	}
+
+	var request model.TripSuggestionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	userStorage, err := handler.userStorageManager.GetUserStorage(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := userStorage.DismissTripSuggestion(request); err != nil {
+		c.JSON(tripErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, "Dismiss suggestion with id:"+strconv.Itoa(request.ID))
+}
+
+func tripErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrTripNotFound), errors.Is(err, storage.ErrTripSuggestionNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadRequest
+	}
+}
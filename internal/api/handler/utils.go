@@ -3,6 +3,8 @@ package handler
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/storage"
 	"strconv"
 )
 
@@ -14,3 +16,18 @@ func getUserId(c *gin.Context) (int, error) {
 
 	return strconv.Atoi(userIDStr)
 }
+
+// hiddenUnlocked reports whether the request carries a valid hidden album token, in the
+// hiddenToken header or, for image urls, the hiddenToken query parameter
+func hiddenUnlocked(c *gin.Context, userStorageManager *storage.UserStorageManager, userID int) bool {
+	token := c.GetHeader("hiddenToken")
+	if token == "" {
+		token = c.Query("hiddenToken")
+	}
+	return token != "" && userStorageManager.VerifyHiddenToken(userID, token) == nil
+}
+
+// wantsHidden reports whether fetch options ask for hidden assets
+func wantsHidden(with common_models.PHFetchOptions) bool {
+	return with.IsHidden != nil && *with.IsHidden
+}
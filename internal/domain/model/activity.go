@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+func (a *Activity) GetID() int                      { return a.ID }
+func (a *Activity) SetID(id int)                    { a.ID = id }
+func (a *Activity) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *Activity) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *Activity) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *Activity) GetModificationDate() time.Time  { return a.ModificationDate }
+
+// Activity types
+const (
+	ActivityUpload      = "upload"
+	ActivityDelete      = "delete"
+	ActivityAlbumAdd    = "album_add"
+	ActivityAlbumRemove = "album_remove"
+	ActivityShare       = "share"
+	ActivityJoin        = "join"
+	ActivityComment     = "comment"
+)
+
+// Activity collection types, besides the share link types
+const (
+	ActivityCollectionAlbum       = "album"
+	ActivityCollectionSharedAlbum = "shared_album"
+)
+
+// Activity is an entry of the activity log of a user library. UserID is the user
+// who acted, which differs from the library owner for shared album activity.
+type Activity struct {
+	ID               int       `json:"id"`
+	UserID           int       `json:"userID"`
+	Type             string    `json:"type"`
+	CollectionType   string    `json:"collectionType,omitempty"`
+	CollectionID     int       `json:"collectionID,omitempty"`
+	AssetIds         []int     `json:"assetIds,omitempty"`
+	Text             string    `json:"text,omitempty"`
+	CreationDate     time.Time `json:"creationDate"`
+	ModificationDate time.Time `json:"modificationDate"`
+}
+
+// ActivityFetchOptions filters and pages the activity feed
+type ActivityFetchOptions struct {
+	CollectionType string `form:"collectionType"`
+	CollectionID   int    `form:"collectionID"`
+	FetchOffset    int    `form:"fetchOffset"`
+	FetchLimit     int    `form:"fetchLimit"`
+}
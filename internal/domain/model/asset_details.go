@@ -0,0 +1,77 @@
+package model
+
+import "time"
+
+func (a *AssetDetails) GetID() int                      { return a.ID }
+func (a *AssetDetails) SetID(id int)                    { a.ID = id }
+func (a *AssetDetails) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *AssetDetails) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *AssetDetails) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *AssetDetails) GetModificationDate() time.Time  { return a.ModificationDate }
+
+// Color labels, as Lightroom names them
+const (
+	LabelRed    = "red"
+	LabelYellow = "yellow"
+	LabelGreen  = "green"
+	LabelBlue   = "blue"
+	LabelPurple = "purple"
+)
+
+// AssetDetails holds what the server records about an asset beyond the shared
+// PHAsset model, one per asset.
+type AssetDetails struct {
+	ID                int             `json:"id"`
+	AssetID           int             `json:"assetID"`
+	Format            string          `json:"format"` // detected from the content: jpeg, heic, dng, mp4...
+	MimeType          string          `json:"mimeType"`
+	OriginalName      string          `json:"originalName,omitempty"` // file name given at upload
+	Preview           string          `json:"preview,omitempty"`      // embedded JPEG preview, in thumbnails/previews
+	Orientation       int             `json:"orientation"`            // EXIF orientation, 1 is upright
+	DisplayWidth      int             `json:"displayWidth"`           // size once the orientation is applied
+	DisplayHeight     int             `json:"displayHeight"`
+	Lens              string          `json:"lens,omitempty"`
+	FocalLength       float64         `json:"focalLength,omitempty"`  // millimeters
+	Aperture          float64         `json:"aperture,omitempty"`     // f-number
+	ExposureTime      string          `json:"exposureTime,omitempty"` // "1/250"
+	ISO               int             `json:"iso,omitempty"`
+	Flash             *bool           `json:"flash,omitempty"`
+	Altitude          *float64        `json:"altitude,omitempty"` // meters above sea level
+	TimeZone          string          `json:"timeZone,omitempty"` // offset of the capture date, "+03:30"
+	Caption           string          `json:"caption,omitempty"`
+	Keywords          []string        `json:"keywords,omitempty"`          // levels of hierarchical keywords joined with "/"
+	Rating            int             `json:"rating"`                      // stars, 0 to 5
+	Label             string          `json:"label,omitempty"`             // color label
+	ContentIdentifier string          `json:"contentIdentifier,omitempty"` // Live Photo, shared by the photo and its video
+	Resources         []AssetResource `json:"resources,omitempty"`         // files paired with the original
+	SyncDate          time.Time       `json:"syncDate"`                    // modification date of the asset last written to the XMP sidecar
+	CreationDate      time.Time       `json:"creationDate"`
+	ModificationDate  time.Time       `json:"modificationDate"`
+}
+
+// Resource types, as Photos names them
+const (
+	ResourcePhoto          = "photo"
+	ResourceVideo          = "video"
+	ResourcePairedVideo    = "pairedVideo"    // the video of a Live Photo
+	ResourceAlternatePhoto = "alternatePhoto" // the RAW of a RAW+JPEG pair
+)
+
+// AssetResource is a file of an asset. Live Photos and RAW+JPEG pairs are one asset
+// with the photo as original and the other file as a resource.
+type AssetResource struct {
+	Type         string `json:"type"`
+	Filename     string `json:"filename"` // in the assets folder, "<asset ID>_<type>.<ext>"
+	MimeType     string `json:"mimeType"`
+	Size         int64  `json:"size"`
+	OriginalName string `json:"originalName,omitempty"`
+}
+
+// RawMetadata lists every tag found in the original file of an asset
+type RawMetadata struct {
+	AssetID int                 `json:"assetID"`
+	EXIF    map[string]string   `json:"exif,omitempty"`
+	GPS     map[string]string   `json:"gps,omitempty"`
+	IPTC    map[string][]string `json:"iptc,omitempty"`
+	XMP     string              `json:"xmp,omitempty"`
+}
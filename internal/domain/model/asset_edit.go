@@ -0,0 +1,56 @@
+package model
+
+import "time"
+
+func (a *AssetEdit) GetID() int                      { return a.ID }
+func (a *AssetEdit) SetID(id int)                    { a.ID = id }
+func (a *AssetEdit) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *AssetEdit) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *AssetEdit) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *AssetEdit) GetModificationDate() time.Time  { return a.ModificationDate }
+
+// Edit operation types
+const (
+	EditCrop       = "crop"
+	EditRotate     = "rotate"
+	EditFlip       = "flip"
+	EditExposure   = "exposure"
+	EditContrast   = "contrast"
+	EditSaturation = "saturation"
+)
+
+// Flip directions
+const (
+	FlipHorizontal = "horizontal"
+	FlipVertical   = "vertical"
+)
+
+// AssetEdit is the edit stack of an asset. The operations are applied in order on
+// the upright image when thumbnails and the rendered version are made, the
+// original file is never changed.
+type AssetEdit struct {
+	ID               int             `json:"id"`
+	AssetID          int             `json:"assetID"`
+	Operations       []EditOperation `json:"operations"`
+	CreationDate     time.Time       `json:"creationDate"`
+	ModificationDate time.Time       `json:"modificationDate"`
+}
+
+// EditOperation is one step of an edit stack. Crop rectangles are normalized to
+// the size of the image at that step, with the origin at the top left corner.
+type EditOperation struct {
+	Type      string  `json:"type"`
+	X         float64 `json:"x,omitempty"` // crop
+	Y         float64 `json:"y,omitempty"`
+	Width     float64 `json:"width,omitempty"`
+	Height    float64 `json:"height,omitempty"`
+	Angle     int     `json:"angle,omitempty"`     // rotate: clockwise, a multiple of 90
+	Direction string  `json:"direction,omitempty"` // flip
+	Amount    float64 `json:"amount,omitempty"`    // exposure in stops, contrast and saturation from -1 to 1
+}
+
+// AssetEditRequest replaces the edit stack of an asset, or appends to it
+type AssetEditRequest struct {
+	Operations []EditOperation `json:"operations"`
+	Append     bool            `json:"append"`
+}
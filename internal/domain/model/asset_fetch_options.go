@@ -0,0 +1,21 @@
+package model
+
+import "github.com/mahdi-cpp/api-go-pkg/common_models"
+
+// AssetFetchOptions adds filters on the asset details to the shared fetch options,
+// the JSON of both is read from the same object
+type AssetFetchOptions struct {
+	common_models.PHFetchOptions
+	MinRating int      `json:"minRating,omitempty"`
+	Labels    []string `json:"labels,omitempty"`   // any of them
+	Keywords  []string `json:"keywords,omitempty"` // all of them, a keyword also matches the keywords under it
+}
+
+// KeywordNode is a level of the keyword tree. Count is the number of assets tagged
+// with the keyword or with one under it.
+type KeywordNode struct {
+	Name     string         `json:"name"`
+	Path     string         `json:"path"` // "Animals/Birds/Eagle"
+	Count    int            `json:"count"`
+	Children []*KeywordNode `json:"children,omitempty"`
+}
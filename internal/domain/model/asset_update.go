@@ -0,0 +1,35 @@
+package model
+
+import (
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"time"
+)
+
+// AssetUpdate adds metadata edits, ratings, labels and keywords to the shared asset
+// update, the JSON of both is read from the same object, and applies them to every
+// asset of AssetIds. Nil fields are left as they are.
+type AssetUpdate struct {
+	common_models.AssetUpdate
+
+	// Capture date: set it, or move it for a camera with a wrong clock. TimeZone
+	// keeps the moment and changes the local time, "+09:00" or "Asia/Tokyo".
+	CapturedDate *time.Time `json:"capturedDate,omitempty"`
+	Shift        string     `json:"shift,omitempty"` // a duration such as "-1h30m"
+	TimeZone     string     `json:"timeZone,omitempty"`
+
+	// Location: coordinates, or the location of a village
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	VillageID *int     `json:"villageID,omitempty"`
+
+	Caption *string `json:"caption,omitempty"`
+
+	// Tags, edited in bulk like the albums: Keywords replaces them all, otherwise
+	// AddKeywords and RemoveKeywords change them. Removing a keyword removes the
+	// keywords under it too, "Animals" removes "Animals/Birds/Eagle".
+	Rating         *int      `json:"rating,omitempty"` // 0 clears the rating
+	Label          *string   `json:"label,omitempty"`  // "" clears the label
+	Keywords       *[]string `json:"keywords,omitempty"`
+	AddKeywords    []string  `json:"addKeywords,omitempty"`
+	RemoveKeywords []string  `json:"removeKeywords,omitempty"`
+}
@@ -18,6 +18,7 @@ const (
 	ErrCodeInternal           = "INTERNAL_ERROR"
 	ErrCodeStorage            = "STORAGE_ERROR"
 	ErrCodeValidation         = "VALIDATION_ERROR"
+	ErrCodeUnsupportedMedia   = "UNSUPPORTED_MEDIA"
 	ErrCodeRateLimited        = "RATE_LIMITED"
 	ErrCodeNotImplemented     = "NOT_IMPLEMENTED"
 	ErrCodeServiceUnavailable = "SERVICE_UNAVAILABLE"
@@ -104,6 +105,13 @@ var (
 		HTTPStatus: http.StatusConflict,
 	}
 
+	// 415 Unsupported Media Type
+	ErrUnsupportedMedia = &AppError{
+		Code:       ErrCodeUnsupportedMedia,
+		Message:    "File format not supported",
+		HTTPStatus: http.StatusUnsupportedMediaType,
+	}
+
 	// 422 Unprocessable Entity
 	ErrValidationFailed = &AppError{
 		Code:       ErrCodeValidation,
@@ -159,6 +167,12 @@ var (
 		Message:    "Data corruption detected",
 		HTTPStatus: http.StatusInternalServerError,
 	}
+
+	ErrQuotaExceeded = &AppError{
+		Code:       ErrCodeStorage,
+		Message:    "Storage quota exceeded",
+		HTTPStatus: http.StatusInsufficientStorage,
+	}
 )
 
 // Error helpers
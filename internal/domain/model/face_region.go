@@ -0,0 +1,63 @@
+package model
+
+import "time"
+
+func (a *FaceRegion) GetID() int                      { return a.ID }
+func (a *FaceRegion) SetID(id int)                    { a.ID = id }
+func (a *FaceRegion) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *FaceRegion) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *FaceRegion) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *FaceRegion) GetModificationDate() time.Time  { return a.ModificationDate }
+
+// Face region sources
+const (
+	FaceSourceManual   = "manual"
+	FaceSourceDetected = "detected"
+)
+
+// FaceRegion is a face on an asset. The box is normalized to the image size with
+// the origin at the top left corner, PersonID is 0 for faces nobody has named.
+type FaceRegion struct {
+	ID               int       `json:"id"`
+	AssetID          int       `json:"assetID"`
+	PersonID         int       `json:"personID"`
+	X                float64   `json:"x"`
+	Y                float64   `json:"y"`
+	Width            float64   `json:"width"`
+	Height           float64   `json:"height"`
+	Source           string    `json:"source"`
+	Confidence       float64   `json:"confidence,omitempty"`
+	Embedding        []float64 `json:"embedding,omitempty"`
+	CreationDate     time.Time `json:"creationDate"`
+	ModificationDate time.Time `json:"modificationDate"`
+}
+
+// FaceRegionRequest tags a new region when ID is 0, otherwise retags or moves an existing one
+type FaceRegionRequest struct {
+	ID       int      `json:"id"`
+	AssetID  int      `json:"assetID"`
+	PersonID int      `json:"personID"`
+	X        *float64 `json:"x,omitempty"`
+	Y        *float64 `json:"y,omitempty"`
+	Width    *float64 `json:"width,omitempty"`
+	Height   *float64 `json:"height,omitempty"`
+}
+
+// PersonMerge moves everything of PersonIds into the person ID
+type PersonMerge struct {
+	ID        int   `json:"id"`
+	PersonIds []int `json:"personIds"`
+}
+
+// PersonSplit moves the assets of the person ID to PersonID, or to a new person named Title
+type PersonSplit struct {
+	ID       int    `json:"id"`
+	AssetIds []int  `json:"assetIds"`
+	PersonID int    `json:"personID,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+type PersonAvatar struct {
+	ID           int `json:"id"`
+	FaceRegionID int `json:"faceRegionID"`
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+func (a *HiddenPin) GetID() int                      { return a.ID }
+func (a *HiddenPin) SetID(id int)                    { a.ID = id }
+func (a *HiddenPin) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *HiddenPin) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *HiddenPin) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *HiddenPin) GetModificationDate() time.Time  { return a.ModificationDate }
+
+// HiddenPin protects the hidden album of a user, only the salted hash of the PIN is stored
+type HiddenPin struct {
+	ID               int        `json:"id"`
+	UserID           int        `json:"userID"`
+	PinHash          string     `json:"pinHash"`
+	PinSalt          string     `json:"pinSalt"`
+	FailedAttempts   int        `json:"failedAttempts"`
+	LockedUntil      *time.Time `json:"lockedUntil,omitempty"`
+	CreationDate     time.Time  `json:"creationDate"`
+	ModificationDate time.Time  `json:"modificationDate"`
+}
+
+// HiddenPinRequest sets the PIN, CurrentPin is required to change an existing one
+type HiddenPinRequest struct {
+	Pin        string `json:"pin"`
+	CurrentPin string `json:"currentPin,omitempty"`
+}
+
+// HiddenUnlock is the short-lived token that opens the hidden album
+type HiddenUnlock struct {
+	Token          string    `json:"token"`
+	ExpirationDate time.Time `json:"expirationDate"`
+}
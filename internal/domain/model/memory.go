@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Memory types
+const (
+	MemoryOnThisDay = "on_this_day"
+	MemoryMonthly   = "monthly_highlight"
+	MemoryTrip      = "trip_recap"
+	MemoryPerson    = "person"
+)
+
+// Memory is a generated collection of the best assets around a date, a trip or a
+// person. Memories are built from the library on request and are not stored, the
+// ID is derived from the type and the source so it stays the same between builds.
+type Memory struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	Subtitle  string    `json:"subtitle,omitempty"`
+	StartDate time.Time `json:"startDate"`
+	EndDate   time.Time `json:"endDate"`
+	Count     int       `json:"count"`
+	AssetIds  []int     `json:"assetIds"`
+}
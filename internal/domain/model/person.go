@@ -15,6 +15,8 @@ type Person struct {
 	Subtitle         string    `json:"subtitle,omitempty"`
 	Count            int       `json:"count"`
 	IsCollection     bool      `json:"isCollection"`
+	AvatarRegionID   int       `json:"avatarRegionID,omitempty"`
+	Avatar           string    `json:"avatar,omitempty"`
 	CreationDate     time.Time `json:"creationDate"`
 	ModificationDate time.Time `json:"modificationDate"`
 }
@@ -0,0 +1,55 @@
+package model
+
+import "time"
+
+func (a *PersonSuggestion) GetID() int                      { return a.ID }
+func (a *PersonSuggestion) SetID(id int)                    { a.ID = id }
+func (a *PersonSuggestion) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *PersonSuggestion) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *PersonSuggestion) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *PersonSuggestion) GetModificationDate() time.Time  { return a.ModificationDate }
+
+func (a *FaceScan) GetID() int                      { return a.ID }
+func (a *FaceScan) SetID(id int)                    { a.ID = id }
+func (a *FaceScan) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *FaceScan) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *FaceScan) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *FaceScan) GetModificationDate() time.Time  { return a.ModificationDate }
+
+// Person suggestion statuses
+const (
+	PersonSuggestionPending   = "pending"
+	PersonSuggestionConfirmed = "confirmed"
+	PersonSuggestionDismissed = "dismissed"
+)
+
+// PersonSuggestion is a cluster of detected faces nobody has named yet
+type PersonSuggestion struct {
+	ID               int       `json:"id"`
+	FaceRegionIds    []int     `json:"faceRegionIds"`
+	AssetIds         []int     `json:"assetIds"`
+	Status           string    `json:"status"`
+	PersonID         int       `json:"personID,omitempty"`
+	CreationDate     time.Time `json:"creationDate"`
+	ModificationDate time.Time `json:"modificationDate"`
+}
+
+// PersonSuggestionRequest confirms a suggestion into PersonID, or into a new person named Title
+type PersonSuggestionRequest struct {
+	ID       int    `json:"id"`
+	PersonID int    `json:"personID,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+// FaceScan records that face detection ran on an asset
+type FaceScan struct {
+	ID               int       `json:"id"`
+	AssetID          int       `json:"assetID"`
+	FaceCount        int       `json:"faceCount"`
+	CreationDate     time.Time `json:"creationDate"`
+	ModificationDate time.Time `json:"modificationDate"`
+}
+
+type FaceScanRequest struct {
+	AssetIds []int `json:"assetIds,omitempty"`
+}
@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+func (a *Quota) GetID() int                      { return a.ID }
+func (a *Quota) SetID(id int)                    { a.ID = id }
+func (a *Quota) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *Quota) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *Quota) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *Quota) GetModificationDate() time.Time  { return a.ModificationDate }
+
+// Quota limits the bytes on disk, originals and thumbnails, and the number of assets
+// of a user. A zero limit is unlimited. Override lets an admin lift the limits
+// without losing them.
+type Quota struct {
+	ID               int       `json:"id"`
+	UserID           int       `json:"userID"`
+	MaxBytes         int64     `json:"maxBytes"`
+	MaxAssets        int       `json:"maxAssets"`
+	Override         bool      `json:"override"`
+	CreationDate     time.Time `json:"creationDate"`
+	ModificationDate time.Time `json:"modificationDate"`
+}
+
+// QuotaRequest sets the quota of a user, fields left out keep their value
+type QuotaRequest struct {
+	UserID    int    `json:"userID"`
+	MaxBytes  *int64 `json:"maxBytes,omitempty"`
+	MaxAssets *int   `json:"maxAssets,omitempty"`
+	Override  *bool  `json:"override,omitempty"`
+}
+
+// QuotaUsage is the quota of a user with the current usage
+type QuotaUsage struct {
+	MaxBytes   int64 `json:"maxBytes"`
+	MaxAssets  int   `json:"maxAssets"`
+	Override   bool  `json:"override"`
+	UsedBytes  int64 `json:"usedBytes"` // originals and their resources, not thumbnails
+	UsedAssets int   `json:"usedAssets"`
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"time"
+)
+
+func (a *ShareLink) GetID() int                      { return a.ID }
+func (a *ShareLink) SetID(id int)                    { a.ID = id }
+func (a *ShareLink) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *ShareLink) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *ShareLink) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *ShareLink) GetModificationDate() time.Time  { return a.ModificationDate }
+
+// Share link types
+const (
+	ShareLinkAlbum  = "album"
+	ShareLinkTrip   = "trip"
+	ShareLinkAssets = "assets"
+)
+
+type ShareLink struct {
+	ID               int        `json:"id"`
+	UserID           int        `json:"userID"`
+	Token            string     `json:"token"`
+	Title            string     `json:"title"`
+	Type             string     `json:"type"`
+	CollectionID     int        `json:"collectionID,omitempty"`
+	AssetIds         []int      `json:"assetIds,omitempty"`
+	ExpirationDate   *time.Time `json:"expirationDate,omitempty"`
+	PasswordHash     string     `json:"passwordHash,omitempty"`
+	PasswordSalt     string     `json:"passwordSalt,omitempty"`
+	AllowDownload    bool       `json:"allowDownload"`
+	ViewCount        int        `json:"viewCount"`
+	DownloadCount    int        `json:"downloadCount"`
+	LastViewDate     *time.Time `json:"lastViewDate,omitempty"`
+	CreationDate     time.Time  `json:"creationDate"`
+	ModificationDate time.Time  `json:"modificationDate"`
+}
+
+// ShareLinkRequest creates a share link, ExpiresIn is in hours and zero means no expiry
+type ShareLinkRequest struct {
+	Title         string `json:"title,omitempty"`
+	Type          string `json:"type"`
+	CollectionID  int    `json:"collectionID,omitempty"`
+	AssetIds      []int  `json:"assetIds,omitempty"`
+	ExpiresIn     int    `json:"expiresIn,omitempty"`
+	Password      string `json:"password,omitempty"`
+	AllowDownload bool   `json:"allowDownload"`
+}
+
+// ShareLinkInfo is the API view of a ShareLink without its password hash
+type ShareLinkInfo struct {
+	ID             int        `json:"id"`
+	Token          string     `json:"token"`
+	Title          string     `json:"title"`
+	Type           string     `json:"type"`
+	CollectionID   int        `json:"collectionID,omitempty"`
+	AssetIds       []int      `json:"assetIds,omitempty"`
+	ExpirationDate *time.Time `json:"expirationDate,omitempty"`
+	HasPassword    bool       `json:"hasPassword"`
+	AllowDownload  bool       `json:"allowDownload"`
+	ViewCount      int        `json:"viewCount"`
+	DownloadCount  int        `json:"downloadCount"`
+	LastViewDate   *time.Time `json:"lastViewDate,omitempty"`
+	CreationDate   time.Time  `json:"creationDate"`
+}
+
+func NewShareLinkInfo(link *ShareLink) ShareLinkInfo {
+	return ShareLinkInfo{
+		ID:             link.ID,
+		Token:          link.Token,
+		Title:          link.Title,
+		Type:           link.Type,
+		CollectionID:   link.CollectionID,
+		AssetIds:       link.AssetIds,
+		ExpirationDate: link.ExpirationDate,
+		HasPassword:    link.PasswordHash != "",
+		AllowDownload:  link.AllowDownload,
+		ViewCount:      link.ViewCount,
+		DownloadCount:  link.DownloadCount,
+		LastViewDate:   link.LastViewDate,
+		CreationDate:   link.CreationDate,
+	}
+}
+
+// ShareLinkAsset is what a share link shows of an asset: the files the link serves,
+// without the location, collections, camera or other data of the library
+type ShareLinkAsset struct {
+	ID           int                     `json:"id"`
+	Thumbnails   []string                `json:"thumbnails"`
+	Original     string                  `json:"original,omitempty"` // when the link allows downloads
+	Width        int                     `json:"width"`
+	Height       int                     `json:"height"`
+	MediaType    common_models.MediaType `json:"mediaType"`
+	CapturedDate *time.Time              `json:"capturedDate,omitempty"`
+}
+
+// IsExpired reports whether the link is past its expiration date
+func (a *ShareLink) IsExpired() bool {
+	return a.ExpirationDate != nil && time.Now().After(*a.ExpirationDate)
+}
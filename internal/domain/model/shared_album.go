@@ -9,15 +9,50 @@ func (a *SharedAlbum) SetModificationDate(t time.Time) { a.ModificationDate = t
 func (a *SharedAlbum) GetCreationDate() time.Time      { return a.CreationDate }
 func (a *SharedAlbum) GetModificationDate() time.Time  { return a.ModificationDate }
 
+// Shared album member roles
+const (
+	SharedRoleOwner       = "owner"
+	SharedRoleContributor = "contributor"
+	SharedRoleViewer      = "viewer"
+)
+
+// Shared album member status
+const (
+	SharedMemberInvited = "invited"
+	SharedMemberJoined  = "joined"
+)
+
 type SharedAlbum struct {
-	ID               int       `json:"id"`
-	Name             string    `json:"name"`
-	AlbumType        string    `json:"albumType"`
-	Count            int       `json:"count"`
-	IsCollection     bool      `json:"isCollection"`
-	IsHidden         bool      `json:"isHidden"`
-	CreationDate     time.Time `json:"creationDate"`
-	ModificationDate time.Time `json:"modificationDate"`
+	ID               int                 `json:"id"`
+	OwnerID          int                 `json:"ownerID"`
+	Name             string              `json:"name"`
+	AlbumType        string              `json:"albumType"`
+	Count            int                 `json:"count"`
+	CommentCount     int                 `json:"commentCount"`
+	ReactionCount    int                 `json:"reactionCount"`
+	IsCollection     bool                `json:"isCollection"`
+	IsHidden         bool                `json:"isHidden"`
+	InviteToken      string              `json:"inviteToken,omitempty"` // sent to the owner only
+	Members          []SharedAlbumMember `json:"members"`
+	Assets           []SharedAlbumAsset  `json:"assets"`
+	CreationDate     time.Time           `json:"creationDate"`
+	ModificationDate time.Time           `json:"modificationDate"`
+}
+
+// SharedAlbumMember is a user invited to or joined in a shared album
+type SharedAlbumMember struct {
+	UserID      int       `json:"userID"`
+	Role        string    `json:"role"`
+	Status      string    `json:"status"`
+	InvitedDate time.Time `json:"invitedDate"`
+	JoinedDate  time.Time `json:"joinedDate,omitempty"`
+}
+
+// SharedAlbumAsset references an asset in the library of the user who added it
+type SharedAlbumAsset struct {
+	UserID    int       `json:"userID"`
+	AssetID   int       `json:"assetID"`
+	AddedDate time.Time `json:"addedDate"`
 }
 
 type SharedAlbumHandler struct {
@@ -28,6 +63,27 @@ type SharedAlbumHandler struct {
 	IsHidden     *bool  `json:"isHidden,omitempty"`
 }
 
+// SharedAlbumInvite invites users to a shared album or changes their role
+type SharedAlbumInvite struct {
+	ID      int    `json:"id"`
+	UserIDs []int  `json:"userIDs"`
+	Role    string `json:"role,omitempty"`
+}
+
+// SharedAlbumJoin accepts an invite, either by album id or by invite link token
+type SharedAlbumJoin struct {
+	ID          int    `json:"id,omitempty"`
+	InviteToken string `json:"inviteToken,omitempty"`
+}
+
+// SharedAlbumAssets adds or removes assets of the requesting user. Asset IDs are
+// per user, the owner removes the assets of a member by giving their UserID.
+type SharedAlbumAssets struct {
+	ID       int   `json:"id"`
+	UserID   int   `json:"userID,omitempty"`
+	AssetIds []int `json:"assetIds"`
+}
+
 func UpdateSharedAlbum(album *SharedAlbum, handler SharedAlbumHandler) *SharedAlbum {
 
 	if handler.Name != "" {
@@ -48,3 +104,41 @@ func UpdateSharedAlbum(album *SharedAlbum, handler SharedAlbumHandler) *SharedAl
 
 	return album
 }
+
+// GetMember returns the member entry of a user, the owner is always a joined member
+func (a *SharedAlbum) GetMember(userID int) (*SharedAlbumMember, bool) {
+	if userID == a.OwnerID {
+		return &SharedAlbumMember{UserID: userID, Role: SharedRoleOwner, Status: SharedMemberJoined, JoinedDate: a.CreationDate}, true
+	}
+	for i := range a.Members {
+		if a.Members[i].UserID == userID {
+			return &a.Members[i], true
+		}
+	}
+	return nil, false
+}
+
+// CanView reports whether a user has joined the album
+func (a *SharedAlbum) CanView(userID int) bool {
+	member, ok := a.GetMember(userID)
+	return ok && member.Status == SharedMemberJoined
+}
+
+// CanContribute reports whether a user may add their own assets to the album
+func (a *SharedAlbum) CanContribute(userID int) bool {
+	member, ok := a.GetMember(userID)
+	if !ok || member.Status != SharedMemberJoined {
+		return false
+	}
+	return member.Role == SharedRoleOwner || member.Role == SharedRoleContributor
+}
+
+// HasAsset reports whether an asset of a user is part of the album
+func (a *SharedAlbum) HasAsset(userID int, assetID int) bool {
+	for _, item := range a.Assets {
+		if item.UserID == userID && item.AssetID == assetID {
+			return true
+		}
+	}
+	return false
+}
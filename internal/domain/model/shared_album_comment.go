@@ -0,0 +1,57 @@
+package model
+
+import "time"
+
+func (a *SharedAlbumComment) GetID() int                      { return a.ID }
+func (a *SharedAlbumComment) SetID(id int)                    { a.ID = id }
+func (a *SharedAlbumComment) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *SharedAlbumComment) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *SharedAlbumComment) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *SharedAlbumComment) GetModificationDate() time.Time  { return a.ModificationDate }
+
+func (a *SharedAlbumReaction) GetID() int                      { return a.ID }
+func (a *SharedAlbumReaction) SetID(id int)                    { a.ID = id }
+func (a *SharedAlbumReaction) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *SharedAlbumReaction) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *SharedAlbumReaction) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *SharedAlbumReaction) GetModificationDate() time.Time  { return a.ModificationDate }
+
+// SharedAlbumComment is a comment of a member on an asset of a shared album
+type SharedAlbumComment struct {
+	ID               int       `json:"id"`
+	SharedAlbumID    int       `json:"sharedAlbumID"`
+	AssetUserID      int       `json:"assetUserID"`
+	AssetID          int       `json:"assetID"`
+	UserID           int       `json:"userID"`
+	Text             string    `json:"text"`
+	CreationDate     time.Time `json:"creationDate"`
+	ModificationDate time.Time `json:"modificationDate"`
+}
+
+// SharedAlbumReaction is an emoji reaction of a member on an asset of a shared album,
+// each member has at most one reaction per asset
+type SharedAlbumReaction struct {
+	ID               int       `json:"id"`
+	SharedAlbumID    int       `json:"sharedAlbumID"`
+	AssetUserID      int       `json:"assetUserID"`
+	AssetID          int       `json:"assetID"`
+	UserID           int       `json:"userID"`
+	Emoji            string    `json:"emoji"`
+	CreationDate     time.Time `json:"creationDate"`
+	ModificationDate time.Time `json:"modificationDate"`
+}
+
+// SharedAlbumFeedbackRequest addresses the comments and reactions of a shared album asset
+type SharedAlbumFeedbackRequest struct {
+	ID          int    `json:"id"`
+	AssetUserID int    `json:"assetUserID"`
+	AssetID     int    `json:"assetID"`
+	Text        string `json:"text,omitempty"`
+	Emoji       string `json:"emoji,omitempty"`
+}
+
+// SharedAlbumFeedback lists the comments and reactions of a shared album asset
+type SharedAlbumFeedback struct {
+	Comments  []*SharedAlbumComment  `json:"comments"`
+	Reactions []*SharedAlbumReaction `json:"reactions"`
+}
@@ -12,6 +12,44 @@ type StorageStats struct {
 	ThumbnailsGen int   `json:"thumbnailsGenerated"`
 }
 
+// LibraryStats is the statistics dashboard of a user library. Hidden assets are not counted.
+type LibraryStats struct {
+	TotalAssets    int            `json:"totalAssets"`
+	MediaTypes     map[string]int `json:"mediaTypes"`
+	Years          []*YearCount   `json:"years"`
+	TopCameras     []*CameraCount `json:"topCameras"`
+	OriginalBytes  int64          `json:"originalBytes"`
+	ThumbnailBytes int64          `json:"thumbnailBytes"`
+	ThumbnailCount int            `json:"thumbnailCount"`
+	Uploads24h     int            `json:"uploads24h"`
+	Uploads7d      int            `json:"uploads7d"`
+	Caches         []*CacheStats  `json:"caches"`
+	Index          IndexStatus    `json:"index"`
+	Quota          QuotaUsage     `json:"quota"`
+}
+
+type YearCount struct {
+	Year  int `json:"year"`
+	Count int `json:"count"`
+}
+
+type CameraCount struct {
+	CameraMake  string `json:"cameraMake"`
+	CameraModel string `json:"cameraModel"`
+	Count       int    `json:"count"`
+}
+
+// CacheStats is the hit rate of a cache. Estimated rates come from following the
+// requests of a loader that does not report its hits, not from the loader itself.
+type CacheStats struct {
+	Name      string  `json:"name"`
+	Size      int     `json:"size"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	HitRate   float64 `json:"hitRate"`
+	Estimated bool    `json:"estimated"`
+}
+
 // IndexStatus represents index health information
 type IndexStatus struct {
 	LastRebuild       time.Time `json:"lastRebuild"`
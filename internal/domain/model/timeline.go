@@ -0,0 +1,25 @@
+package model
+
+// Timeline is the number of assets per year, month and day, newest first.
+// Assets are placed by their captured date, or their creation date without one.
+type Timeline struct {
+	Total int             `json:"total"`
+	Years []*TimelineYear `json:"years"`
+}
+
+type TimelineYear struct {
+	Year   int              `json:"year"`
+	Count  int              `json:"count"`
+	Months []*TimelineMonth `json:"months"`
+}
+
+type TimelineMonth struct {
+	Month int            `json:"month"`
+	Count int            `json:"count"`
+	Days  []*TimelineDay `json:"days"`
+}
+
+type TimelineDay struct {
+	Day   int `json:"day"`
+	Count int `json:"count"`
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"time"
+)
+
+// TripDetail is the itinerary of a trip: its assets grouped by day and the route
+// travelled between the geotagged ones.
+type TripDetail struct {
+	Trip      *Trip          `json:"trip"`
+	StartDate time.Time      `json:"startDate"`
+	EndDate   time.Time      `json:"endDate"`
+	Distance  float64        `json:"distance"` // kilometers
+	Places    []string       `json:"places"`
+	Days      []TripDay      `json:"days"`
+	Route     GeoJSONFeature `json:"route"`
+}
+
+type TripDay struct {
+	Date     string                   `json:"date"` // 2006-01-02
+	Distance float64                  `json:"distance"`
+	Places   []string                 `json:"places"`
+	Assets   []*common_models.PHAsset `json:"assets"`
+}
+
+// GeoJSONFeature is a GeoJSON Feature, positions are [longitude, latitude]. The
+// geometry is null when there is no position.
+type GeoJSONFeature struct {
+	Type       string           `json:"type"`
+	Geometry   *GeoJSONGeometry `json:"geometry"`
+	Properties map[string]any   `json:"properties"`
+}
+
+// GeoJSONGeometry is a Point, whose coordinates are one position, or a LineString
+// of two positions or more
+type GeoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
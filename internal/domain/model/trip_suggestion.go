@@ -0,0 +1,44 @@
+package model
+
+import "time"
+
+func (a *TripSuggestion) GetID() int                      { return a.ID }
+func (a *TripSuggestion) SetID(id int)                    { a.ID = id }
+func (a *TripSuggestion) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *TripSuggestion) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *TripSuggestion) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *TripSuggestion) GetModificationDate() time.Time  { return a.ModificationDate }
+
+// Trip suggestion statuses
+const (
+	TripSuggestionPending   = "pending"
+	TripSuggestionAccepted  = "accepted"
+	TripSuggestionDismissed = "dismissed"
+)
+
+// TripTypeDetected marks trips created from an accepted suggestion
+const TripTypeDetected = "detected"
+
+// TripSuggestion is a group of assets taken away from home that the detector
+// proposes as a trip. Dismissed suggestions are kept so they are not proposed again.
+type TripSuggestion struct {
+	ID               int       `json:"id"`
+	Title            string    `json:"title"`
+	Subtitle         string    `json:"subtitle,omitempty"`
+	Place            string    `json:"place,omitempty"`
+	Latitude         float64   `json:"latitude"`
+	Longitude        float64   `json:"longitude"`
+	Distance         float64   `json:"distance"` // kilometers from home
+	StartDate        time.Time `json:"startDate"`
+	EndDate          time.Time `json:"endDate"`
+	AssetIds         []int     `json:"assetIds"`
+	Status           string    `json:"status"`
+	TripID           int       `json:"tripID,omitempty"`
+	CreationDate     time.Time `json:"creationDate"`
+	ModificationDate time.Time `json:"modificationDate"`
+}
+
+type TripSuggestionRequest struct {
+	ID    int    `json:"id"`
+	Title string `json:"title,omitempty"`
+}
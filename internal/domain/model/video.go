@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+func (a *VideoMetadata) GetID() int                      { return a.ID }
+func (a *VideoMetadata) SetID(id int)                    { a.ID = id }
+func (a *VideoMetadata) SetCreationDate(t time.Time)     { a.CreationDate = t }
+func (a *VideoMetadata) SetModificationDate(t time.Time) { a.ModificationDate = t }
+func (a *VideoMetadata) GetCreationDate() time.Time      { return a.CreationDate }
+func (a *VideoMetadata) GetModificationDate() time.Time  { return a.ModificationDate }
+
+// VideoMetadata is read from the MP4/MOV container of a video asset. Width and
+// Height are the displayed size, after Rotation.
+type VideoMetadata struct {
+	ID               int        `json:"id"`
+	AssetID          int        `json:"assetID"`
+	Duration         float64    `json:"duration"` // seconds
+	Width            int        `json:"width"`
+	Height           int        `json:"height"`
+	Rotation         int        `json:"rotation"`
+	VideoCodec       string     `json:"videoCodec,omitempty"`
+	AudioCodec       string     `json:"audioCodec,omitempty"`
+	CapturedDate     *time.Time `json:"capturedDate,omitempty"`
+	Latitude         *float64   `json:"latitude,omitempty"`
+	Longitude        *float64   `json:"longitude,omitempty"`
+	Poster           string     `json:"poster,omitempty"` // thumbnail file name
+	CreationDate     time.Time  `json:"creationDate"`
+	ModificationDate time.Time  `json:"modificationDate"`
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"log"
+	"sort"
+)
+
+// recordActivity appends an entry to the activity log of the library
+func (userStorage *UserStorage) recordActivity(activity *model.Activity) {
+	if _, err := userStorage.ActivityManager.Create(activity); err != nil {
+		log.Printf("failed to record %s activity for user %d: %v", activity.Type, userStorage.user.ID, err)
+	}
+}
+
+// recordActivity appends an entry to the activity log of the user owning the library
+func (us *UserStorageManager) recordActivity(ownerID int, activity *model.Activity) {
+	userStorage, err := us.GetUserStorage(nil, ownerID)
+	if err != nil {
+		log.Printf("failed to record %s activity for user %d: %v", activity.Type, ownerID, err)
+		return
+	}
+	userStorage.recordActivity(activity)
+}
+
+// recordSharedAlbumActivity logs an action of a member in the activity of the album owner
+func (us *UserStorageManager) recordSharedAlbumActivity(album *model.SharedAlbum, userID int, activityType string, assetIds []int, text string) {
+	us.recordActivity(album.OwnerID, &model.Activity{
+		UserID:         userID,
+		Type:           activityType,
+		CollectionType: model.ActivityCollectionSharedAlbum,
+		CollectionID:   album.ID,
+		AssetIds:       assetIds,
+		Text:           text,
+	})
+}
+
+// FetchActivity returns the activity feed of a user: everything in their own library,
+// plus the activity of the shared albums of other users they have joined. Newest first.
+func (us *UserStorageManager) FetchActivity(userID int, with model.ActivityFetchOptions) ([]*model.Activity, int, error) {
+
+	userStorage, err := us.GetUserStorage(nil, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	matches, err := userStorage.ActivityManager.GetList(func(a *model.Activity) bool {
+		return activityMatches(a, with)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	us.sharedMu.Lock()
+	albums, err := us.sharedAlbumManager.GetList(func(a *model.SharedAlbum) bool {
+		return a.OwnerID != userID && a.CanView(userID)
+	})
+	for i := range albums {
+		albums[i] = cloneSharedAlbum(albums[i])
+	}
+	us.sharedMu.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, album := range albums {
+		if with.CollectionType != "" && (with.CollectionType != model.ActivityCollectionSharedAlbum || with.CollectionID != album.ID) {
+			continue
+		}
+
+		ownerStorage, err := us.GetUserStorage(nil, album.OwnerID)
+		if err != nil {
+			continue
+		}
+
+		items, err := ownerStorage.ActivityManager.GetList(func(a *model.Activity) bool {
+			return a.CollectionType == model.ActivityCollectionSharedAlbum && a.CollectionID == album.ID
+		})
+		if err != nil {
+			continue
+		}
+		matches = append(matches, items...)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].CreationDate.After(matches[j].CreationDate)
+	})
+
+	total := len(matches)
+
+	start := with.FetchOffset
+	if start < 0 {
+		start = 0
+	}
+	if start > total {
+		start = total
+	}
+	end := start + with.FetchLimit
+	if end > total || with.FetchLimit <= 0 {
+		end = total
+	}
+
+	return matches[start:end], total, nil
+}
+
+func activityMatches(activity *model.Activity, with model.ActivityFetchOptions) bool {
+	if with.CollectionType != "" && activity.CollectionType != with.CollectionType {
+		return false
+	}
+	if with.CollectionID != 0 && activity.CollectionID != with.CollectionID {
+		return false
+	}
+	return true
+}
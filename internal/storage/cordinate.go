@@ -1 +1,28 @@
 package storage
+
+import (
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"math"
+)
+
+const earthRadius = 6371.0 // kilometers
+
+// haversineDistance returns the great-circle distance between two points in kilometers
+func haversineDistance(a Coordinate, b Coordinate) float64 {
+	dLat := (b.Latitude - a.Latitude) * math.Pi / 180
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a.Latitude*math.Pi/180)*math.Cos(b.Latitude*math.Pi/180)*
+			math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return earthRadius * 2 * math.Atan2(math.Sqrt(h), math.Sqrt(1-h))
+}
+
+// assetCoordinate returns the location of an asset, ok is false for assets without GPS
+func assetCoordinate(asset *common_models.PHAsset) (Coordinate, bool) {
+	if asset.Place.IsEmpty() || (asset.Place.Latitude == 0 && asset.Place.Longitude == 0) {
+		return Coordinate{}, false
+	}
+	return Coordinate{Latitude: asset.Place.Latitude, Longitude: asset.Place.Longitude}, true
+}
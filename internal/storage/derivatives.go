@@ -0,0 +1,452 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/mahdi-cpp/photocloud_v2/config"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Thumbnail fits
+const (
+	FitCover   = "cover"   // fills the box, cropping the overflow
+	FitContain = "contain" // fits in the box, keeping the whole image
+)
+
+// derivativeCache is the LRU index of the thumbnails generated on request, in all
+// formats. The files live in thumbnails/derivatives, the least recently used are
+// deleted when the total size goes over config.DerivativeCacheBytes.
+type derivativeCache struct {
+	mu       sync.Mutex
+	dir      string
+	capacity int64
+	size     int64
+	order    *list.List // of *derivativeEntry, most recently used first
+	entries  map[string]*list.Element
+	loaded   bool
+	inflight map[string]*derivativeCall
+	versions map[int]int // by asset, bumped by removeAsset so renders started before are not stored
+	hits     int64
+	misses   int64
+}
+
+type derivativeEntry struct {
+	name string
+	size int64
+}
+
+// derivativeCall coalesces the concurrent requests for the same derivative
+type derivativeCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newDerivativeCache(dir string, capacity int64) *derivativeCache {
+	return &derivativeCache{
+		dir:      dir,
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*derivativeCall),
+		versions: make(map[int]int),
+	}
+}
+
+// GetThumbnail returns a thumbnail of the asset of w by h pixels in the given format,
+// generating it on the first request. Sizes are restricted to config.ThumbnailSizes,
+// formats without an encoder fall back to JPEG. The format served is returned.
+func (userStorage *UserStorage) GetThumbnail(assetID int, w int, h int, fit string, format string) ([]byte, string, time.Time, error) {
+
+	if w == 0 {
+		w = h
+	}
+	if h == 0 {
+		h = w
+	}
+	if fit == "" {
+		fit = FitCover
+	}
+	if !slices.Contains(config.ThumbnailSizes, w) || !slices.Contains(config.ThumbnailSizes, h) || (fit != FitCover && fit != FitContain) {
+		return nil, "", time.Time{}, ErrInvalidThumbnail
+	}
+
+	if _, exists := userStorage.GetAssetCopy(assetID); !exists {
+		return nil, "", time.Time{}, ErrAssetNotFound
+	}
+
+	encoder, exists := userStorage.encoders[format]
+	if !exists {
+		format, encoder = FormatJPEG, userStorage.encoders[FormatJPEG]
+	}
+
+	name := fmt.Sprintf("%d_%dx%d_%s%s", assetID, w, h, fit, formatExtensions[format])
+
+	data, modTime, err := userStorage.derivatives.get(assetID, name, func() ([]byte, error) {
+		img, err := userStorage.renderThumbnail(assetID, w, h, fit)
+		if err != nil {
+			return nil, err
+		}
+		return encodeThumbnail(userStorage.maintenanceCtx, encoder, img)
+	}, userStorage.addThumbnailBytes)
+
+	return data, format, modTime, err
+}
+
+// ConvertThumbnail returns a pre-generated thumbnail in another format than JPEG,
+// converted on the first request and cached with the other derivatives. Thumbnails
+// of edited photos are made again from the original with the edits, in any format.
+func (us *UserStorageManager) ConvertThumbnail(userID int, filename string, format string) ([]byte, time.Time, error) {
+
+	userStorage, err := us.GetUserStorage(nil, userID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if filename == "" || filepath.Base(filename) != filename {
+		return nil, time.Time{}, ErrAssetNotFound
+	}
+
+	assetID, size, ofAsset := thumbnailAsset(filename)
+	edited := ofAsset && userStorage.isEdited(assetID)
+
+	encoder, exists := userStorage.encoders[format]
+	if !exists || (format == FormatJPEG && !edited) {
+		return nil, time.Time{}, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	// Named after the asset so they are dropped with its other derivatives;
+	// avatars change name instead
+	name := "thumb_" + FormatFilename(filename, format)
+	if ofAsset {
+		name = fmt.Sprintf("%d_thumb_%d%s", assetID, size, formatExtensions[format])
+	}
+
+	return userStorage.derivatives.get(assetID, name, func() ([]byte, error) {
+		var img image.Image
+		var err error
+		if edited {
+			img, err = userStorage.renderThumbnail(assetID, size, size, FitContain)
+		} else {
+			var data []byte
+			if data, err = us.RepositoryGetTinyImage(userID, filename); err != nil {
+				return nil, ErrAssetNotFound
+			}
+			if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+				err = fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return encodeThumbnail(userStorage.maintenanceCtx, encoder, img)
+	}, userStorage.addThumbnailBytes)
+}
+
+// thumbnailAsset returns the asset and size of a pre-generated thumbnail, named
+// "<id>_<size>.jpg"; false for other files of the folder, like avatars
+func thumbnailAsset(filename string) (int, int, bool) {
+
+	id, size, found := strings.Cut(strings.TrimSuffix(filename, filepath.Ext(filename)), "_")
+	if !found {
+		return 0, 0, false
+	}
+	assetID, err := strconv.Atoi(id)
+	if err != nil || assetID <= 0 {
+		return 0, 0, false
+	}
+	pixels, err := strconv.Atoi(size)
+	if err != nil || pixels <= 0 {
+		return 0, 0, false
+	}
+	return assetID, pixels, true
+}
+
+func encodeThumbnail(ctx context.Context, encoder ImageEncoder, img image.Image) ([]byte, error) {
+	data, err := encoder.Encode(ctx, img)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrThumbnailFailed, err)
+	}
+	return data, nil
+}
+
+// get returns the cached derivative of an asset or renders it once for all concurrent callers
+func (cache *derivativeCache) get(assetID int, name string, render func() ([]byte, error), account func(int64, int)) ([]byte, time.Time, error) {
+
+	cache.mu.Lock()
+	cache.load()
+
+	if element, exists := cache.entries[name]; exists {
+		cache.order.MoveToFront(element)
+		cache.hits++
+		cache.mu.Unlock()
+
+		path := filepath.Join(cache.dir, name)
+		data, err := os.ReadFile(path)
+		if err == nil {
+			stat, _ := os.Stat(path)
+			return data, statModTime(stat), nil
+		}
+		// Removed behind our back, render it again
+		cache.mu.Lock()
+		cache.remove(name, nil)
+	} else {
+		cache.misses++
+	}
+
+	if call, exists := cache.inflight[name]; exists {
+		cache.mu.Unlock()
+		<-call.done
+		return call.data, time.Now(), call.err
+	}
+
+	call := &derivativeCall{done: make(chan struct{})}
+	cache.inflight[name] = call
+	version := cache.versions[assetID]
+	cache.mu.Unlock()
+
+	call.data, call.err = render()
+	if call.err == nil {
+		call.err = cache.store(assetID, version, name, call.data, account)
+	}
+
+	cache.mu.Lock()
+	if cache.inflight[name] == call {
+		delete(cache.inflight, name)
+	}
+	cache.mu.Unlock()
+	close(call.done)
+
+	return call.data, time.Now(), call.err
+}
+
+// store writes a derivative and evicts the least recently used ones over the capacity.
+// A derivative rendered from a version of the asset that was removed meanwhile is
+// dropped: the file is written aside and only renamed in place when still current.
+func (cache *derivativeCache) store(assetID int, version int, name string, data []byte, account func(int64, int)) error {
+
+	if err := os.MkdirAll(cache.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create derivatives folder: %w", err)
+	}
+	temp, err := os.CreateTemp(cache.dir, ".tmp-"+name+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to save thumbnail: %w", err)
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to save thumbnail: %w", err)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.versions[assetID] != version {
+		os.Remove(temp.Name())
+		return nil
+	}
+	cache.remove(name, account) // a copy stored by a render that was detached meanwhile
+	if err := os.Rename(temp.Name(), filepath.Join(cache.dir, name)); err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to save thumbnail: %w", err)
+	}
+	account(int64(len(data)), 1)
+
+	cache.entries[name] = cache.order.PushFront(&derivativeEntry{name: name, size: int64(len(data))})
+	cache.size += int64(len(data))
+
+	for cache.size > cache.capacity && cache.order.Len() > 1 {
+		oldest := cache.order.Back().Value.(*derivativeEntry)
+		cache.remove(oldest.name, account)
+	}
+
+	return nil
+}
+
+// remove drops a derivative from the index and deletes its file, callers hold mu
+func (cache *derivativeCache) remove(name string, account func(int64, int)) {
+
+	element, exists := cache.entries[name]
+	if !exists {
+		return
+	}
+	entry := element.Value.(*derivativeEntry)
+
+	cache.order.Remove(element)
+	delete(cache.entries, name)
+	cache.size -= entry.size
+
+	if err := os.Remove(filepath.Join(cache.dir, name)); err == nil && account != nil {
+		account(-entry.size, -1)
+	}
+}
+
+// removeAsset deletes the derivatives of an asset, after it changed or was deleted.
+// Renders in flight are detached: they finish for their callers but are not stored.
+func (cache *derivativeCache) removeAsset(assetID int, account func(int64, int)) {
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.load()
+
+	cache.versions[assetID]++
+
+	prefix := fmt.Sprintf("%d_", assetID)
+	for name := range cache.entries {
+		if strings.HasPrefix(name, prefix) {
+			cache.remove(name, account)
+		}
+	}
+	for name := range cache.inflight {
+		if strings.HasPrefix(name, prefix) {
+			delete(cache.inflight, name)
+		}
+	}
+}
+
+// load indexes the derivatives on disk the first time, oldest first. Callers hold mu.
+func (cache *derivativeCache) load() {
+
+	if cache.loaded {
+		return
+	}
+	cache.loaded = true
+
+	entries, err := os.ReadDir(cache.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("failed to read derivatives: %v", err)
+		}
+		return
+	}
+
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		// Left over by a store that did not finish
+		if strings.HasPrefix(entry.Name(), ".tmp-") {
+			os.Remove(filepath.Join(cache.dir, entry.Name()))
+			continue
+		}
+		files = append(files, file{entry.Name(), info.Size(), info.ModTime()})
+	}
+	slices.SortFunc(files, func(a, b file) int { return b.modTime.Compare(a.modTime) })
+
+	for _, f := range files {
+		cache.entries[f.name] = cache.order.PushBack(&derivativeEntry{name: f.name, size: f.size})
+		cache.size += f.size
+	}
+}
+
+func (cache *derivativeCache) stats() *model.CacheStats {
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	stats := &model.CacheStats{
+		Name:   "derivatives",
+		Size:   len(cache.entries),
+		Hits:   cache.hits,
+		Misses: cache.misses,
+	}
+	if total := cache.hits + cache.misses; total > 0 {
+		stats.HitRate = float64(cache.hits) / float64(total)
+	}
+	return stats
+}
+
+// renderThumbnail decodes the original, or a frame of a video, and resizes it
+func (userStorage *UserStorage) renderThumbnail(assetID int, w int, h int, fit string) (image.Image, error) {
+
+	asset, exists := userStorage.GetAssetCopy(assetID)
+	if !exists {
+		return nil, ErrAssetNotFound
+	}
+
+	var img image.Image
+	var err error
+	if IsVideoFile(asset.Filename) {
+		if userStorage.posterExtractor == nil {
+			return nil, ErrVideoProcessing
+		}
+		ctx, cancel := context.WithTimeout(userStorage.maintenanceCtx, videoPosterTimeout)
+		defer cancel()
+		path := filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "assets"), asset.Filename)
+		img, err = userStorage.posterExtractor.ExtractFrame(ctx, path, time.Second)
+	} else {
+		img, err = userStorage.decodeOriginal(asset)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	orientation, operations := userStorage.renderState(asset)
+
+	// Edits are made on the upright image at full size, crops are relative to it
+	if operations != nil {
+		return fitImage(applyEdits(orientImage(img, orientation), operations), w, h, fit), nil
+	}
+
+	// Scale first, the box is turned with the image so the result fits w by h
+	if swapsAxes(orientation) {
+		w, h = h, w
+	}
+
+	return orientImage(fitImage(img, w, h, fit), orientation), nil
+}
+
+// fitImage resizes an image to cover or fit in a w by h box, images are never enlarged
+func fitImage(img image.Image, w int, h int, fit string) image.Image {
+
+	src := img.Bounds()
+	sw, sh := src.Dx(), src.Dy()
+	if sw == 0 || sh == 0 {
+		return img
+	}
+
+	if fit == FitCover {
+		// Crop the center to the aspect ratio of the box
+		if sw*h > sh*w {
+			cw := sh * w / h
+			src.Min.X += (sw - cw) / 2
+			src.Max.X = src.Min.X + cw
+		} else {
+			ch := sw * h / w
+			src.Min.Y += (sh - ch) / 2
+			src.Max.Y = src.Min.Y + ch
+		}
+		return scaleImage(img, src, max(w, h))
+	}
+
+	// The longest side that keeps both sides in the box
+	scale := min(float64(w)/float64(sw), float64(h)/float64(sh))
+	return scaleImage(img, src, max(1, int(float64(max(sw, sh))*scale)))
+}
+
+func statModTime(stat os.FileInfo) time.Time {
+	if stat == nil {
+		return time.Time{}
+	}
+	return stat.ModTime()
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/config"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const previewFolder = "thumbnails/previews"
+
+// GetAssetDetails returns what was recorded about an asset at upload
+func (userStorage *UserStorage) GetAssetDetails(assetID int) (*model.AssetDetails, error) {
+
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+
+	if _, exists := userStorage.assets[assetID]; !exists {
+		return nil, ErrAssetNotFound
+	}
+	details, exists := userStorage.details[assetID]
+	if !exists {
+		return nil, ErrDetailsNotFound
+	}
+	return details, nil
+}
+
+// prepareDetails indexes the asset details by asset
+func (userStorage *UserStorage) prepareDetails() {
+
+	userStorage.mu.Lock()
+	defer userStorage.mu.Unlock()
+
+	userStorage.details = make(map[int]*model.AssetDetails)
+
+	items, err := userStorage.DetailsManager.GetAll()
+	if err != nil {
+		log.Printf("failed to load asset details for user %d: %v", userStorage.user.ID, err)
+		return
+	}
+	for _, details := range items {
+		userStorage.details[details.AssetID] = details
+	}
+}
+
+// createDetails records the format and metadata of a new asset and saves the
+// embedded preview of formats Go cannot decode. Callers hold the write lock.
+func (userStorage *UserStorage) createDetails(asset *common_models.PHAsset, format *mediaFormat, originalName string, data []byte, meta *photoMetadata) (*model.AssetDetails, error) {
+
+	details := &model.AssetDetails{
+		AssetID:      asset.ID,
+		Format:       format.Name,
+		MimeType:     format.MimeType,
+		OriginalName: originalName,
+		Orientation:  1,
+	}
+
+	var preview []byte
+	if format.Preview {
+		if preview = extractPreview(data); preview != nil {
+			name := fmt.Sprintf("%d.jpg", asset.ID)
+			if err := userStorage.savePreview(name, preview); err != nil {
+				log.Printf("failed to save preview of asset %d: %v", asset.ID, err)
+			} else {
+				details.Preview = name
+			}
+		}
+	}
+
+	// Videos come with their displayed size from the container
+	width, height := asset.PixelWidth, asset.PixelHeight
+	if meta != nil {
+		details.Orientation = meta.Orientation
+		details.Lens = meta.Lens
+		details.FocalLength = meta.FocalLength
+		details.Aperture = meta.Aperture
+		details.ExposureTime = meta.ExposureTime
+		details.ISO = meta.ISO
+		details.Flash = meta.Flash
+		details.Altitude = meta.Altitude
+		details.TimeZone = meta.TimeZone
+		details.Caption = meta.Caption
+		details.Keywords = meta.Keywords
+		details.Rating = meta.Rating
+		details.Label = meta.Label
+		details.ContentIdentifier = meta.ContentIdentifier
+	}
+	if width == 0 || height == 0 {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(preview)); err == nil {
+			width, height = cfg.Width, cfg.Height
+		}
+	}
+	details.DisplayWidth, details.DisplayHeight = width, height
+	if swapsAxes(details.Orientation) {
+		details.DisplayWidth, details.DisplayHeight = height, width
+	}
+
+	details, err := userStorage.DetailsManager.Create(details)
+	if err != nil {
+		return nil, err
+	}
+	userStorage.details[asset.ID] = details
+
+	return details, nil
+}
+
+// applyPhotoMetadata copies the extracted metadata to the fields of the asset
+func applyPhotoMetadata(asset *common_models.PHAsset, meta *photoMetadata) {
+	asset.PixelWidth, asset.PixelHeight = meta.Width, meta.Height
+	asset.CameraMake, asset.CameraModel = meta.CameraMake, meta.CameraModel
+	asset.CapturedDate = meta.CapturedDate
+	if meta.HasLocation {
+		asset.Place.Latitude, asset.Place.Longitude = meta.Latitude, meta.Longitude
+	}
+}
+
+// fillPhotoMetadata takes the pixel size of a new original and fills only the other
+// fields that are still empty, so dates, cameras and places users set are kept
+func fillPhotoMetadata(asset *common_models.PHAsset, meta *photoMetadata) {
+	asset.PixelWidth, asset.PixelHeight = meta.Width, meta.Height
+	if asset.CameraMake == "" {
+		asset.CameraMake = meta.CameraMake
+	}
+	if asset.CameraModel == "" {
+		asset.CameraModel = meta.CameraModel
+	}
+	if _, located := assetCoordinate(asset); !located && meta.HasLocation {
+		asset.Place.Latitude, asset.Place.Longitude = meta.Latitude, meta.Longitude
+	}
+	if asset.CapturedDate.IsZero() {
+		asset.CapturedDate = meta.CapturedDate
+	}
+}
+
+// displaySize returns the size of an asset as it is shown, after the EXIF orientation
+func displaySize(asset *common_models.PHAsset, details *model.AssetDetails) (int, int) {
+	if details != nil && details.DisplayWidth > 0 && details.DisplayHeight > 0 {
+		return details.DisplayWidth, details.DisplayHeight
+	}
+	return asset.PixelWidth, asset.PixelHeight
+}
+
+// probeDetails records the details of the assets uploaded before they were kept
+func (userStorage *UserStorage) probeDetails() {
+
+	userStorage.mu.RLock()
+	var pending []int
+	for id := range userStorage.assets {
+		if _, exists := userStorage.details[id]; !exists {
+			pending = append(pending, id)
+		}
+	}
+	userStorage.mu.RUnlock()
+
+	for _, id := range pending {
+		if userStorage.maintenanceCtx.Err() != nil {
+			return
+		}
+
+		userStorage.mu.Lock()
+		if asset, exists := userStorage.assets[id]; exists {
+			if err := userStorage.probeAssetDetails(asset); err != nil {
+				log.Printf("failed to probe asset %d: %v", id, err)
+			}
+		}
+		userStorage.mu.Unlock()
+	}
+}
+
+// probeAssetDetails reads the original of an asset again. Callers hold the write lock.
+func (userStorage *UserStorage) probeAssetDetails(asset *common_models.PHAsset) error {
+
+	data, err := os.ReadFile(filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "assets"), asset.Filename))
+	if err != nil {
+		return err
+	}
+
+	format, err := detectFormat(data, asset.Filename)
+	if err != nil {
+		return err
+	}
+
+	var meta *photoMetadata
+	if !IsVideoFile(asset.Filename) {
+		meta = readPhotoMetadata(data)
+
+		// Only fill what the old extractor left empty
+		if asset.PixelWidth == 0 || asset.PixelHeight == 0 {
+			asset.PixelWidth, asset.PixelHeight = meta.Width, meta.Height
+		}
+		if asset.CameraMake == "" {
+			asset.CameraMake = meta.CameraMake
+		}
+		if asset.CameraModel == "" {
+			asset.CameraModel = meta.CameraModel
+		}
+		if _, located := assetCoordinate(asset); !located && meta.HasLocation {
+			asset.Place.Latitude, asset.Place.Longitude = meta.Latitude, meta.Longitude
+		}
+		if asset.CapturedDate.IsZero() && !meta.CapturedDate.IsZero() {
+			userStorage.unindexAsset(asset)
+			asset.CapturedDate = meta.CapturedDate
+			userStorage.indexAsset(asset)
+		}
+		if err := userStorage.metadata.SaveMetadata(asset); err != nil {
+			return err
+		}
+	}
+
+	details, err := userStorage.createDetails(asset, format, "", data, meta)
+	if err != nil {
+		return err
+	}
+
+	// Thumbnails made before the orientation was known are sideways
+	if details.Orientation > 1 {
+		userStorage.derivatives.removeAsset(asset.ID, userStorage.addThumbnailBytes)
+	}
+	return nil
+}
+
+func (userStorage *UserStorage) savePreview(name string, data []byte) error {
+
+	dir := config.GetUserPath(userStorage.user.PhoneNumber, previewFolder)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		return err
+	}
+	userStorage.addThumbnailBytes(int64(len(data)), 1)
+
+	return nil
+}
+
+// deleteDetails drops the details of a deleted asset. Callers hold the write lock.
+func (userStorage *UserStorage) deleteDetails(assetID int) {
+
+	details, exists := userStorage.details[assetID]
+	if !exists {
+		return
+	}
+	delete(userStorage.details, assetID)
+
+	if err := userStorage.DetailsManager.Delete(details.ID); err != nil {
+		log.Printf("failed to delete details of asset %d: %v", assetID, err)
+	}
+}
+
+// decodeOriginal decodes the original image file of an asset. HEIF and RAW files
+// are decoded from their embedded preview, or from a frame decoded by ffmpeg when
+// they have none. Callers must not hold mu, the details are read under it.
+func (userStorage *UserStorage) decodeOriginal(asset *common_models.PHAsset) (image.Image, error) {
+
+	var preview, format string
+	userStorage.mu.RLock()
+	if details := userStorage.details[asset.ID]; details != nil {
+		preview, format = details.Preview, details.Format
+	}
+	userStorage.mu.RUnlock()
+
+	if preview != "" {
+		data, err := os.ReadFile(filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, previewFolder), preview))
+		if err == nil {
+			if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+				return img, nil
+			}
+		}
+	}
+
+	path := filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "assets"), asset.Filename)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open original: %w", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err == nil {
+		return img, nil
+	}
+
+	if !previewFormat(format) || userStorage.posterExtractor == nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	ctx, cancel := context.WithTimeout(userStorage.maintenanceCtx, videoPosterTimeout)
+	defer cancel()
+
+	img, err = userStorage.posterExtractor.ExtractFrame(ctx, path, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	return img, nil
+}
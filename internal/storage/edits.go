@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/config"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"image"
+	"image/draw"
+	"log"
+	"math"
+	"slices"
+	"time"
+)
+
+const (
+	editMaxOperations = 50
+	editMaxExposure   = 5 // stops
+)
+
+// GetAssetEdit returns the edit stack of an asset
+func (userStorage *UserStorage) GetAssetEdit(assetID int) (*model.AssetEdit, error) {
+
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+
+	if _, exists := userStorage.assets[assetID]; !exists {
+		return nil, ErrAssetNotFound
+	}
+	edit, exists := userStorage.edits[assetID]
+	if !exists {
+		return nil, ErrEditNotFound
+	}
+
+	// A copy, the stack is replaced by later edits
+	copied := *edit
+	copied.Operations = slices.Clone(edit.Operations)
+	return &copied, nil
+}
+
+// IsEditedFile reports whether a pre-generated thumbnail is of an edited photo, so
+// it must be made again with the edits instead of served as it is
+func (us *UserStorageManager) IsEditedFile(userID int, filename string) bool {
+	userStorage, err := us.GetUserStorage(nil, userID)
+	if err != nil {
+		return false
+	}
+	assetID, _, ofAsset := thumbnailAsset(filename)
+	return ofAsset && userStorage.isEdited(assetID)
+}
+
+func (userStorage *UserStorage) isEdited(assetID int) bool {
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+	_, edited := userStorage.edits[assetID]
+	return edited
+}
+
+// EditAsset replaces the edit stack of a photo, or appends operations to it. The
+// derivatives of the asset are made again from the original with the new stack.
+func (userStorage *UserStorage) EditAsset(assetID int, request model.AssetEditRequest) (*model.AssetEdit, error) {
+
+	for _, operation := range request.Operations {
+		if err := validateEditOperation(operation); err != nil {
+			return nil, err
+		}
+	}
+
+	userStorage.mu.Lock()
+	defer userStorage.mu.Unlock()
+
+	asset, exists := userStorage.assets[assetID]
+	if !exists {
+		return nil, ErrAssetNotFound
+	}
+	if IsVideoFile(asset.Filename) {
+		return nil, fmt.Errorf("%w: videos cannot be edited", ErrInvalidEdit)
+	}
+
+	edit, exists := userStorage.edits[assetID]
+	operations := request.Operations
+	if exists && request.Append {
+		operations = append(slices.Clone(edit.Operations), operations...)
+	}
+	if len(operations) == 0 || len(operations) > editMaxOperations {
+		return nil, fmt.Errorf("%w: from 1 to %d operations", ErrInvalidEdit, editMaxOperations)
+	}
+
+	var err error
+	if exists {
+		edit.Operations = operations
+		edit, err = userStorage.EditManager.Update(edit)
+	} else {
+		edit, err = userStorage.EditManager.Create(&model.AssetEdit{AssetID: assetID, Operations: operations})
+	}
+	if err != nil {
+		return nil, err
+	}
+	userStorage.edits[assetID] = edit
+
+	if err := userStorage.editChanged(asset); err != nil {
+		return nil, err
+	}
+	return edit, nil
+}
+
+// RevertAsset drops the edit stack of an asset, back to the original
+func (userStorage *UserStorage) RevertAsset(assetID int) error {
+
+	userStorage.mu.Lock()
+	defer userStorage.mu.Unlock()
+
+	asset, exists := userStorage.assets[assetID]
+	if !exists {
+		return ErrAssetNotFound
+	}
+	edit, exists := userStorage.edits[assetID]
+	if !exists {
+		return ErrEditNotFound
+	}
+
+	if err := userStorage.EditManager.Delete(edit.ID); err != nil {
+		return err
+	}
+	delete(userStorage.edits, assetID)
+
+	return userStorage.editChanged(asset)
+}
+
+// editChanged marks the asset modified and drops its derivatives. Callers hold the write lock.
+func (userStorage *UserStorage) editChanged(asset *common_models.PHAsset) error {
+
+	asset.ModificationDate = time.Now()
+	if err := userStorage.metadata.SaveMetadata(asset); err != nil {
+		return err
+	}
+	userStorage.derivatives.removeAsset(asset.ID, userStorage.addThumbnailBytes)
+
+	return nil
+}
+
+// GetRendered returns the edited version of a photo, with its longest side scaled
+// to size or at full size when size is 0. It is cached with the other derivatives.
+func (userStorage *UserStorage) GetRendered(assetID int, size int, format string) ([]byte, string, time.Time, error) {
+
+	if size != 0 && !slices.Contains(config.ThumbnailSizes, size) {
+		return nil, "", time.Time{}, ErrInvalidThumbnail
+	}
+
+	asset, exists := userStorage.GetAssetCopy(assetID)
+	if !exists {
+		return nil, "", time.Time{}, ErrAssetNotFound
+	}
+	if IsVideoFile(asset.Filename) {
+		return nil, "", time.Time{}, ErrUnsupportedFormat
+	}
+
+	encoder, exists := userStorage.encoders[format]
+	if !exists {
+		format, encoder = FormatJPEG, userStorage.encoders[FormatJPEG]
+	}
+
+	name := fmt.Sprintf("%d_rendered_%d%s", assetID, size, formatExtensions[format])
+
+	data, modTime, err := userStorage.derivatives.get(assetID, name, func() ([]byte, error) {
+		img, err := userStorage.decodeOriginal(asset)
+		if err != nil {
+			return nil, err
+		}
+		orientation, operations := userStorage.renderState(asset)
+		img = applyEdits(orientImage(img, orientation), operations)
+		if size > 0 {
+			img = scaleImage(img, img.Bounds(), size)
+		}
+		return encodeThumbnail(userStorage.maintenanceCtx, encoder, img)
+	}, userStorage.addThumbnailBytes)
+
+	return data, format, modTime, err
+}
+
+// prepareEdits indexes the edit stacks by asset
+func (userStorage *UserStorage) prepareEdits() {
+
+	userStorage.mu.Lock()
+	defer userStorage.mu.Unlock()
+
+	userStorage.edits = make(map[int]*model.AssetEdit)
+
+	items, err := userStorage.EditManager.GetAll()
+	if err != nil {
+		log.Printf("failed to load asset edits for user %d: %v", userStorage.user.ID, err)
+		return
+	}
+	for _, edit := range items {
+		userStorage.edits[edit.AssetID] = edit
+	}
+}
+
+// deleteEdit drops the edit stack of a deleted asset. Callers hold the write lock.
+func (userStorage *UserStorage) deleteEdit(assetID int) {
+
+	edit, exists := userStorage.edits[assetID]
+	if !exists {
+		return
+	}
+	delete(userStorage.edits, assetID)
+
+	if err := userStorage.EditManager.Delete(edit.ID); err != nil {
+		log.Printf("failed to delete edits of asset %d: %v", assetID, err)
+	}
+}
+
+// renderState copies, under the read lock, what rendering an asset needs: its EXIF
+// orientation, videos are always upright, and its edit stack, nil when not edited
+func (userStorage *UserStorage) renderState(asset *common_models.PHAsset) (int, []model.EditOperation) {
+
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+
+	orientation := 1
+	if details := userStorage.details[asset.ID]; details != nil && !IsVideoFile(asset.Filename) {
+		orientation = details.Orientation
+	}
+	var operations []model.EditOperation
+	if edit, exists := userStorage.edits[asset.ID]; exists {
+		operations = slices.Clone(edit.Operations)
+	}
+	return orientation, operations
+}
+
+func validateEditOperation(operation model.EditOperation) error {
+
+	valid := false
+	switch operation.Type {
+	case model.EditCrop:
+		valid = operation.X >= 0 && operation.Y >= 0 && operation.Width > 0 && operation.Height > 0 &&
+			operation.X+operation.Width <= 1 && operation.Y+operation.Height <= 1
+	case model.EditRotate:
+		valid = operation.Angle%90 == 0
+	case model.EditFlip:
+		valid = operation.Direction == model.FlipHorizontal || operation.Direction == model.FlipVertical
+	case model.EditExposure:
+		valid = math.Abs(operation.Amount) <= editMaxExposure
+	case model.EditContrast, model.EditSaturation:
+		valid = math.Abs(operation.Amount) <= 1
+	}
+
+	if !valid {
+		return fmt.Errorf("%w: %s", ErrInvalidEdit, operation.Type)
+	}
+	return nil
+}
+
+// applyEdits runs an edit stack on the upright image of an asset
+func applyEdits(img image.Image, operations []model.EditOperation) image.Image {
+
+	for _, operation := range operations {
+		switch operation.Type {
+		case model.EditCrop:
+			img = cropImage(img, operation)
+		case model.EditRotate:
+			// Clockwise turns are the orientations that undo a counterclockwise one
+			switch (operation.Angle%360 + 360) % 360 {
+			case 90:
+				img = orientImage(img, 6)
+			case 180:
+				img = orientImage(img, 3)
+			case 270:
+				img = orientImage(img, 8)
+			}
+		case model.EditFlip:
+			if operation.Direction == model.FlipHorizontal {
+				img = orientImage(img, 2)
+			} else {
+				img = orientImage(img, 4)
+			}
+		case model.EditExposure:
+			gain := math.Pow(2, operation.Amount)
+			img = adjustImage(img, func(r, g, b float64) (float64, float64, float64) {
+				return r * gain, g * gain, b * gain
+			})
+		case model.EditContrast:
+			factor := 1 + operation.Amount
+			img = adjustImage(img, func(r, g, b float64) (float64, float64, float64) {
+				return (r-0.5)*factor + 0.5, (g-0.5)*factor + 0.5, (b-0.5)*factor + 0.5
+			})
+		case model.EditSaturation:
+			factor := 1 + operation.Amount
+			img = adjustImage(img, func(r, g, b float64) (float64, float64, float64) {
+				luma := 0.2126*r + 0.7152*g + 0.0722*b
+				return luma + (r-luma)*factor, luma + (g-luma)*factor, luma + (b-luma)*factor
+			})
+		}
+	}
+
+	return img
+}
+
+func cropImage(img image.Image, operation model.EditOperation) image.Image {
+
+	bounds := img.Bounds()
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+
+	rect := image.Rect(
+		int(math.Round(operation.X*w)),
+		int(math.Round(operation.Y*h)),
+		int(math.Round((operation.X+operation.Width)*w)),
+		int(math.Round((operation.Y+operation.Height)*h)),
+	).Add(bounds.Min).Intersect(bounds)
+	if rect.Empty() {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
+
+// adjustImage maps the colors of every pixel, channels go from 0 to 1
+func adjustImage(img image.Image, adjust func(r, g, b float64) (float64, float64, float64)) image.Image {
+
+	dst := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	channel := func(v float64) uint8 {
+		return uint8(math.Round(min(1, max(0, v)) * 255))
+	}
+
+	for i := 0; i+3 < len(dst.Pix); i += 4 {
+		r, g, b := adjust(float64(dst.Pix[i])/255, float64(dst.Pix[i+1])/255, float64(dst.Pix[i+2])/255)
+		dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2] = channel(r), channel(g), channel(b)
+	}
+	return dst
+}
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Thumbnail formats, negotiated from the Accept header of the request
+const (
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+)
+
+const imageEncodeTimeout = 30 * time.Second
+
+// Preferred first when the client accepts several formats with the same quality
+var formatPreference = []string{FormatAVIF, FormatWebP, FormatJPEG}
+
+var formatExtensions = map[string]string{
+	FormatJPEG: ".jpg",
+	FormatWebP: ".webp",
+	FormatAVIF: ".avif",
+}
+
+// ImageEncoder writes a thumbnail in one format. Implementations must be safe for
+// concurrent use.
+type ImageEncoder interface {
+	Encode(ctx context.Context, img image.Image) ([]byte, error)
+}
+
+// JPEGEncoder uses the standard library, it is always available
+type JPEGEncoder struct {
+	Quality int
+}
+
+func (encoder *JPEGEncoder) Encode(ctx context.Context, img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: encoder.Quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CommandEncoder runs an external encoder such as cwebp or avifenc. The image is
+// handed over as a temporary PNG file, "{in}" and "{out}" in Args are replaced by
+// the input and output paths. It is never installed by default, see SetImageEncoder.
+type CommandEncoder struct {
+	Path string
+	Args []string
+}
+
+// NewCWebPEncoder encodes lossy WebP with cwebp from libwebp, the files are smaller
+// than those of WebPEncoder
+func NewCWebPEncoder(path string) *CommandEncoder {
+	return &CommandEncoder{Path: path, Args: []string{"-quiet", "-q", "75", "-metadata", "none", "{in}", "-o", "{out}"}}
+}
+
+// NewAvifencEncoder encodes AVIF with avifenc from libavif
+func NewAvifencEncoder(path string) *CommandEncoder {
+	return &CommandEncoder{Path: path, Args: []string{"--speed", "8", "{in}", "{out}"}}
+}
+
+func (encoder *CommandEncoder) Encode(ctx context.Context, img image.Image) ([]byte, error) {
+
+	dir, err := os.MkdirTemp("", "encode-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out")
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(in, buf.Bytes(), 0600); err != nil {
+		return nil, err
+	}
+
+	args := make([]string, len(encoder.Args))
+	for i, arg := range encoder.Args {
+		args[i] = strings.NewReplacer("{in}", in, "{out}", out).Replace(arg)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, imageEncodeTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, encoder.Path, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", filepath.Base(encoder.Path), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return os.ReadFile(out)
+}
+
+// defaultImageEncoders returns the pure Go encoders: JPEG and near lossless WebP.
+// Without a lossy WebP mode the WebP files are about twice the size of the JPEG
+// ones. There is no pure Go AVIF encoder, so AVIF stays disabled unless one is
+// installed with SetImageEncoder, for example NewAvifencEncoder with the path of
+// avifenc. The same way NewCWebPEncoder switches WebP to the lossy files of cwebp.
+func defaultImageEncoders() map[string]ImageEncoder {
+
+	log.Printf("thumbnails: JPEG and WebP enabled, AVIF disabled")
+
+	return map[string]ImageEncoder{
+		FormatJPEG: &JPEGEncoder{Quality: 85},
+		FormatWebP: &WebPEncoder{NearLossless: 3},
+	}
+}
+
+// SetImageEncoder replaces the encoder of a format, a nil encoder disables the
+// format. JPEG cannot be disabled. Storages opened afterwards use it.
+func (us *UserStorageManager) SetImageEncoder(format string, encoder ImageEncoder) error {
+
+	if _, exists := formatExtensions[format]; !exists {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+	if encoder == nil && format == FormatJPEG {
+		return fmt.Errorf("the JPEG encoder cannot be removed")
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	encoders := make(map[string]ImageEncoder, len(us.encoders))
+	for f, e := range us.encoders {
+		encoders[f] = e
+	}
+	if encoder == nil {
+		delete(encoders, format)
+	} else {
+		encoders[format] = encoder
+	}
+	us.encoders = encoders
+
+	return nil
+}
+
+// NegotiateFormat picks the thumbnail format for an Accept header: the available
+// format with the highest quality value, AVIF then WebP on ties. WebP and AVIF
+// must be listed explicitly, wildcards only stand for JPEG.
+func (us *UserStorageManager) NegotiateFormat(accept string) string {
+
+	us.mu.RLock()
+	encoders := us.encoders
+	us.mu.RUnlock()
+
+	return negotiateFormat(accept, encoders)
+}
+
+func negotiateFormat(accept string, encoders map[string]ImageEncoder) string {
+
+	weights := map[string]float64{FormatJPEG: 0.001} // JPEG is the fallback
+	wildcard := -1.0
+
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.EqualFold(name, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		switch mediaType {
+		case "image/jpeg", "image/jpg":
+			weights[FormatJPEG] = q
+		case "image/webp":
+			weights[FormatWebP] = q
+		case "image/avif":
+			weights[FormatAVIF] = q
+		case "image/*", "*/*":
+			wildcard = max(wildcard, q)
+		}
+	}
+	if wildcard > 0 && weights[FormatJPEG] < wildcard {
+		weights[FormatJPEG] = wildcard
+	}
+
+	best, bestWeight := FormatJPEG, 0.0
+	for _, format := range formatPreference {
+		if _, available := encoders[format]; !available {
+			continue
+		}
+		if weight := weights[format]; weight > bestWeight {
+			best, bestWeight = format, weight
+		}
+	}
+
+	return best
+}
+
+// FormatFilename returns the name of a file once converted to a format
+func FormatFilename(filename string, format string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + formatExtensions[format]
+}
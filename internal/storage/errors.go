@@ -6,6 +6,9 @@ var (
 	ErrUnsupportedFormat = errors.New("unsupported image format")
 	ErrVideoProcessing   = errors.New("video processing disabled")
 	ErrThumbnailFailed   = errors.New("thumbnail generation failed")
+	ErrVideoNotFound     = errors.New("video metadata not found")
+	ErrInvalidThumbnail  = errors.New("thumbnail size or fit not allowed")
+	ErrDetailsNotFound   = errors.New("asset details not found")
 )
 
 var (
@@ -13,6 +16,70 @@ var (
 	ErrThumbnailNotFound = errors.New("thumbnail not found")
 	ErrFileTooLarge      = errors.New("file size exceeds limit")
 	ErrInvalidUpdate     = errors.New("invalid asset update")
+	ErrInvalidLocation   = errors.New("latitude and longitude must be set together and in range")
+	ErrInvalidDateEdit   = errors.New("set the capture date, or shift it, not both")
 	ErrMetadataCorrupted = errors.New("metadata corrupted")
 	ErrIndexCorrupted    = errors.New("index corrupted")
 )
+
+var (
+	ErrSharedAlbumNotFound  = errors.New("shared album not found")
+	ErrSharedAlbumForbidden = errors.New("permission denied for shared album")
+	ErrInvalidInviteToken   = errors.New("invalid invite token")
+	ErrInvalidRole          = errors.New("invalid shared album role")
+	ErrUserNotFound         = errors.New("user not found")
+)
+
+var (
+	ErrShareLinkNotFound = errors.New("share link not found")
+	ErrShareLinkExpired  = errors.New("share link expired")
+	ErrShareLinkPassword = errors.New("share link password required")
+	ErrShareLinkDownload = errors.New("downloads are disabled for this share link")
+	ErrInvalidShareLink  = errors.New("invalid share link")
+)
+
+var (
+	ErrFeedbackNotFound = errors.New("comment or reaction not found")
+	ErrInvalidFeedback  = errors.New("invalid comment or reaction")
+)
+
+var (
+	ErrTripNotFound           = errors.New("trip not found")
+	ErrVillageNotFound        = errors.New("village not found")
+	ErrTripSuggestionNotFound = errors.New("trip suggestion not found")
+)
+
+var (
+	ErrPersonNotFound     = errors.New("person not found")
+	ErrFaceRegionNotFound = errors.New("face region not found")
+	ErrInvalidFaceRegion  = errors.New("invalid face region")
+
+	ErrPersonSuggestionNotFound = errors.New("person suggestion not found")
+)
+
+var (
+	ErrHiddenPinNotSet = errors.New("hidden album pin is not set")
+	ErrHiddenPinWrong  = errors.New("wrong hidden album pin")
+	ErrHiddenPinFormat = errors.New("hidden album pin must be 4 to 8 digits")
+	ErrHiddenLocked    = errors.New("hidden album locked after too many attempts")
+	ErrHiddenToken     = errors.New("hidden album is locked")
+)
+
+var (
+	ErrMemoryNotFound = errors.New("memory not found")
+)
+
+var (
+	ErrInvalidQuota = errors.New("quota limits must not be negative")
+	ErrQuotaBytes   = errors.New("byte limit reached")
+	ErrQuotaAssets  = errors.New("asset limit reached")
+)
+
+var (
+	ErrEditNotFound = errors.New("asset has no edits")
+	ErrInvalidEdit  = errors.New("invalid edit operation")
+)
+
+var (
+	ErrResourceNotFound = errors.New("asset has no such resource")
+)
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/mahdi-cpp/photocloud_v2/config"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const etagCacheSize = 10000
+
+// etagCache keeps the content hash of the files streamed from disk, an entry is
+// valid while the size and modification time of the file are unchanged
+type etagCache struct {
+	mu      sync.Mutex
+	entries map[string]etagEntry
+}
+
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// FileETag returns the strong ETag of an open file, hashing it only when it changed
+func (us *UserStorageManager) FileETag(file *os.File, stat os.FileInfo) (string, error) {
+
+	us.etags.mu.Lock()
+	entry, exists := us.etags.entries[file.Name()]
+	us.etags.mu.Unlock()
+	if exists && entry.size == stat.Size() && entry.modTime.Equal(stat.ModTime()) {
+		return entry.etag, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, stat.Size())); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+
+	us.etags.mu.Lock()
+	if us.etags.entries == nil || len(us.etags.entries) >= etagCacheSize {
+		us.etags.entries = make(map[string]etagEntry)
+	}
+	us.etags.entries[file.Name()] = etagEntry{size: stat.Size(), modTime: stat.ModTime(), etag: etag}
+	us.etags.mu.Unlock()
+
+	return etag, nil
+}
+
+// ContentETag returns the strong ETag of content loaded in memory
+func ContentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// OriginalInfo and ThumbnailInfo stat the files of a user, for Last-Modified
+func (us *UserStorageManager) OriginalInfo(userID int, filename string) (os.FileInfo, error) {
+	return us.userFileInfo(userID, "assets", filename)
+}
+
+func (us *UserStorageManager) ThumbnailInfo(userID int, filename string) (os.FileInfo, error) {
+	return us.userFileInfo(userID, "thumbnails", filename)
+}
+
+func (us *UserStorageManager) userFileInfo(userID int, dir string, filename string) (os.FileInfo, error) {
+
+	userStorage, err := us.GetUserStorage(nil, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if filename == "" || filepath.Base(filename) != filename {
+		return nil, ErrAssetNotFound
+	}
+
+	return os.Stat(filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, dir), filename))
+}
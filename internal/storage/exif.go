@@ -0,0 +1,414 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// EXIF tags read by the server
+const (
+	exifTagImageWidth       = 0x0100
+	exifTagImageHeight      = 0x0101
+	exifTagDescription      = 0x010E
+	exifTagMake             = 0x010F
+	exifTagModel            = 0x0110
+	exifTagOrientation      = 0x0112
+	exifTagDateTime         = 0x0132
+	exifTagExposureTime     = 0x829A
+	exifTagFNumber          = 0x829D
+	exifTagExifIFD          = 0x8769
+	exifTagGPSIFD           = 0x8825
+	exifTagISO              = 0x8827
+	exifTagDateTimeOriginal = 0x9003
+	exifTagOffsetOriginal   = 0x9011
+	exifTagFlash            = 0x9209
+	exifTagFocalLength      = 0x920A
+	exifTagMakerNote        = 0x927C
+	exifTagPixelX           = 0xA002
+	exifTagPixelY           = 0xA003
+	exifTagLensMake         = 0xA433
+	exifTagLensModel        = 0xA434
+
+	appleTagContentIdentifier = 0x0011 // in the Apple maker note
+
+	gpsTagLatitudeRef  = 0x01
+	gpsTagLatitude     = 0x02
+	gpsTagLongitudeRef = 0x03
+	gpsTagLongitude    = 0x04
+	gpsTagAltitudeRef  = 0x05
+	gpsTagAltitude     = 0x06
+	gpsTagTimeStamp    = 0x07
+	gpsTagDateStamp    = 0x1D
+)
+
+// Names of the tags listed by the raw metadata endpoint, others show as hex
+var exifTagNames = map[uint16]string{
+	exifTagImageWidth:       "ImageWidth",
+	exifTagImageHeight:      "ImageHeight",
+	exifTagDescription:      "ImageDescription",
+	exifTagMake:             "Make",
+	exifTagModel:            "Model",
+	exifTagOrientation:      "Orientation",
+	0x011A:                  "XResolution",
+	0x011B:                  "YResolution",
+	0x0128:                  "ResolutionUnit",
+	0x0131:                  "Software",
+	exifTagDateTime:         "DateTime",
+	0x013B:                  "Artist",
+	0x8298:                  "Copyright",
+	exifTagExposureTime:     "ExposureTime",
+	exifTagFNumber:          "FNumber",
+	0x8822:                  "ExposureProgram",
+	exifTagISO:              "ISOSpeedRatings",
+	0x9000:                  "ExifVersion",
+	exifTagDateTimeOriginal: "DateTimeOriginal",
+	0x9004:                  "DateTimeDigitized",
+	0x9010:                  "OffsetTime",
+	exifTagOffsetOriginal:   "OffsetTimeOriginal",
+	0x9012:                  "OffsetTimeDigitized",
+	0x9201:                  "ShutterSpeedValue",
+	0x9202:                  "ApertureValue",
+	0x9204:                  "ExposureBiasValue",
+	0x9205:                  "MaxApertureValue",
+	0x9207:                  "MeteringMode",
+	exifTagFlash:            "Flash",
+	exifTagFocalLength:      "FocalLength",
+	0x9290:                  "SubSecTime",
+	0x9291:                  "SubSecTimeOriginal",
+	0xA001:                  "ColorSpace",
+	exifTagPixelX:           "PixelXDimension",
+	exifTagPixelY:           "PixelYDimension",
+	0xA402:                  "ExposureMode",
+	0xA403:                  "WhiteBalance",
+	0xA405:                  "FocalLengthIn35mmFilm",
+	0xA406:                  "SceneCaptureType",
+	0xA431:                  "BodySerialNumber",
+	exifTagLensMake:         "LensMake",
+	exifTagLensModel:        "LensModel",
+}
+
+var gpsTagNames = map[uint16]string{
+	0x00:               "GPSVersionID",
+	gpsTagLatitudeRef:  "GPSLatitudeRef",
+	gpsTagLatitude:     "GPSLatitude",
+	gpsTagLongitudeRef: "GPSLongitudeRef",
+	gpsTagLongitude:    "GPSLongitude",
+	gpsTagAltitudeRef:  "GPSAltitudeRef",
+	gpsTagAltitude:     "GPSAltitude",
+	gpsTagTimeStamp:    "GPSTimeStamp",
+	0x0C:               "GPSSpeedRef",
+	0x0D:               "GPSSpeed",
+	0x10:               "GPSImgDirectionRef",
+	0x11:               "GPSImgDirection",
+	0x12:               "GPSMapDatum",
+	gpsTagDateStamp:    "GPSDateStamp",
+}
+
+// exifTags holds the entries of IFD0, the EXIF IFD and the GPS IFD of a file
+type exifTags struct {
+	order binary.ByteOrder
+	tiff  []byte
+	ifd0  map[uint16]tiffEntry
+	exif  map[uint16]tiffEntry
+	gps   map[uint16]tiffEntry
+}
+
+type tiffEntry struct {
+	kind  uint16
+	count uint32
+	value []byte // the value bytes, wherever they are stored
+}
+
+// Sizes of the TIFF field types, by type number
+var tiffTypeSizes = [...]int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+// readExif finds the EXIF TIFF structure of a JPEG, TIFF based RAW or HEIF file,
+// nil when there is none
+func readExif(data []byte) *exifTags {
+
+	tiff := findExifTIFF(data)
+	if len(tiff) < 8 {
+		return nil
+	}
+
+	tags := &exifTags{tiff: tiff}
+	switch string(tiff[:2]) {
+	case "II":
+		tags.order = binary.LittleEndian
+	case "MM":
+		tags.order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	tags.ifd0 = tags.readIFD(tags.order.Uint32(tiff[4:8]))
+	if offset, exists := tags.uint(tags.ifd0, exifTagExifIFD); exists {
+		tags.exif = tags.readIFD(offset)
+	}
+	if offset, exists := tags.uint(tags.ifd0, exifTagGPSIFD); exists {
+		tags.gps = tags.readIFD(offset)
+	}
+
+	return tags
+}
+
+func findExifTIFF(data []byte) []byte {
+
+	// JPEG: the APP1 segment starting with "Exif"
+	if bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return findJPEGSegment(data, 0xE1, []byte("Exif\x00\x00"))
+	}
+
+	// TIFF based RAW files are a TIFF structure themselves
+	if isRawHeader(data) && (data[0] == 'I' || data[0] == 'M') {
+		return data
+	}
+
+	// HEIF and others keep the EXIF payload somewhere in the file
+	if i := bytes.Index(data, []byte("Exif\x00\x00")); i >= 0 {
+		return data[i+6:]
+	}
+
+	return nil
+}
+
+func (tags *exifTags) readIFD(offset uint32) map[uint16]tiffEntry {
+
+	data := tags.tiff
+	if offset == 0 || uint64(offset)+2 > uint64(len(data)) {
+		return nil
+	}
+
+	count := int(tags.order.Uint16(data[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(data) {
+		return nil
+	}
+
+	entries := make(map[uint16]tiffEntry, count)
+	for i := 0; i < count; i++ {
+		raw := data[start+i*12 : start+i*12+12]
+		kind := tags.order.Uint16(raw[2:4])
+		n := tags.order.Uint32(raw[4:8])
+		if int(kind) >= len(tiffTypeSizes) || kind == 0 {
+			continue
+		}
+
+		size := uint64(tiffTypeSizes[kind]) * uint64(n)
+		var value []byte
+		if size <= 4 {
+			value = raw[8 : 8+size]
+		} else {
+			at := uint64(tags.order.Uint32(raw[8:12]))
+			if at+size > uint64(len(data)) {
+				continue
+			}
+			value = data[at : at+size]
+		}
+
+		entries[tags.order.Uint16(raw[0:2])] = tiffEntry{kind: kind, count: n, value: value}
+	}
+
+	return entries
+}
+
+// uint returns the first value of a BYTE, SHORT or LONG entry
+func (tags *exifTags) uint(ifd map[uint16]tiffEntry, tag uint16) (uint32, bool) {
+
+	entry, exists := ifd[tag]
+	if !exists || entry.count == 0 {
+		return 0, false
+	}
+
+	switch entry.kind {
+	case 1, 7:
+		return uint32(entry.value[0]), true
+	case 3:
+		return uint32(tags.order.Uint16(entry.value)), true
+	case 4:
+		return tags.order.Uint32(entry.value), true
+	}
+	return 0, false
+}
+
+// string returns an ASCII entry without its padding
+func (tags *exifTags) string(ifd map[uint16]tiffEntry, tag uint16) string {
+	entry, exists := ifd[tag]
+	if !exists || (entry.kind != 2 && entry.kind != 7) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(entry.value), "\x00"))
+}
+
+// rationals returns the values of a RATIONAL or SRATIONAL entry
+func (tags *exifTags) rationals(ifd map[uint16]tiffEntry, tag uint16) []float64 {
+
+	entry, exists := ifd[tag]
+	if !exists || (entry.kind != 5 && entry.kind != 10) {
+		return nil
+	}
+
+	values := make([]float64, 0, entry.count)
+	for i := 0; i+8 <= len(entry.value); i += 8 {
+		num, den := tags.order.Uint32(entry.value[i:]), tags.order.Uint32(entry.value[i+4:])
+		if den == 0 {
+			values = append(values, 0)
+		} else if entry.kind == 10 {
+			values = append(values, float64(int32(num))/float64(int32(den)))
+		} else {
+			values = append(values, float64(num)/float64(den))
+		}
+	}
+	return values
+}
+
+func (tags *exifTags) rational(ifd map[uint16]tiffEntry, tag uint16) (float64, bool) {
+	values := tags.rationals(ifd, tag)
+	if len(values) == 0 {
+		return 0, false
+	}
+	return values[0], true
+}
+
+// format renders an entry for the raw metadata endpoint
+func (tags *exifTags) format(entry tiffEntry) string {
+
+	const maxValues = 16
+
+	switch entry.kind {
+	case 2: // ASCII
+		return strings.TrimSpace(strings.TrimRight(string(entry.value), "\x00"))
+	case 7: // UNDEFINED, shown as text when printable
+		if utf8.Valid(entry.value) && len(entry.value) <= 64 && !bytes.ContainsFunc(entry.value, func(r rune) bool { return r < 0x20 && r != 0 }) {
+			return strings.TrimRight(string(entry.value), "\x00")
+		}
+		return fmt.Sprintf("(%d bytes)", len(entry.value))
+	}
+
+	size := tiffTypeSizes[entry.kind]
+	var values []string
+	for i := 0; i+size <= len(entry.value) && len(values) < maxValues; i += size {
+		v := entry.value[i : i+size]
+		switch entry.kind {
+		case 1:
+			values = append(values, strconv.Itoa(int(v[0])))
+		case 6:
+			values = append(values, strconv.Itoa(int(int8(v[0]))))
+		case 3:
+			values = append(values, strconv.Itoa(int(tags.order.Uint16(v))))
+		case 8:
+			values = append(values, strconv.Itoa(int(int16(tags.order.Uint16(v)))))
+		case 4:
+			values = append(values, strconv.FormatUint(uint64(tags.order.Uint32(v)), 10))
+		case 9:
+			values = append(values, strconv.Itoa(int(int32(tags.order.Uint32(v)))))
+		case 5:
+			values = append(values, fmt.Sprintf("%d/%d", tags.order.Uint32(v), tags.order.Uint32(v[4:])))
+		case 10:
+			values = append(values, fmt.Sprintf("%d/%d", int32(tags.order.Uint32(v)), int32(tags.order.Uint32(v[4:]))))
+		case 11:
+			values = append(values, strconv.FormatFloat(float64(math.Float32frombits(tags.order.Uint32(v))), 'g', -1, 32))
+		case 12:
+			values = append(values, strconv.FormatFloat(math.Float64frombits(tags.order.Uint64(v)), 'g', -1, 64))
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+// named lists the entries of an IFD by tag name
+func (tags *exifTags) named(ifd map[uint16]tiffEntry, names map[uint16]string) map[string]string {
+
+	result := make(map[string]string, len(ifd))
+	for tag, entry := range ifd {
+		if tag == exifTagMakerNote || tag == exifTagExifIFD || tag == exifTagGPSIFD {
+			continue
+		}
+		name, exists := names[tag]
+		if !exists {
+			name = fmt.Sprintf("0x%04X", tag)
+		}
+		result[name] = tags.format(entry)
+	}
+	return result
+}
+
+// appleContentIdentifier returns the identifier iPhones write in the maker note of
+// a Live Photo, the same as in its video
+func (tags *exifTags) appleContentIdentifier() string {
+
+	note := tags.exif[exifTagMakerNote].value
+	if !bytes.HasPrefix(note, []byte("Apple iOS\x00")) || len(note) < 16 {
+		return ""
+	}
+
+	// A big endian IFD after the header, its offsets start at the maker note
+	apple := &exifTags{order: binary.BigEndian, tiff: note}
+	return apple.string(apple.readIFD(14), appleTagContentIdentifier)
+}
+
+// orientation returns the EXIF orientation, 1 when unknown
+func (tags *exifTags) orientation() int {
+	if tags == nil {
+		return 1
+	}
+	if value, exists := tags.uint(tags.ifd0, exifTagOrientation); exists && value >= 1 && value <= 8 {
+		return int(value)
+	}
+	return 1
+}
+
+// swapsAxes reports whether an orientation turns the image by a quarter
+func swapsAxes(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// orientImage turns a decoded image upright according to its EXIF orientation
+func orientImage(img image.Image, orientation int) image.Image {
+
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if swapsAxes(orientation) {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // turned a quarter counterclockwise, rotate clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // turned a quarter clockwise, rotate counterclockwise
+				sx, sy = w-1-y, x
+			}
+			i, j := src.PixOffset(sx, sy), dst.PixOffset(x, y)
+			copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// exifJPEG wraps a TIFF structure in the APP1 segment of a JPEG
+func exifJPEG(tiff []byte) []byte {
+	length := len(tiff) + 8
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte(length >> 8), byte(length)}
+	return append(append(data, "Exif\x00\x00"...), tiff...)
+}
+
+func TestReadExif(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		data        []byte
+		valid       bool
+		orientation int
+		make        string
+	}{
+		{name: "little endian", data: readFixture(t, "exif_le.jpg"), valid: true, orientation: 6, make: "Apple"},
+		{name: "big endian", data: readFixture(t, "exif_be.jpg"), valid: true, orientation: 3, make: "Canon"},
+		{name: "raw", data: readFixture(t, "preview.nef"), valid: true, orientation: 8, make: "Nikon"},
+		{name: "heif payload", data: append([]byte("ftypheic....Exif\x00\x00"), tiffWithEntry(exifTagOrientation, 3, 1, 5)...), valid: true, orientation: 5},
+		{name: "orientation out of range", data: exifJPEG(tiffWithEntry(exifTagOrientation, 3, 1, 9)), valid: true, orientation: 1},
+		{name: "orientation of another type", data: exifJPEG(tiffWithEntry(exifTagOrientation, 5, 1, 6)), valid: true, orientation: 1},
+		{name: "value past the end", data: exifJPEG(tiffWithEntry(exifTagMake, 2, 100, 8)), valid: true, orientation: 1},
+		{name: "count overflow", data: exifJPEG(tiffWithEntry(exifTagMake, 12, 0xFFFFFFFF, 8)), valid: true, orientation: 1},
+		{name: "unknown type", data: exifJPEG(tiffWithEntry(exifTagOrientation, 13, 1, 6)), valid: true, orientation: 1},
+		{name: "IFD past the end", data: exifJPEG([]byte("II*\x00\xFF\xFF\xFF\xFF")), valid: true, orientation: 1},
+		{name: "IFD count past the end", data: exifJPEG([]byte("II*\x00\x08\x00\x00\x00\xFF\xFF")), valid: true, orientation: 1},
+		{name: "exif IFD loops to itself", data: exifJPEG(tiffWithEntry(exifTagExifIFD, 4, 1, 8)), valid: true, orientation: 1},
+		{name: "bad byte order", data: exifJPEG([]byte("XX*\x00\x08\x00\x00\x00")), orientation: 1},
+		{name: "too short", data: exifJPEG([]byte("II*")), orientation: 1},
+		{name: "no exif", data: []byte{0xFF, 0xD8, 0xFF, 0xD9}, orientation: 1},
+		{name: "empty", orientation: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags := readExif(test.data)
+			if (tags != nil) != test.valid {
+				t.Fatalf("tags = %v, want valid %v", tags != nil, test.valid)
+			}
+			if got := tags.orientation(); got != test.orientation {
+				t.Errorf("orientation = %d, want %d", got, test.orientation)
+			}
+			if tags != nil {
+				if got := tags.string(tags.ifd0, exifTagMake); got != test.make {
+					t.Errorf("make = %q, want %q", got, test.make)
+				}
+			}
+		})
+	}
+}
+
+func TestExifNamed(t *testing.T) {
+
+	tags := readExif(readFixture(t, "exif_le.jpg"))
+
+	ifd0 := tags.named(tags.ifd0, exifTagNames)
+	if ifd0["Make"] != "Apple" || ifd0["Orientation"] != "6" {
+		t.Errorf("ifd0 = %v", ifd0)
+	}
+	if _, listed := ifd0["0x8769"]; listed {
+		t.Errorf("the EXIF IFD pointer is listed")
+	}
+
+	exif := tags.named(tags.exif, exifTagNames)
+	if exif["ExposureTime"] != "1/250" || exif["ISOSpeedRatings"] != "200" {
+		t.Errorf("exif = %v", exif)
+	}
+	if _, listed := exif["0x927C"]; listed {
+		t.Errorf("the maker note is listed")
+	}
+
+	gps := tags.named(tags.gps, gpsTagNames)
+	if gps["GPSLatitude"] != "35/1 41/1 2220/100" || gps["GPSLatitudeRef"] != "N" {
+		t.Errorf("gps = %v", gps)
+	}
+}
+
+func TestAppleContentIdentifier(t *testing.T) {
+
+	tests := []struct {
+		name string
+		note string
+		want string
+	}{
+		{"other maker", "Canon\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00", ""},
+		{"too short", "Apple iOS\x00\x00\x01", ""},
+		{"IFD past the end", "Apple iOS\x00\x00\x01MM\xFF\xFF", ""},
+		{"value past the end", "Apple iOS\x00\x00\x01MM\x00\x01\x00\x11\x00\x02\x00\x00\x00\x40\x00\x00\x00\x20\x00\x00\x00\x00", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags := &exifTags{exif: map[uint16]tiffEntry{exifTagMakerNote: {kind: 7, count: uint32(len(test.note)), value: []byte(test.note)}}}
+			if got := tags.appleContentIdentifier(); got != test.want {
+				t.Errorf("identifier = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+
+	// A 2x1 image, red on the left and blue on the right
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		width       int
+		height      int
+		first       color.RGBA // the top left pixel
+	}{
+		{1, 2, 1, red},
+		{2, 2, 1, blue},
+		{3, 2, 1, blue},
+		{4, 2, 1, red},
+		{5, 1, 2, red},
+		{6, 1, 2, red},
+		{7, 1, 2, blue},
+		{8, 1, 2, blue},
+		{0, 2, 1, red},
+		{9, 2, 1, red},
+	}
+
+	for _, test := range tests {
+		img := orientImage(src, test.orientation)
+		bounds := img.Bounds()
+		if bounds.Dx() != test.width || bounds.Dy() != test.height {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", test.orientation, bounds.Dx(), bounds.Dy(), test.width, test.height)
+			continue
+		}
+		if got := color.RGBAModel.Convert(img.At(bounds.Min.X, bounds.Min.Y)); got != test.first {
+			t.Errorf("orientation %d: top left %v, want %v", test.orientation, got, test.first)
+		}
+		if swapsAxes(test.orientation) != (test.width == 1) {
+			t.Errorf("orientation %d: swapsAxes = %v", test.orientation, swapsAxes(test.orientation))
+		}
+	}
+}
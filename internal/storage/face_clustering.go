@@ -0,0 +1,429 @@
+package storage
+
+import (
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"log"
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	faceScanInterval      = 1 * time.Hour
+	faceClusterSimilarity = 0.92 // cosine similarity to the cluster centroid
+	faceClusterMinFaces   = 2
+)
+
+// ScanFaces runs the face detector on the given assets, or on every asset not
+// scanned yet when assetIds is empty, and stores the faces as unnamed regions.
+// It returns the number of faces found.
+func (userStorage *UserStorage) ScanFaces(assetIds []int) (int, error) {
+
+	if userStorage.faceDetector == nil {
+		return 0, nil
+	}
+
+	userStorage.faceMu.Lock()
+	defer userStorage.faceMu.Unlock()
+
+	scans, err := userStorage.FaceScanManager.GetAll()
+	if err != nil {
+		return 0, err
+	}
+	scanned := make(map[int]bool, len(scans))
+	for _, scan := range scans {
+		scanned[scan.AssetID] = true
+	}
+
+	var pending []*common_models.PHAsset
+	userStorage.mu.RLock()
+	if len(assetIds) == 0 {
+		for _, asset := range userStorage.assets {
+			pending = append(pending, copyAsset(asset))
+		}
+	} else {
+		for _, id := range assetIds {
+			if asset, exists := userStorage.assets[id]; exists {
+				pending = append(pending, copyAsset(asset))
+			}
+		}
+	}
+	userStorage.mu.RUnlock()
+
+	sort.Slice(pending, func(i, j int) bool { return pending[i].ID < pending[j].ID })
+
+	found := 0
+	for _, asset := range pending {
+
+		if scanned[asset.ID] || asset.MediaType == "video" {
+			continue
+		}
+
+		faces := userStorage.detectFaces(asset)
+		for _, face := range faces {
+			_, err := userStorage.FaceRegionManager.Create(&model.FaceRegion{
+				AssetID:    asset.ID,
+				X:          face.X,
+				Y:          face.Y,
+				Width:      face.Width,
+				Height:     face.Height,
+				Source:     model.FaceSourceDetected,
+				Confidence: face.Confidence,
+				Embedding:  face.Embedding,
+			})
+			if err != nil {
+				return found, err
+			}
+		}
+		found += len(faces)
+
+		if _, err := userStorage.FaceScanManager.Create(&model.FaceScan{AssetID: asset.ID, FaceCount: len(faces)}); err != nil {
+			return found, err
+		}
+		scanned[asset.ID] = true
+	}
+
+	return found, nil
+}
+
+// detectFaces decodes the original and runs the detector, failures are logged and give no faces
+func (userStorage *UserStorage) detectFaces(asset *common_models.PHAsset) []DetectedFace {
+
+	img, err := userStorage.decodeOriginal(asset)
+	if err != nil {
+		log.Printf("face detection skipped asset %d: %v", asset.ID, err)
+		return nil
+	}
+
+	faces, err := userStorage.faceDetector.Detect(img)
+	if err != nil {
+		log.Printf("face detection failed for asset %d: %v", asset.ID, err)
+		return nil
+	}
+
+	return faces
+}
+
+// faceScanWorker scans new assets in the background until the storage is removed
+func (userStorage *UserStorage) faceScanWorker() {
+
+	ticker := time.NewTicker(faceScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-userStorage.maintenanceCtx.Done():
+			return
+		case <-ticker.C:
+			if _, err := userStorage.ScanFaces(nil); err != nil {
+				log.Printf("face scan failed for user %d: %v", userStorage.user.ID, err)
+			}
+		}
+	}
+}
+
+// GetPersonSuggestions returns the pending suggestions of unnamed detected faces.
+// Suggestions keep their IDs between calls: faces detected since the last call join
+// the closest pending suggestion or are clustered into new ones, and faces tagged
+// or deleted meanwhile leave theirs. Faces of dismissed suggestions are not proposed again.
+func (userStorage *UserStorage) GetPersonSuggestions() ([]*model.PersonSuggestion, error) {
+
+	userStorage.faceMu.Lock()
+	defer userStorage.faceMu.Unlock()
+
+	suggestions, err := userStorage.PersonSuggestionManager.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	faces, err := userStorage.FaceRegionManager.GetList(func(a *model.FaceRegion) bool {
+		return a.PersonID == 0 && len(a.Embedding) > 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	unnamed := make(map[int]*model.FaceRegion, len(faces))
+	for _, face := range faces {
+		unnamed[face.ID] = face
+	}
+
+	var dismissed, pending []*model.PersonSuggestion
+	for _, suggestion := range suggestions {
+		switch suggestion.Status {
+		case model.PersonSuggestionDismissed:
+			dismissed = append(dismissed, suggestion)
+		case model.PersonSuggestionPending:
+			suggestion, err := userStorage.pruneSuggestion(suggestion, unnamed)
+			if err != nil {
+				return nil, err
+			}
+			if suggestion != nil {
+				pending = append(pending, suggestion)
+			}
+		}
+	}
+
+	if fresh := uncoveredFaces(faces, slices.Concat(dismissed, pending)); len(fresh) > 0 {
+		if pending, err = userStorage.suggestFaces(pending, fresh, unnamed); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		if len(pending[i].FaceRegionIds) != len(pending[j].FaceRegionIds) {
+			return len(pending[i].FaceRegionIds) > len(pending[j].FaceRegionIds)
+		}
+		return pending[i].ID < pending[j].ID
+	})
+
+	return pending, nil
+}
+
+// pruneSuggestion drops the faces of a pending suggestion that were tagged or deleted
+// since it was made, and the suggestion itself when too few are left. Callers hold faceMu.
+func (userStorage *UserStorage) pruneSuggestion(suggestion *model.PersonSuggestion, unnamed map[int]*model.FaceRegion) (*model.PersonSuggestion, error) {
+
+	var kept []int
+	var assetIds []int
+	for _, id := range suggestion.FaceRegionIds {
+		if face, exists := unnamed[id]; exists {
+			kept = append(kept, id)
+			assetIds = appendUniqueInt(assetIds, face.AssetID)
+		}
+	}
+	if len(kept) == len(suggestion.FaceRegionIds) {
+		return suggestion, nil
+	}
+
+	if len(kept) < faceClusterMinFaces {
+		return nil, userStorage.PersonSuggestionManager.Delete(suggestion.ID)
+	}
+
+	suggestion.FaceRegionIds = kept
+	suggestion.AssetIds = assetIds
+	return userStorage.PersonSuggestionManager.Update(suggestion)
+}
+
+// suggestFaces adds new faces to the pending suggestion with the most similar centroid,
+// and clusters the others into new suggestions. Callers hold faceMu.
+func (userStorage *UserStorage) suggestFaces(pending []*model.PersonSuggestion, fresh []*model.FaceRegion, unnamed map[int]*model.FaceRegion) ([]*model.PersonSuggestion, error) {
+
+	centroids := make([][]float64, len(pending))
+	for i, suggestion := range pending {
+		var members []*model.FaceRegion
+		for _, id := range suggestion.FaceRegionIds {
+			members = append(members, unnamed[id])
+		}
+		centroids[i] = faceCentroid(members)
+	}
+
+	changed := make(map[int]bool)
+	var rest []*model.FaceRegion
+	for _, face := range fresh {
+		best := -1
+		bestSimilarity := faceClusterSimilarity
+		for i, centroid := range centroids {
+			if similarity := cosineSimilarity(face.Embedding, centroid); similarity >= bestSimilarity {
+				best = i
+				bestSimilarity = similarity
+			}
+		}
+		if best < 0 {
+			rest = append(rest, face)
+			continue
+		}
+		pending[best].FaceRegionIds = append(pending[best].FaceRegionIds, face.ID)
+		pending[best].AssetIds = appendUniqueInt(pending[best].AssetIds, face.AssetID)
+		changed[best] = true
+	}
+
+	for i := range pending {
+		if changed[i] {
+			if _, err := userStorage.PersonSuggestionManager.Update(pending[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Faces left alone stay unassigned and are clustered again with the next new faces
+	for _, cluster := range clusterFaces(rest) {
+
+		if len(cluster) < faceClusterMinFaces {
+			continue
+		}
+
+		suggestion := &model.PersonSuggestion{Status: model.PersonSuggestionPending}
+		for _, face := range cluster {
+			suggestion.FaceRegionIds = append(suggestion.FaceRegionIds, face.ID)
+			suggestion.AssetIds = appendUniqueInt(suggestion.AssetIds, face.AssetID)
+		}
+
+		created, err := userStorage.PersonSuggestionManager.Create(suggestion)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, created)
+	}
+
+	return pending, nil
+}
+
+// ConfirmPersonSuggestion tags the faces of a suggestion with an existing or a new person
+func (userStorage *UserStorage) ConfirmPersonSuggestion(request model.PersonSuggestionRequest) (*model.Person, error) {
+
+	suggestion, err := userStorage.PersonSuggestionManager.Get(request.ID)
+	if err != nil || suggestion.Status != model.PersonSuggestionPending {
+		return nil, ErrPersonSuggestionNotFound
+	}
+
+	var person *model.Person
+	if request.PersonID != 0 {
+		person, err = userStorage.PersonManager.Get(request.PersonID)
+		if err != nil {
+			return nil, ErrPersonNotFound
+		}
+	} else {
+		person, err = userStorage.PersonManager.Create(&model.Person{Title: request.Title})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var assetIds []int
+	var firstRegion int
+	for _, id := range suggestion.FaceRegionIds {
+		region, err := userStorage.FaceRegionManager.Get(id)
+		if err != nil || region.PersonID != 0 {
+			continue
+		}
+		region.PersonID = person.ID
+		if _, err := userStorage.FaceRegionManager.Update(region); err != nil {
+			return nil, err
+		}
+		assetIds = appendUniqueInt(assetIds, region.AssetID)
+		if firstRegion == 0 {
+			firstRegion = region.ID
+		}
+	}
+
+	if len(assetIds) > 0 {
+		update := common_models.AssetUpdate{AssetIds: assetIds, AddPersons: []int{person.ID}}
+		if _, err := userStorage.UpdateAsset(model.AssetUpdate{AssetUpdate: update}); err != nil {
+			return nil, err
+		}
+	}
+
+	suggestion.Status = model.PersonSuggestionConfirmed
+	suggestion.PersonID = person.ID
+	if _, err := userStorage.PersonSuggestionManager.Update(suggestion); err != nil {
+		return nil, err
+	}
+
+	if person.Avatar == "" && firstRegion != 0 {
+		if updated, err := userStorage.SetPersonAvatar(model.PersonAvatar{ID: person.ID, FaceRegionID: firstRegion}); err == nil {
+			person = updated
+		} else {
+			log.Printf("failed to create avatar for person %d: %v", person.ID, err)
+		}
+	}
+
+	userStorage.UpdateCollections()
+
+	return person, nil
+}
+
+func (userStorage *UserStorage) DismissPersonSuggestion(request model.PersonSuggestionRequest) error {
+
+	suggestion, err := userStorage.PersonSuggestionManager.Get(request.ID)
+	if err != nil || suggestion.Status != model.PersonSuggestionPending {
+		return ErrPersonSuggestionNotFound
+	}
+
+	suggestion.Status = model.PersonSuggestionDismissed
+	_, err = userStorage.PersonSuggestionManager.Update(suggestion)
+	return err
+}
+
+// uncoveredFaces returns the faces that are in none of suggestions, ordered by ID
+func uncoveredFaces(faces []*model.FaceRegion, suggestions []*model.PersonSuggestion) []*model.FaceRegion {
+
+	covered := make(map[int]bool)
+	for _, suggestion := range suggestions {
+		for _, id := range suggestion.FaceRegionIds {
+			covered[id] = true
+		}
+	}
+
+	var fresh []*model.FaceRegion
+	for _, face := range faces {
+		if !covered[face.ID] {
+			fresh = append(fresh, face)
+		}
+	}
+	sort.Slice(fresh, func(i, j int) bool { return fresh[i].ID < fresh[j].ID })
+
+	return fresh
+}
+
+// clusterFaces groups faces greedily: each face joins the most similar cluster
+// centroid above faceClusterSimilarity or starts a new cluster
+func clusterFaces(faces []*model.FaceRegion) [][]*model.FaceRegion {
+
+	var clusters [][]*model.FaceRegion
+	var centroids [][]float64
+
+	for _, face := range faces {
+
+		best := -1
+		bestSimilarity := faceClusterSimilarity
+		for i, centroid := range centroids {
+			if similarity := cosineSimilarity(face.Embedding, centroid); similarity >= bestSimilarity {
+				best = i
+				bestSimilarity = similarity
+			}
+		}
+
+		if best < 0 {
+			clusters = append(clusters, []*model.FaceRegion{face})
+			centroids = append(centroids, append([]float64(nil), face.Embedding...))
+			continue
+		}
+
+		clusters[best] = append(clusters[best], face)
+
+		// Running mean of the cluster embeddings
+		n := float64(len(clusters[best]))
+		for i := range centroids[best] {
+			centroids[best][i] += (face.Embedding[i] - centroids[best][i]) / n
+		}
+	}
+
+	return clusters
+}
+
+// faceCentroid returns the mean embedding of faces
+func faceCentroid(faces []*model.FaceRegion) []float64 {
+	var centroid []float64
+	n := 0
+	for _, face := range faces {
+		if n > 0 && len(face.Embedding) != len(centroid) {
+			continue
+		}
+		if n == 0 {
+			centroid = make([]float64, len(face.Embedding))
+		}
+		n++
+		for i := range centroid {
+			centroid[i] += (face.Embedding[i] - centroid[i]) / float64(n)
+		}
+	}
+	return centroid
+}
+
+func appendUniqueInt(items []int, value int) []int {
+	if containsInt(items, value) {
+		return items
+	}
+	return append(items, value)
+}
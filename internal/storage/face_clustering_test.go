@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+)
+
+var (
+	faceBackground = color.RGBA{R: 30, G: 60, B: 160, A: 255}
+	faceFeature    = color.RGBA{R: 20, G: 20, B: 20, A: 255}
+	lightSkin      = color.RGBA{R: 224, G: 172, B: 140, A: 255}
+	darkSkin       = color.RGBA{R: 141, G: 85, B: 36, A: 255}
+)
+
+// testFace is a skin square at x, y in pixels of a 96x96 image, optionally with dark eyes or beard
+type testFace struct {
+	x, y, size int
+	skin       color.RGBA
+	eyes       bool
+	beard      bool
+}
+
+func faceImage(faces ...testFace) image.Image {
+
+	img := image.NewRGBA(image.Rect(0, 0, 96, 96))
+	draw.Draw(img, img.Bounds(), image.NewUniform(faceBackground), image.Point{}, draw.Src)
+
+	for _, face := range faces {
+		draw.Draw(img, image.Rect(face.x, face.y, face.x+face.size, face.y+face.size), image.NewUniform(face.skin), image.Point{}, draw.Src)
+		if face.eyes {
+			eye := face.size / 6
+			for _, ex := range []int{face.x + face.size/4, face.x + face.size*3/4 - eye} {
+				ey := face.y + face.size/4
+				draw.Draw(img, image.Rect(ex, ey, ex+eye, ey+eye), image.NewUniform(faceFeature), image.Point{}, draw.Src)
+			}
+		}
+		if face.beard {
+			beard := image.Rect(face.x+face.size/8, face.y+face.size/2, face.x+face.size*7/8, face.y+face.size*7/8)
+			draw.Draw(img, beard, image.NewUniform(faceFeature), image.Point{}, draw.Src)
+		}
+	}
+
+	return img
+}
+
+func TestLocalFaceDetector(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		img   image.Image
+		faces int
+	}{
+		{name: "one face", img: faceImage(testFace{x: 20, y: 20, size: 40, skin: lightSkin, eyes: true}), faces: 1},
+		{name: "two faces", img: faceImage(testFace{x: 4, y: 10, size: 30, skin: lightSkin}, testFace{x: 50, y: 40, size: 30, skin: darkSkin}), faces: 2},
+		{name: "too small", img: faceImage(testFace{x: 20, y: 20, size: 5, skin: lightSkin}), faces: 0},
+		{name: "no skin", img: faceImage(), faces: 0},
+	}
+
+	detector := NewLocalFaceDetector()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, err := detector.Detect(test.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(first) != test.faces {
+				t.Fatalf("faces = %d, want %d", len(first), test.faces)
+			}
+
+			second, err := detector.Detect(test.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(first, second) {
+				t.Errorf("second detection = %+v, want %+v", second, first)
+			}
+		})
+	}
+}
+
+func TestClusterFaces(t *testing.T) {
+
+	light := testFace{x: 20, y: 20, size: 40, skin: lightSkin, eyes: true}
+	lightMoved := testFace{x: 40, y: 30, size: 48, skin: lightSkin, eyes: true}
+	dark := testFace{x: 20, y: 20, size: 40, skin: darkSkin, beard: true}
+
+	tests := []struct {
+		name      string
+		images    []image.Image
+		dismissed [][]int
+		clusters  [][]int
+	}{
+		{name: "same image", images: []image.Image{faceImage(light), faceImage(light)}, clusters: [][]int{{1, 2}}},
+		{name: "similar faces", images: []image.Image{faceImage(light), faceImage(lightMoved)}, clusters: [][]int{{1, 2}}},
+		{name: "different faces", images: []image.Image{faceImage(light), faceImage(dark), faceImage(lightMoved)}, clusters: [][]int{{1, 3}, {2}}},
+		{name: "dismissed faces", images: []image.Image{faceImage(light), faceImage(lightMoved), faceImage(light)}, dismissed: [][]int{{1, 2}}, clusters: [][]int{{3}}},
+		{name: "all dismissed", images: []image.Image{faceImage(light), faceImage(light)}, dismissed: [][]int{{1}, {2}}},
+	}
+
+	detector := NewLocalFaceDetector()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var faces []*model.FaceRegion
+			for i, img := range test.images {
+				detected, err := detector.Detect(img)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, face := range detected {
+					faces = append(faces, &model.FaceRegion{ID: len(faces) + 1, AssetID: i + 1, Embedding: face.Embedding})
+				}
+			}
+
+			var suggestions []*model.PersonSuggestion
+			for _, ids := range test.dismissed {
+				suggestions = append(suggestions, &model.PersonSuggestion{FaceRegionIds: ids, Status: model.PersonSuggestionDismissed})
+			}
+
+			var clusters [][]int
+			for _, cluster := range clusterFaces(uncoveredFaces(faces, suggestions)) {
+				var ids []int
+				for _, face := range cluster {
+					ids = append(ids, face.ID)
+				}
+				clusters = append(clusters, ids)
+			}
+
+			if !reflect.DeepEqual(clusters, test.clusters) {
+				t.Errorf("clusters = %v, want %v", clusters, test.clusters)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"image"
+	"math"
+)
+
+// DetectedFace is a face found by a FaceDetector. The box is normalized to the
+// image size, the embedding is compared with cosine similarity when clustering.
+type DetectedFace struct {
+	X          float64
+	Y          float64
+	Width      float64
+	Height     float64
+	Confidence float64
+	Embedding  []float64
+}
+
+// FaceDetector finds faces on an image. Implementations must be safe for concurrent use.
+type FaceDetector interface {
+	Detect(img image.Image) ([]DetectedFace, error)
+}
+
+const (
+	localDetectorSize     = 96   // pixels of the longest side the image is analysed at
+	localDetectorMinArea  = 0.01 // of the image, smaller skin areas are ignored
+	localDetectorMaxFaces = 8
+	localEmbeddingGrid    = 4
+)
+
+// LocalFaceDetector is a stand-in detector without GPU or network dependencies.
+// It looks for connected skin-tone areas and describes each one by the average
+// colors of a grid over it. It is deterministic: the same image always gives the
+// same faces and embeddings, which makes it usable in tests. It takes any skin-tone
+// area for a face, so it is never installed by default.
+type LocalFaceDetector struct{}
+
+func NewLocalFaceDetector() *LocalFaceDetector {
+	return &LocalFaceDetector{}
+}
+
+func (detector *LocalFaceDetector) Detect(img image.Image) ([]DetectedFace, error) {
+
+	small := scaleImage(img, img.Bounds(), localDetectorSize).(*image.RGBA)
+	bounds := small.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	skin := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := small.RGBAAt(x, y)
+			skin[y*w+x] = isSkinTone(c.R, c.G, c.B)
+		}
+	}
+
+	var faces []DetectedFace
+	seen := make([]bool, w*h)
+	minArea := int(localDetectorMinArea * float64(w*h))
+
+	// Scan in row order so the faces come out in a stable order
+	for start := 0; start < w*h && len(faces) < localDetectorMaxFaces; start++ {
+		if !skin[start] || seen[start] {
+			continue
+		}
+
+		area, box := floodSkin(skin, seen, w, h, start)
+		if area < max(minArea, 1) {
+			continue
+		}
+
+		// Faces are roughly as wide as they are high
+		ratio := float64(box.Dx()) / float64(box.Dy())
+		if ratio < 0.5 || ratio > 2 {
+			continue
+		}
+
+		faces = append(faces, DetectedFace{
+			X:          float64(box.Min.X) / float64(w),
+			Y:          float64(box.Min.Y) / float64(h),
+			Width:      float64(box.Dx()) / float64(w),
+			Height:     float64(box.Dy()) / float64(h),
+			Confidence: float64(area) / float64(box.Dx()*box.Dy()),
+			Embedding:  colorEmbedding(small, box),
+		})
+	}
+
+	return faces, nil
+}
+
+// floodSkin marks the skin area connected to start and returns its size and bounding box
+func floodSkin(skin []bool, seen []bool, w int, h int, start int) (int, image.Rectangle) {
+
+	box := image.Rect(start%w, start/w, start%w+1, start/w+1)
+	stack := []int{start}
+	seen[start] = true
+	area := 0
+
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		area++
+
+		x, y := i%w, i/w
+		box = box.Union(image.Rect(x, y, x+1, y+1))
+
+		for _, n := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+			if n[0] < 0 || n[1] < 0 || n[0] >= w || n[1] >= h {
+				continue
+			}
+			j := n[1]*w + n[0]
+			if skin[j] && !seen[j] {
+				seen[j] = true
+				stack = append(stack, j)
+			}
+		}
+	}
+
+	return area, box
+}
+
+// isSkinTone applies the usual chrominance bounds for skin in YCbCr
+func isSkinTone(r, g, b uint8) bool {
+	fr, fg, fb := float64(r), float64(g), float64(b)
+	cb := 128 - 0.168736*fr - 0.331264*fg + 0.5*fb
+	cr := 128 + 0.5*fr - 0.418688*fg - 0.081312*fb
+	return cb >= 77 && cb <= 127 && cr >= 133 && cr <= 173
+}
+
+// colorEmbedding averages the colors of a grid over box, normalized to unit length
+func colorEmbedding(img *image.RGBA, box image.Rectangle) []float64 {
+
+	embedding := make([]float64, 0, localEmbeddingGrid*localEmbeddingGrid*3)
+
+	for gy := 0; gy < localEmbeddingGrid; gy++ {
+		for gx := 0; gx < localEmbeddingGrid; gx++ {
+			cell := image.Rect(
+				box.Min.X+gx*box.Dx()/localEmbeddingGrid,
+				box.Min.Y+gy*box.Dy()/localEmbeddingGrid,
+				box.Min.X+(gx+1)*box.Dx()/localEmbeddingGrid,
+				box.Min.Y+(gy+1)*box.Dy()/localEmbeddingGrid,
+			)
+			if cell.Empty() {
+				cell = image.Rect(cell.Min.X, cell.Min.Y, cell.Min.X+1, cell.Min.Y+1)
+			}
+
+			var r, g, b, n float64
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					c := img.RGBAAt(x, y)
+					r += float64(c.R)
+					g += float64(c.G)
+					b += float64(c.B)
+					n++
+				}
+			}
+			embedding = append(embedding, r/n, g/n, b/n)
+		}
+	}
+
+	return normalizeVector(embedding)
+}
+
+func normalizeVector(v []float64) []float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := math.Sqrt(sum)
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+func cosineSimilarity(a []float64, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/gabriel-vasile/mimetype"
+	"image/jpeg"
+	"path/filepath"
+	"strings"
+)
+
+// mediaFormat is a file format accepted at upload
+type mediaFormat struct {
+	Name     string
+	MimeType string
+	Ext      string // extension the original is saved with
+	Preview  bool   // not decodable in Go, thumbnails come from the embedded JPEG preview
+}
+
+// Formats detected from the content, by MIME type
+var mediaFormats = map[string]mediaFormat{
+	"image/jpeg":             {"jpeg", "image/jpeg", ".jpg", false},
+	"image/png":              {"png", "image/png", ".png", false},
+	"image/vnd.mozilla.apng": {"png", "image/png", ".png", false},
+	"image/gif":              {"gif", "image/gif", ".gif", false},
+	"image/webp":             {"webp", "image/webp", ".webp", false},
+	"image/heic":             {"heic", "image/heic", ".heic", true},
+	"image/heic-sequence":    {"heic", "image/heic", ".heic", true},
+	"image/heif":             {"heif", "image/heif", ".heif", true},
+	"image/heif-sequence":    {"heif", "image/heif", ".heif", true},
+	"image/avif":             {"avif", "image/avif", ".avif", true},
+	"image/tiff":             {"tiff", "image/tiff", ".tif", true},
+	"video/mp4":              {"mp4", "video/mp4", ".mp4", false},
+	"video/x-m4v":            {"m4v", "video/x-m4v", ".m4v", false},
+	"video/quicktime":        {"mov", "video/quicktime", ".mov", false},
+	"video/3gpp":             {"3gp", "video/3gpp", ".3gp", false},
+}
+
+// Camera RAW formats. Most are TIFF files that mimetype cannot tell apart, so they
+// are recognized by their extension once the content matches a RAW header.
+var rawFormats = map[string]mediaFormat{
+	".dng": {"dng", "image/x-adobe-dng", ".dng", true},
+	".cr2": {"cr2", "image/x-canon-cr2", ".cr2", true},
+	".cr3": {"cr3", "image/x-canon-cr3", ".cr3", true},
+	".nef": {"nef", "image/x-nikon-nef", ".nef", true},
+	".nrw": {"nrw", "image/x-nikon-nrw", ".nrw", true},
+	".arw": {"arw", "image/x-sony-arw", ".arw", true},
+	".orf": {"orf", "image/x-olympus-orf", ".orf", true},
+	".rw2": {"rw2", "image/x-panasonic-rw2", ".rw2", true},
+	".raf": {"raf", "image/x-fuji-raf", ".raf", true},
+	".pef": {"pef", "image/x-pentax-pef", ".pef", true},
+	".srw": {"srw", "image/x-samsung-srw", ".srw", true},
+}
+
+const (
+	previewMaxScan = 64 // JPEG markers tried when a RAW has no preview tag
+	tiffMaxIFDs    = 32
+)
+
+// detectFormat identifies an upload from its first bytes, the file name only
+// tells RAW formats apart. Unknown formats return ErrUnsupportedFormat.
+func detectFormat(data []byte, filename string) (*mediaFormat, error) {
+
+	if format, exists := rawFormats[strings.ToLower(filepath.Ext(filename))]; exists && isRawHeader(data) {
+		return &format, nil
+	}
+
+	detected := mimetype.Detect(data)
+	for m := detected; m != nil; m = m.Parent() {
+		if format, exists := mediaFormats[m.String()]; exists {
+			return &format, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, detected.String())
+}
+
+// previewFormat reports whether a format is decoded through its preview
+func previewFormat(name string) bool {
+	for _, formats := range []map[string]mediaFormat{mediaFormats, rawFormats} {
+		for _, format := range formats {
+			if format.Name == name {
+				return format.Preview
+			}
+		}
+	}
+	return false
+}
+
+func isRawHeader(data []byte) bool {
+	switch {
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")): // TIFF: DNG, CR2, NEF, ARW, PEF, SRW
+		return true
+	case bytes.HasPrefix(data, []byte("IIRO")), bytes.HasPrefix(data, []byte("IIRS")), bytes.HasPrefix(data, []byte("MMOR")): // Olympus
+		return true
+	case bytes.HasPrefix(data, []byte("IIU\x00")): // Panasonic
+		return true
+	case bytes.HasPrefix(data, []byte("FUJIFILMCCD-RAW")):
+		return true
+	case len(data) >= 12 && string(data[4:12]) == "ftypcrx ": // Canon CR3
+		return true
+	}
+	return false
+}
+
+// extractPreview returns the largest JPEG embedded in a RAW or HEIF file that the
+// standard decoder can read, nil when there is none
+func extractPreview(data []byte) []byte {
+
+	var candidates [][]byte
+
+	switch {
+	case bytes.HasPrefix(data, []byte("FUJIFILMCCD-RAW")) && len(data) >= 92:
+		offset := int(binary.BigEndian.Uint32(data[84:88]))
+		length := int(binary.BigEndian.Uint32(data[88:92]))
+		if offset > 0 && length > 0 && offset+length <= len(data) {
+			candidates = append(candidates, data[offset:offset+length])
+		}
+	case len(data) >= 8 && (data[0] == 'I' && data[1] == 'I' || data[0] == 'M' && data[1] == 'M'):
+		candidates = tiffPreviews(data)
+	}
+
+	// HEIF keeps its EXIF in an item, the TIFF inside may carry a thumbnail
+	if len(candidates) == 0 {
+		if i := bytes.Index(data, []byte("Exif\x00\x00")); i >= 0 {
+			candidates = tiffPreviews(data[i+6:])
+		}
+	}
+
+	// Formats without a preview tag, such as CR3, are scanned for JPEG markers
+	if len(candidates) == 0 {
+		for offset, tries := 0, 0; tries < previewMaxScan; tries++ {
+			i := bytes.Index(data[offset:], []byte{0xFF, 0xD8, 0xFF})
+			if i < 0 {
+				break
+			}
+			candidates = append(candidates, data[offset+i:])
+			offset += i + 3
+		}
+	}
+
+	var best []byte
+	bestPixels := 0
+	for _, candidate := range candidates {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(candidate))
+		if err != nil {
+			continue // lossless JPEG raw data and thumbnails in other encodings
+		}
+		if pixels := cfg.Width * cfg.Height; pixels > bestPixels {
+			best, bestPixels = candidate, pixels
+		}
+	}
+
+	return best
+}
+
+// tiffPreviews walks the IFDs of a TIFF structure, sub IFDs included, and returns
+// the JPEG streams they point to
+func tiffPreviews(data []byte) [][]byte {
+
+	if len(data) < 8 {
+		return nil
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	var previews [][]byte
+	add := func(offset uint32, length uint32) {
+		if offset > 0 && length > 0 && uint64(offset)+uint64(length) <= uint64(len(data)) {
+			previews = append(previews, data[offset:offset+length])
+		}
+	}
+
+	queue := []uint32{order.Uint32(data[4:8])}
+	visited := make(map[uint32]bool)
+
+	for len(queue) > 0 && len(visited) < tiffMaxIFDs {
+		ifd := queue[0]
+		queue = queue[1:]
+		if ifd == 0 || visited[ifd] || uint64(ifd)+2 > uint64(len(data)) {
+			continue
+		}
+		visited[ifd] = true
+
+		count := int(order.Uint16(data[ifd:]))
+		entries := int(ifd) + 2
+		if entries+count*12+4 > len(data) {
+			continue
+		}
+
+		var jpegOffset, jpegLength, stripOffset, stripLength uint32
+		var compression uint32
+		for i := 0; i < count; i++ {
+			entry := data[entries+i*12 : entries+i*12+12]
+			tag := order.Uint16(entry[0:2])
+			kind := order.Uint16(entry[2:4])
+			n := order.Uint32(entry[4:8])
+			value := order.Uint32(entry[8:12])
+			if kind == 3 { // SHORT, left aligned in the value field
+				value = uint32(order.Uint16(entry[8:10]))
+			}
+
+			switch tag {
+			case 0x0103: // Compression
+				compression = value
+			case 0x0111: // StripOffsets
+				if n == 1 {
+					stripOffset = value
+				}
+			case 0x0117: // StripByteCounts
+				if n == 1 {
+					stripLength = value
+				}
+			case 0x0201: // JPEGInterchangeFormat
+				jpegOffset = value
+			case 0x0202: // JPEGInterchangeFormatLength
+				jpegLength = value
+			case 0x002E: // Panasonic JpgFromRaw
+				add(value, n)
+			case 0x014A: // SubIFDs
+				if n == 1 {
+					queue = append(queue, value)
+				} else if uint64(value)+uint64(n)*4 <= uint64(len(data)) {
+					for j := uint32(0); j < n; j++ {
+						queue = append(queue, order.Uint32(data[value+j*4:]))
+					}
+				}
+			}
+		}
+
+		add(jpegOffset, jpegLength)
+		if compression == 6 || compression == 7 {
+			add(stripOffset, stripLength)
+		}
+
+		queue = append(queue, order.Uint32(data[entries+count*12:]))
+	}
+
+	return previews
+}
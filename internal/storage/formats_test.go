@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/jpeg"
+	"testing"
+)
+
+// tiffWithEntry builds a little endian TIFF whose IFD0 holds one raw entry
+func tiffWithEntry(tag uint16, kind uint16, count uint32, value uint32) []byte {
+	data := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry[0:], tag)
+	binary.LittleEndian.PutUint16(entry[2:], kind)
+	binary.LittleEndian.PutUint32(entry[4:], count)
+	binary.LittleEndian.PutUint32(entry[8:], value)
+	return append(append(data, entry...), 0, 0, 0, 0)
+}
+
+func TestDetectFormat(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		data     []byte
+		filename string
+		want     string
+		err      error
+	}{
+		{name: "jpeg", data: readFixture(t, "exif_le.jpg"), filename: "IMG_0001.JPG", want: "jpeg"},
+		{name: "jpeg named as raw", data: readFixture(t, "exif_le.jpg"), filename: "IMG_0001.NEF", want: "jpeg"},
+		{name: "raw", data: readFixture(t, "preview.nef"), filename: "DSC_0001.NEF", want: "nef"},
+		{name: "raw by another extension", data: readFixture(t, "preview.nef"), filename: "DSC_0001.dng", want: "dng"},
+		{name: "tiff", data: readFixture(t, "preview.nef"), filename: "scan.tif", want: "tiff"},
+		{name: "quicktime", data: readFixture(t, "live.mov"), filename: "IMG_0001.MOV", want: "mov"},
+		{name: "png", data: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), filename: "a.png", want: "png"},
+		{name: "cr3", data: []byte("\x00\x00\x00\x18ftypcrx \x00\x00\x00\x01crx isom"), filename: "a.cr3", want: "cr3"},
+		{name: "fuji", data: []byte("FUJIFILMCCD-RAW 0201FF383501"), filename: "a.raf", want: "raf"},
+		{name: "text", data: []byte("hello"), filename: "a.jpg", err: ErrUnsupportedFormat},
+		{name: "empty", filename: "a.jpg", err: ErrUnsupportedFormat},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format, err := detectFormat(test.data, test.filename)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("error = %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if format.Name != test.want {
+				t.Errorf("format = %s, want %s", format.Name, test.want)
+			}
+		})
+	}
+}
+
+func TestExtractPreview(t *testing.T) {
+
+	fuji := func(offset uint32, length uint32, payload []byte) []byte {
+		data := make([]byte, 92)
+		copy(data, "FUJIFILMCCD-RAW ")
+		binary.BigEndian.PutUint32(data[84:], offset)
+		binary.BigEndian.PutUint32(data[88:], length)
+		return append(data, payload...)
+	}
+	thumbnail := readFixture(t, "iptc.jpg")
+
+	tests := []struct {
+		name   string
+		data   []byte
+		width  int // 0 when there is no preview
+		height int
+	}{
+		{name: "largest of the tiff previews", data: readFixture(t, "preview.nef"), width: 32, height: 16},
+		{name: "fuji", data: fuji(92, uint32(len(thumbnail)), thumbnail), width: 8, height: 8},
+		{name: "fuji preview past the end", data: fuji(92, 1<<20, thumbnail), width: 8, height: 8}, // found by the scan
+		{name: "fuji truncated header", data: []byte("FUJIFILMCCD-RAW 0201")},
+		{name: "scanned for markers", data: append([]byte("....ftypcrx ...."), thumbnail...), width: 8, height: 8},
+		{name: "marker without an image", data: []byte("\xFF\xD8\xFF\xFF\xD8\xFF")},
+		{name: "IFD past the end", data: []byte("II*\x00\xFF\xFF\x00\x00")},
+		{name: "IFD loops to itself", data: tiffWithEntry(0x014A, 4, 1, 8)},
+		{name: "sub IFDs past the end", data: tiffWithEntry(0x014A, 4, 0x40000000, 8)},
+		{name: "preview past the end", data: tiffWithEntry(0x0201, 4, 1, 0xFFFFFFF0)},
+		{name: "empty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			preview := extractPreview(test.data)
+			if test.width == 0 {
+				if preview != nil {
+					t.Fatalf("preview of %d bytes, want none", len(preview))
+				}
+				return
+			}
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(preview))
+			if err != nil {
+				t.Fatalf("preview does not decode: %v", err)
+			}
+			if cfg.Width != test.width || cfg.Height != test.height {
+				t.Errorf("preview = %dx%d, want %dx%d", cfg.Width, cfg.Height, test.width, test.height)
+			}
+		})
+	}
+}
+
+// Every prefix of a RAW file is scanned without panicking
+func TestExtractPreviewTruncated(t *testing.T) {
+
+	data := readFixture(t, "preview.nef")
+	for n := 0; n < len(data); n++ {
+		extractPreview(data[:n])
+	}
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hidden assets and albums are left out of every listing unless the request carries
+// an unlock token. The token is obtained with the PIN of the user, it is signed with
+// the server key and bound to the PIN hash, so changing the PIN revokes it.
+const (
+	hiddenUnlockTTL   = 15 * time.Minute
+	hiddenMaxAttempts = 5
+	hiddenLockout     = 5 * time.Minute
+)
+
+// SetHiddenPin sets the PIN of the hidden album, the current PIN is required to change it
+func (us *UserStorageManager) SetHiddenPin(userID int, request model.HiddenPinRequest) error {
+
+	if !validHiddenPin(request.Pin) {
+		return ErrHiddenPinFormat
+	}
+
+	us.hiddenMu.Lock()
+	defer us.hiddenMu.Unlock()
+
+	pin, err := us.getHiddenPin(userID)
+	switch {
+	case err == nil:
+		if err := us.checkHiddenPin(pin, request.CurrentPin); err != nil {
+			return err
+		}
+	case errors.Is(err, ErrHiddenPinNotSet):
+		pin = &model.HiddenPin{UserID: userID}
+	default:
+		return err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate pin salt: %w", err)
+	}
+	hash, err := hashSharePassword(request.Pin, salt)
+	if err != nil {
+		return err
+	}
+
+	pin.PinSalt = hex.EncodeToString(salt)
+	pin.PinHash = hash
+	pin.FailedAttempts = 0
+	pin.LockedUntil = nil
+
+	if pin.ID == 0 {
+		_, err = us.hiddenPinManager.Create(pin)
+	} else {
+		_, err = us.hiddenPinManager.Update(pin)
+	}
+	return err
+}
+
+// UnlockHidden checks the PIN and returns a token opening the hidden album for a while
+func (us *UserStorageManager) UnlockHidden(userID int, pinCode string) (*model.HiddenUnlock, error) {
+
+	us.hiddenMu.Lock()
+	defer us.hiddenMu.Unlock()
+
+	pin, err := us.getHiddenPin(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.checkHiddenPin(pin, pinCode); err != nil {
+		return nil, err
+	}
+
+	expiration := time.Now().Add(hiddenUnlockTTL)
+	payload := fmt.Sprintf("%d.%d", userID, expiration.Unix())
+
+	return &model.HiddenUnlock{
+		Token:          payload + "." + us.hiddenSignature(payload, pin),
+		ExpirationDate: expiration,
+	}, nil
+}
+
+// VerifyHiddenToken returns ErrHiddenToken unless the token unlocks the hidden album of the user
+func (us *UserStorageManager) VerifyHiddenToken(userID int, token string) error {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != strconv.Itoa(userID) {
+		return ErrHiddenToken
+	}
+
+	expiration, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiration {
+		return ErrHiddenToken
+	}
+
+	pin, err := us.getHiddenPin(userID)
+	if err != nil {
+		return ErrHiddenToken
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(us.hiddenSignature(payload, pin))) {
+		return ErrHiddenToken
+	}
+
+	return nil
+}
+
+// IsHiddenFile reports whether a file, original or thumbnail, belongs to a hidden asset of the user
+func (us *UserStorageManager) IsHiddenFile(userID int, filename string) bool {
+	userStorage, err := us.GetUserStorage(nil, userID)
+	if err != nil {
+		return false
+	}
+	return userStorage.isHiddenFile(filename)
+}
+
+// checkHiddenPin compares the PIN, counting failures and locking the album after too many.
+// Callers hold hiddenMu.
+func (us *UserStorageManager) checkHiddenPin(pin *model.HiddenPin, pinCode string) error {
+
+	if pin.LockedUntil != nil && time.Now().Before(*pin.LockedUntil) {
+		return ErrHiddenLocked
+	}
+
+	salt, err := hex.DecodeString(pin.PinSalt)
+	if err != nil {
+		return ErrHiddenPinWrong
+	}
+	hash, err := hashSharePassword(pinCode, salt)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(hash), []byte(pin.PinHash)) {
+		pin.FailedAttempts++
+		if pin.FailedAttempts >= hiddenMaxAttempts {
+			lockedUntil := time.Now().Add(hiddenLockout)
+			pin.LockedUntil = &lockedUntil
+			pin.FailedAttempts = 0
+		}
+		if _, err := us.hiddenPinManager.Update(pin); err != nil {
+			return err
+		}
+		return ErrHiddenPinWrong
+	}
+
+	if pin.FailedAttempts != 0 || pin.LockedUntil != nil {
+		pin.FailedAttempts = 0
+		pin.LockedUntil = nil
+		if _, err := us.hiddenPinManager.Update(pin); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (us *UserStorageManager) getHiddenPin(userID int) (*model.HiddenPin, error) {
+	items, err := us.hiddenPinManager.GetList(func(a *model.HiddenPin) bool {
+		return a.UserID == userID
+	})
+	if err != nil || len(items) == 0 {
+		return nil, ErrHiddenPinNotSet
+	}
+	return items[0], nil
+}
+
+func (us *UserStorageManager) hiddenSignature(payload string, pin *model.HiddenPin) string {
+	return us.shareSignature("hidden:" + payload + ":" + pin.PinHash)
+}
+
+func validHiddenPin(pin string) bool {
+	if len(pin) < 4 || len(pin) > 8 {
+		return false
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// prepareHidden indexes the file names of hidden assets for the download checks
+func (userStorage *UserStorage) prepareHidden() {
+
+	userStorage.mu.Lock()
+	defer userStorage.mu.Unlock()
+
+	userStorage.hiddenFiles = make(map[string]bool)
+	for _, asset := range userStorage.assets {
+		if asset.IsHidden {
+			userStorage.hiddenFiles[strings.TrimSuffix(asset.Filename, filepath.Ext(asset.Filename))] = true
+		}
+	}
+}
+
+// isHiddenFile matches originals by name and thumbnails by the "<name>_" prefix
+func (userStorage *UserStorage) isHiddenFile(filename string) bool {
+
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if userStorage.hiddenFiles[base] {
+		return true
+	}
+	if i := strings.LastIndex(base, "_"); i > 0 {
+		return userStorage.hiddenFiles[base[:i]]
+	}
+	return false
+}
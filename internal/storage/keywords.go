@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const ratingMax = 5
+
+var colorLabels = []string{model.LabelRed, model.LabelYellow, model.LabelGreen, model.LabelBlue, model.LabelPurple}
+
+// GetKeywordTree returns the keywords of the assets as a tree, with the number of
+// assets under each keyword. Hidden assets are not counted.
+func (userStorage *UserStorage) GetKeywordTree() []*model.KeywordNode {
+
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+
+	root := &model.KeywordNode{}
+	nodes := make(map[string]*model.KeywordNode) // by lower case path
+
+	for id, asset := range userStorage.assets {
+		details := userStorage.details[id]
+		if asset.IsHidden || details == nil {
+			continue
+		}
+
+		// An asset counts once for each keyword, even when several are under it
+		counted := make(map[string]bool)
+		for _, keyword := range details.Keywords {
+			keyword = normalizeKeyword(keyword)
+			if keyword == "" {
+				continue
+			}
+			parent := root
+			levels := strings.Split(keyword, "/")
+			for i := range levels {
+				path := strings.Join(levels[:i+1], "/")
+				key := strings.ToLower(path)
+
+				node, exists := nodes[key]
+				if !exists {
+					node = &model.KeywordNode{Name: levels[i], Path: path}
+					nodes[key] = node
+					parent.Children = append(parent.Children, node)
+				}
+				if !counted[key] {
+					counted[key] = true
+					node.Count++
+				}
+				parent = node
+			}
+		}
+	}
+
+	sortKeywordNodes(root.Children)
+	return root.Children
+}
+
+func sortKeywordNodes(nodes []*model.KeywordNode) {
+	sort.Slice(nodes, func(i, j int) bool { return strings.ToLower(nodes[i].Name) < strings.ToLower(nodes[j].Name) })
+	for _, node := range nodes {
+		sortKeywordNodes(node.Children)
+	}
+}
+
+// detailsFiltered reports whether fetch options filter on the asset details
+func detailsFiltered(options model.AssetFetchOptions) bool {
+	return options.MinRating > 0 || len(options.Labels) > 0 || len(options.Keywords) > 0
+}
+
+// detailsCriteria adds the rating, label and keyword filters to the criteria of
+// the shared fetch options
+func detailsCriteria(options model.AssetFetchOptions, details map[int]*model.AssetDetails, criteria assetSearchCriteria[common_models.PHAsset]) assetSearchCriteria[common_models.PHAsset] {
+
+	return func(asset common_models.PHAsset) bool {
+
+		if !criteria(asset) {
+			return false
+		}
+
+		d := details[asset.ID]
+		if d == nil {
+			return false
+		}
+
+		if d.Rating < options.MinRating {
+			return false
+		}
+
+		if len(options.Labels) > 0 && !slices.ContainsFunc(options.Labels, func(label string) bool { return strings.EqualFold(label, d.Label) }) {
+			return false
+		}
+
+		for _, filter := range options.Keywords {
+			filter = normalizeKeyword(filter)
+			if !slices.ContainsFunc(d.Keywords, func(keyword string) bool { return keywordUnder(keyword, filter) }) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// normalizeKeyword trims the levels of a hierarchical keyword and drops the empty ones
+func normalizeKeyword(keyword string) string {
+	var levels []string
+	for _, level := range strings.Split(keyword, "/") {
+		if level = strings.TrimSpace(level); level != "" {
+			levels = append(levels, level)
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+// keywordUnder reports whether a keyword is the parent keyword or one under it
+func keywordUnder(keyword string, parent string) bool {
+	if len(keyword) > len(parent) {
+		return keyword[len(parent)] == '/' && strings.EqualFold(keyword[:len(parent)], parent)
+	}
+	return strings.EqualFold(keyword, parent)
+}
+
+// validLabel reports whether a label is a known color, or empty to clear it
+func validLabel(label string) bool {
+	return label == "" || slices.Contains(colorLabels, strings.ToLower(label))
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"sort"
+	"time"
+)
+
+const (
+	memoryMonths        = 12 // monthly highlights of the last months
+	memoryMinAssets     = 6  // for highlights, trip and person memories
+	memoryMaxAssets     = 30 // best assets kept in a memory
+	memoryCoverAssets   = 6  // assets returned with the memory list
+	memoryPersonMinYear = 2  // distinct years for a person memory
+)
+
+// GetMemories returns the memories of the given day with their best assets as covers
+func (userStorage *UserStorage) GetMemories(now time.Time) common_models.PHCollectionList[*model.Memory] {
+
+	memories, members := userStorage.buildMemories(now)
+
+	result := common_models.PHCollectionList[*model.Memory]{
+		Collections: make([]*common_models.PHCollection[*model.Memory], len(memories)),
+	}
+
+	for i, memory := range memories {
+		covers := members[memory.ID]
+		if len(covers) > memoryCoverAssets {
+			covers = covers[:memoryCoverAssets]
+		}
+		result.Collections[i] = &common_models.PHCollection[*model.Memory]{
+			Item:   memory,
+			Assets: covers,
+		}
+	}
+
+	return result
+}
+
+// GetMemoryAssets returns the ranked assets of a memory
+func (userStorage *UserStorage) GetMemoryAssets(id string, now time.Time) ([]*common_models.PHAsset, error) {
+	_, members := userStorage.buildMemories(now)
+	items, exists := members[id]
+	if !exists {
+		return nil, ErrMemoryNotFound
+	}
+	return items, nil
+}
+
+// buildMemories generates the memories of the library for the given day,
+// "on this day" first, then monthly highlights, trip recaps and persons.
+func (userStorage *UserStorage) buildMemories(now time.Time) ([]*model.Memory, map[string][]*common_models.PHAsset) {
+
+	// FetchAssets leaves hidden assets out
+	assets, _, _ := userStorage.FetchAssets(common_models.PHFetchOptions{})
+
+	var memories []*model.Memory
+	members := make(map[string][]*common_models.PHAsset)
+
+	add := func(memory *model.Memory, items []*common_models.PHAsset) {
+		items = rankMemoryAssets(items)
+		memory.Count = len(items)
+		memory.AssetIds = make([]int, len(items))
+		for i, asset := range items {
+			memory.AssetIds[i] = asset.ID
+		}
+		memories = append(memories, memory)
+		members[memory.ID] = items
+	}
+
+	// On this day, one memory per past year
+	byYear := make(map[int][]*common_models.PHAsset)
+	for _, asset := range assets {
+		taken := assetTakenDate(asset)
+		if taken.Year() < now.Year() && taken.Month() == now.Month() && taken.Day() == now.Day() {
+			byYear[taken.Year()] = append(byYear[taken.Year()], asset)
+		}
+	}
+	years := make([]int, 0, len(byYear))
+	for year := range byYear {
+		years = append(years, year)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(years)))
+	for _, year := range years {
+		ago := now.Year() - year
+		title := "On this day 1 year ago"
+		if ago > 1 {
+			title = fmt.Sprintf("On this day %d years ago", ago)
+		}
+		day := time.Date(year, now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		add(&model.Memory{
+			ID:        fmt.Sprintf("%s-%d", model.MemoryOnThisDay, year),
+			Type:      model.MemoryOnThisDay,
+			Title:     title,
+			Subtitle:  day.Format("2 January 2006"),
+			StartDate: day,
+			EndDate:   day.AddDate(0, 0, 1),
+		}, byYear[year])
+	}
+
+	// Monthly highlights of the last full months
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	byMonth := make(map[string][]*common_models.PHAsset)
+	for _, asset := range assets {
+		month := assetTakenDate(asset).Format("2006-01")
+		byMonth[month] = append(byMonth[month], asset)
+	}
+	for i := 1; i <= memoryMonths; i++ {
+		start := firstOfMonth.AddDate(0, -i, 0)
+		items := byMonth[start.Format("2006-01")]
+		if len(items) < memoryMinAssets {
+			continue
+		}
+		add(&model.Memory{
+			ID:        fmt.Sprintf("%s-%s", model.MemoryMonthly, start.Format("2006-01")),
+			Type:      model.MemoryMonthly,
+			Title:     "Best of " + start.Format("January 2006"),
+			StartDate: start,
+			EndDate:   start.AddDate(0, 1, 0),
+		}, items)
+	}
+
+	// Trip recaps
+	byTrip := make(map[int][]*common_models.PHAsset)
+	byPerson := make(map[int][]*common_models.PHAsset)
+	for _, asset := range assets {
+		for _, id := range asset.Trips {
+			byTrip[id] = append(byTrip[id], asset)
+		}
+		for _, id := range asset.Persons {
+			byPerson[id] = append(byPerson[id], asset)
+		}
+	}
+
+	trips, _ := userStorage.TripManager.GetAll()
+	sort.Slice(trips, func(i, j int) bool { return trips[i].ID > trips[j].ID })
+	for _, trip := range trips {
+		items := byTrip[trip.ID]
+		if len(items) < memoryMinAssets {
+			continue
+		}
+		start, end := memoryDateRange(items)
+		add(&model.Memory{
+			ID:        fmt.Sprintf("%s-%d", model.MemoryTrip, trip.ID),
+			Type:      model.MemoryTrip,
+			Title:     trip.Title,
+			Subtitle:  "Trip recap",
+			StartDate: start,
+			EndDate:   end,
+		}, items)
+	}
+
+	// Persons seen over several years
+	persons, _ := userStorage.PersonManager.GetAll()
+	sort.Slice(persons, func(i, j int) bool { return persons[i].ID < persons[j].ID })
+	for _, person := range persons {
+		items := byPerson[person.ID]
+		if len(items) < memoryMinAssets || person.Title == "" {
+			continue
+		}
+		start, end := memoryDateRange(items)
+		if end.Year()-start.Year()+1 < memoryPersonMinYear {
+			continue
+		}
+		add(&model.Memory{
+			ID:        fmt.Sprintf("%s-%d", model.MemoryPerson, person.ID),
+			Type:      model.MemoryPerson,
+			Title:     person.Title,
+			Subtitle:  "Through the years",
+			StartDate: start,
+			EndDate:   end,
+		}, items)
+	}
+
+	return memories, members
+}
+
+// rankMemoryAssets sorts the assets best first and keeps the top memoryMaxAssets:
+// favorites, then assets with people, screenshots last
+func rankMemoryAssets(assets []*common_models.PHAsset) []*common_models.PHAsset {
+
+	ranked := make([]*common_models.PHAsset, len(assets))
+	copy(ranked, assets)
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		si, sj := memoryScore(ranked[i]), memoryScore(ranked[j])
+		if si != sj {
+			return si > sj
+		}
+		return assetTakenDate(ranked[i]).Before(assetTakenDate(ranked[j]))
+	})
+
+	if len(ranked) > memoryMaxAssets {
+		ranked = ranked[:memoryMaxAssets]
+	}
+	return ranked
+}
+
+func memoryScore(asset *common_models.PHAsset) int {
+	score := 0
+	if asset.IsFavorite {
+		score += 4
+	}
+	if len(asset.Persons) > 0 {
+		score += 2 + min(len(asset.Persons), 3)
+	}
+	if asset.IsScreenshot {
+		score -= 10
+	}
+	return score
+}
+
+func memoryDateRange(assets []*common_models.PHAsset) (time.Time, time.Time) {
+	start := assetTakenDate(assets[0])
+	end := start
+	for _, asset := range assets[1:] {
+		taken := assetTakenDate(asset)
+		if taken.Before(start) {
+			start = taken
+		}
+		if taken.After(end) {
+			end = taken
+		}
+	}
+	return start, end
+}
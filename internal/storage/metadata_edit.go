@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// metadataEdit is the validated metadata part of an AssetUpdate
+type metadataEdit struct {
+	dated    bool // the capture date changes
+	set      *time.Time
+	shift    time.Duration
+	zone     *time.Location
+	location *Coordinate
+	details  bool // the asset details change
+}
+
+func (userStorage *UserStorage) prepareMetadataEdit(update model.AssetUpdate) (*metadataEdit, error) {
+
+	edit := &metadataEdit{set: update.CapturedDate}
+
+	if update.Shift != "" {
+		if update.CapturedDate != nil {
+			return nil, ErrInvalidDateEdit
+		}
+		shift, err := time.ParseDuration(update.Shift)
+		if err != nil {
+			return nil, fmt.Errorf("%w: shift %q", ErrInvalidUpdate, update.Shift)
+		}
+		edit.shift = shift
+	}
+	if update.TimeZone != "" {
+		zone, err := parseZone(update.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: time zone %q", ErrInvalidUpdate, update.TimeZone)
+		}
+		edit.zone = zone
+	}
+	if edit.set != nil || edit.shift != 0 || edit.zone != nil {
+		edit.dated = true
+	}
+
+	if update.Rating != nil && (*update.Rating < 0 || *update.Rating > ratingMax) {
+		return nil, fmt.Errorf("%w: rating from 0 to %d", ErrInvalidUpdate, ratingMax)
+	}
+	if update.Label != nil && !validLabel(*update.Label) {
+		return nil, fmt.Errorf("%w: label %q", ErrInvalidUpdate, *update.Label)
+	}
+
+	switch {
+	case update.VillageID != nil:
+		village, err := userStorage.VillageManager.Get(*update.VillageID)
+		if err != nil || village == nil {
+			return nil, ErrVillageNotFound
+		}
+		edit.location = &Coordinate{Latitude: village.Latitude, Longitude: village.Longitude}
+	case update.Latitude != nil || update.Longitude != nil:
+		if update.Latitude == nil || update.Longitude == nil ||
+			*update.Latitude < -90 || *update.Latitude > 90 || *update.Longitude < -180 || *update.Longitude > 180 {
+			return nil, ErrInvalidLocation
+		}
+		edit.location = &Coordinate{Latitude: *update.Latitude, Longitude: *update.Longitude}
+	}
+
+	edit.details = edit.dated || update.Rating != nil || update.Label != nil || update.Caption != nil ||
+		update.Keywords != nil || len(update.AddKeywords) > 0 || len(update.RemoveKeywords) > 0
+
+	return edit, nil
+}
+
+// moves reports whether the edit moves assets in time or space
+func (edit *metadataEdit) moves() bool {
+	return edit.dated || edit.location != nil
+}
+
+// applyMoves sets the capture date and location of an asset. Callers hold the
+// write lock and take the asset out of the date index first.
+func (edit *metadataEdit) applyMoves(asset *common_models.PHAsset) {
+	edit.applyDate(asset)
+	if edit.location != nil {
+		asset.Place.Latitude, asset.Place.Longitude = edit.location.Latitude, edit.location.Longitude
+	}
+}
+
+// applyDetails saves the edits kept in the details of an asset, after applyMoves.
+// Callers hold the write lock.
+func (userStorage *UserStorage) applyDetails(asset *common_models.PHAsset, update model.AssetUpdate, edit *metadataEdit) {
+
+	if !edit.details {
+		return
+	}
+
+	if err := userStorage.updateDetails(asset, func(details *model.AssetDetails) {
+		if edit.dated {
+			details.TimeZone = zoneOffset(asset.CapturedDate)
+		}
+		if update.Rating != nil {
+			details.Rating = *update.Rating
+		}
+		if update.Label != nil {
+			details.Label = strings.ToLower(*update.Label)
+		}
+		if update.Caption != nil {
+			details.Caption = strings.TrimSpace(*update.Caption)
+		}
+
+		// Handle keyword operations
+		details.Keywords = editKeywords(details.Keywords, update)
+	}); err != nil {
+		log.Printf("failed to save details of asset %d: %v", asset.ID, err)
+	}
+}
+
+// applyDate sets or moves the capture date of an asset, assets without one start
+// from the date they were added
+func (edit *metadataEdit) applyDate(asset *common_models.PHAsset) {
+
+	if !edit.dated {
+		return
+	}
+
+	captured := assetTakenDate(asset)
+	if edit.set != nil {
+		captured = *edit.set
+	}
+	captured = captured.Add(edit.shift)
+	if edit.zone != nil {
+		captured = captured.In(edit.zone)
+	}
+
+	asset.CapturedDate = captured
+}
+
+// parseZone accepts an offset such as "+03:30" or an IANA zone name
+func parseZone(name string) (*time.Location, error) {
+	if offset, err := time.Parse("-07:00", name); err == nil {
+		_, seconds := offset.Zone()
+		return time.FixedZone(name, seconds), nil
+	}
+	return time.LoadLocation(name)
+}
+
+func zoneOffset(t time.Time) string {
+	_, seconds := t.Zone()
+	return formatOffset(seconds)
+}
+
+// editKeywords applies the keyword operations of an update like the album ones of
+// the shared update, keeping the order. Keywords match without case, removing one
+// removes the keywords under it.
+func editKeywords(keywords []string, update model.AssetUpdate) []string {
+
+	switch {
+	case update.Keywords != nil:
+		// Full replacement
+		keywords = nil
+		for _, keyword := range *update.Keywords {
+			keywords = appendKeyword(keywords, keyword)
+		}
+	case len(update.AddKeywords) > 0 || len(update.RemoveKeywords) > 0:
+
+		// Add new keywords (avoid duplicates)
+		for _, keyword := range update.AddKeywords {
+			keywords = appendKeyword(keywords, keyword)
+		}
+
+		// Remove specified keywords
+		for _, keyword := range update.RemoveKeywords {
+			keyword = normalizeKeyword(keyword)
+			keywords = slices.DeleteFunc(keywords, func(k string) bool { return keywordUnder(k, keyword) })
+		}
+	}
+
+	return keywords
+}
+
+func appendKeyword(keywords []string, keyword string) []string {
+	keyword = normalizeKeyword(keyword)
+	if keyword == "" || slices.ContainsFunc(keywords, func(k string) bool { return strings.EqualFold(k, keyword) }) {
+		return keywords
+	}
+	return append(keywords, keyword)
+}
+
+// updateDetails changes the details of an asset, probing the original first when
+// the asset has none yet. Callers hold the write lock.
+func (userStorage *UserStorage) updateDetails(asset *common_models.PHAsset, change func(*model.AssetDetails)) error {
+
+	details, exists := userStorage.details[asset.ID]
+	if !exists {
+		if err := userStorage.probeAssetDetails(asset); err != nil {
+			return err
+		}
+		details = userStorage.details[asset.ID]
+	}
+
+	change(details)
+
+	_, err := userStorage.DetailsManager.Update(details)
+	return err
+}
+
+// leaveDetectedTrips takes moved assets out of the detected trips whose time span
+// they left. Trips made by hand are kept as they are. Callers hold the write lock.
+func (userStorage *UserStorage) leaveDetectedTrips(assetIds []int) {
+
+	if len(assetIds) == 0 {
+		return
+	}
+
+	trips, err := userStorage.TripManager.GetList(func(a *model.Trip) bool {
+		return a.TripType == model.TripTypeDetected
+	})
+	if err != nil || len(trips) == 0 {
+		return
+	}
+	detected := make(map[int]bool, len(trips))
+	for _, trip := range trips {
+		detected[trip.ID] = true
+	}
+
+	moved := make(map[int]bool, len(assetIds))
+	for _, id := range assetIds {
+		moved[id] = true
+	}
+
+	// Time span of each trip without the moved assets
+	type span struct{ start, end time.Time }
+	spans := make(map[int]*span)
+	for id, asset := range userStorage.assets {
+		if moved[id] {
+			continue
+		}
+		taken := assetTakenDate(asset)
+		for _, tripID := range asset.Trips {
+			if !detected[tripID] {
+				continue
+			}
+			if s, exists := spans[tripID]; !exists {
+				spans[tripID] = &span{taken, taken}
+			} else {
+				s.start = minTime(s.start, taken)
+				s.end = maxTime(s.end, taken)
+			}
+		}
+	}
+
+	for _, id := range assetIds {
+		asset := userStorage.assets[id]
+		taken := assetTakenDate(asset)
+
+		kept := asset.Trips[:0]
+		for _, tripID := range asset.Trips {
+			s := spans[tripID]
+			if detected[tripID] && (s == nil || taken.Before(s.start.Add(-tripMaxGap)) || taken.After(s.end.Add(tripMaxGap))) {
+				continue
+			}
+			kept = append(kept, tripID)
+		}
+
+		if len(kept) != len(asset.Trips) {
+			asset.Trips = kept
+			if err := userStorage.metadata.SaveMetadata(asset); err != nil {
+				log.Printf("failed to save asset %d: %v", id, err)
+			}
+		}
+	}
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
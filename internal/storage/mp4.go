@@ -0,0 +1,361 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VideoInfo is what parseMP4 finds in the moov atom of an MP4 or QuickTime file
+type VideoInfo struct {
+	Duration     float64 // seconds
+	Width        int     // displayed size, after rotation
+	Height       int
+	Rotation     int
+	VideoCodec   string
+	AudioCodec   string
+	CreationTime time.Time
+	Latitude     float64
+	Longitude    float64
+	HasLocation  bool
+
+	ContentIdentifier string // pairs the video of a Live Photo with its photo
+}
+
+const (
+	mp4MaxAtomRead = 1 << 20 // atoms read in memory, mdat and friends are skipped
+	mp4MaxDepth    = 8
+)
+
+var errNotMP4 = errors.New("not an MP4 or QuickTime file")
+
+// Dates of the container count seconds since 1904
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type mp4Track struct {
+	handler  string
+	codec    string
+	width    int
+	height   int
+	rotation int
+}
+
+type mp4Parser struct {
+	r      io.ReaderAt
+	info   *VideoInfo
+	track  *mp4Track
+	tracks []*mp4Track
+	keys   []string // QuickTime metadata keys, indexed from 1 by ilst
+}
+
+// parseMP4 walks the atoms of the file without loading the media data
+func parseMP4(r io.ReaderAt, size int64) (*VideoInfo, error) {
+
+	p := &mp4Parser{r: r, info: &VideoInfo{}}
+
+	found := false
+	err := p.walk(0, size, 0, func(kind string, offset int64, length int64) (bool, error) {
+		if kind == "moov" {
+			found = true
+		}
+		return kind == "moov", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errNotMP4
+	}
+
+	for _, track := range p.tracks {
+		switch track.handler {
+		case "vide":
+			if p.info.VideoCodec == "" {
+				p.info.VideoCodec = track.codec
+				p.info.Width, p.info.Height = track.width, track.height
+				p.info.Rotation = track.rotation
+				if track.rotation == 90 || track.rotation == 270 {
+					p.info.Width, p.info.Height = track.height, track.width
+				}
+			}
+		case "soun":
+			if p.info.AudioCodec == "" {
+				p.info.AudioCodec = track.codec
+			}
+		}
+	}
+
+	return p.info, nil
+}
+
+// walk calls visit for the atoms in [start, end), descending into the container
+// atoms. At the top level visit decides which atoms are entered.
+func (p *mp4Parser) walk(start int64, end int64, depth int, visit func(string, int64, int64) (bool, error)) error {
+
+	if depth > mp4MaxDepth {
+		return nil
+	}
+
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+
+		if _, err := p.r.ReadAt(header[:8], offset); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:8])
+		headerSize := int64(8)
+
+		switch length {
+		case 0: // up to the end of the file
+			length = end - offset
+		case 1: // 64 bit size follows, an atom cut before it is invalid
+			length, headerSize = 0, 16
+			if end-offset >= headerSize {
+				if _, err := p.r.ReadAt(header[8:16], offset+8); err != nil {
+					return err
+				}
+				length = int64(binary.BigEndian.Uint64(header[8:16]))
+			}
+		}
+		if length < headerSize || length > end-offset {
+			if depth == 0 && offset == start {
+				return errNotMP4
+			}
+			return nil
+		}
+
+		body, bodyEnd := offset+headerSize, offset+length
+
+		if visit != nil {
+			enter, err := visit(kind, body, bodyEnd)
+			if err != nil {
+				return err
+			}
+			if enter {
+				if err := p.walk(body, bodyEnd, depth+1, nil); err != nil {
+					return err
+				}
+			}
+		} else if err := p.atom(kind, body, bodyEnd, depth); err != nil {
+			return err
+		}
+
+		offset += length
+	}
+
+	return nil
+}
+
+// atom reads the atoms of interest inside moov
+func (p *mp4Parser) atom(kind string, body int64, end int64, depth int) error {
+
+	switch kind {
+	case "trak":
+		p.track = &mp4Track{}
+		p.tracks = append(p.tracks, p.track)
+		return p.walk(body, end, depth+1, nil)
+	case "mdia", "minf", "stbl", "udta":
+		return p.walk(body, end, depth+1, nil)
+	case "meta":
+		// ISO meta is a full atom with version and flags, QuickTime meta is not
+		data, err := p.read(body, min(end, body+4))
+		if err != nil {
+			return err
+		}
+		if len(data) == 4 && binary.BigEndian.Uint32(data) == 0 {
+			body += 4
+		}
+		// The meta handler is not a track handler
+		track := p.track
+		p.track = nil
+		err = p.walk(body, end, depth+1, nil)
+		p.track = track
+		return err
+	}
+
+	if kind != "mvhd" && kind != "tkhd" && kind != "hdlr" && kind != "stsd" &&
+		kind != "\xa9xyz" && kind != "keys" && kind != "ilst" {
+		return nil
+	}
+
+	data, err := p.read(body, end)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case "mvhd":
+		p.parseMvhd(data)
+	case "tkhd":
+		p.parseTkhd(data)
+	case "hdlr":
+		if p.track != nil && len(data) >= 12 {
+			p.track.handler = string(data[8:12])
+		}
+	case "stsd":
+		if p.track != nil && len(data) >= 16 {
+			p.track.codec = strings.TrimSpace(string(data[12:16]))
+		}
+	case "\xa9xyz":
+		// 16 bit length, 16 bit language, then ISO 6709 text
+		if len(data) > 4 {
+			p.setLocation(string(data[4:]))
+		}
+	case "keys":
+		p.parseKeys(data)
+	case "ilst":
+		p.parseIlst(data)
+	}
+
+	return nil
+}
+
+func (p *mp4Parser) read(start int64, end int64) ([]byte, error) {
+	if end-start > mp4MaxAtomRead {
+		return nil, nil
+	}
+	data := make([]byte, end-start)
+	if _, err := p.r.ReadAt(data, start); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (p *mp4Parser) parseMvhd(data []byte) {
+
+	if len(data) < 20 {
+		return
+	}
+
+	var created, timescale, duration uint64
+	if data[0] == 1 {
+		if len(data) < 32 {
+			return
+		}
+		created = binary.BigEndian.Uint64(data[4:12])
+		timescale = uint64(binary.BigEndian.Uint32(data[20:24]))
+		duration = binary.BigEndian.Uint64(data[24:32])
+	} else {
+		created = uint64(binary.BigEndian.Uint32(data[4:8]))
+		timescale = uint64(binary.BigEndian.Uint32(data[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+
+	if timescale > 0 {
+		p.info.Duration = float64(duration) / float64(timescale)
+	}
+	if created > 0 {
+		p.info.CreationTime = mp4Epoch.Add(time.Duration(created) * time.Second)
+	}
+}
+
+func (p *mp4Parser) parseTkhd(data []byte) {
+
+	if p.track == nil || len(data) == 0 {
+		return
+	}
+
+	// The matrix and the size close the atom in both versions
+	matrix := 40
+	if data[0] == 1 {
+		matrix = 52
+	}
+	if len(data) < matrix+44 {
+		return
+	}
+
+	a := int32(binary.BigEndian.Uint32(data[matrix:]))
+	b := int32(binary.BigEndian.Uint32(data[matrix+4:]))
+	switch {
+	case a == 0 && b > 0:
+		p.track.rotation = 90
+	case a == 0 && b < 0:
+		p.track.rotation = 270
+	case a < 0:
+		p.track.rotation = 180
+	}
+
+	p.track.width = int(binary.BigEndian.Uint32(data[matrix+36:]) >> 16)
+	p.track.height = int(binary.BigEndian.Uint32(data[matrix+40:]) >> 16)
+}
+
+func (p *mp4Parser) parseKeys(data []byte) {
+
+	if len(data) < 8 {
+		return
+	}
+
+	p.keys = nil
+	count := int(binary.BigEndian.Uint32(data[4:8]))
+	for offset := 8; offset+8 <= len(data) && len(p.keys) < count; {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		if size < 8 || offset+size > len(data) {
+			return
+		}
+		p.keys = append(p.keys, string(data[offset+8:offset+size]))
+		offset += size
+	}
+}
+
+// parseIlst reads the values of the QuickTime metadata keys, the location and the
+// Live Photo content identifier
+func (p *mp4Parser) parseIlst(data []byte) {
+
+	for offset := 0; offset+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		if size < 8 || offset+size > len(data) {
+			return
+		}
+		index := int(binary.BigEndian.Uint32(data[offset+4:]))
+		item := data[offset+8 : offset+size]
+		offset += size
+
+		if index < 1 || index > len(p.keys) {
+			continue
+		}
+
+		// The value sits in a "data" atom: size, "data", type, locale, value
+		i := bytes.Index(item, []byte("data"))
+		if i < 4 || i+12 > len(item) {
+			continue
+		}
+		switch p.keys[index-1] {
+		case "com.apple.quicktime.location.ISO6709":
+			p.setLocation(string(item[i+12:]))
+		case "com.apple.quicktime.content.identifier":
+			p.info.ContentIdentifier = strings.TrimRight(string(item[i+12:]), "\x00")
+		}
+	}
+}
+
+// setLocation parses an ISO 6709 string such as "+35.6895+139.6917+012.000/"
+func (p *mp4Parser) setLocation(text string) {
+
+	text = strings.TrimRight(strings.TrimSpace(text), "/\x00")
+
+	var parts []string
+	start := 0
+	for i := 1; i < len(text); i++ {
+		if text[i] == '+' || text[i] == '-' {
+			parts = append(parts, text[start:i])
+			start = i
+		}
+	}
+	parts = append(parts, text[start:])
+	if len(parts) < 2 {
+		return
+	}
+
+	lat, err1 := strconv.ParseFloat(parts[0], 64)
+	lon, err2 := strconv.ParseFloat(parts[1], 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return
+	}
+
+	p.info.Latitude, p.info.Longitude, p.info.HasLocation = lat, lon, true
+}
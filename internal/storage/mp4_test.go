@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// readFixture returns a file of testdata
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	return data
+}
+
+// mp4Atom builds an atom from its type and body
+func mp4Atom(kind string, body ...[]byte) []byte {
+	data := make([]byte, 8)
+	copy(data[4:], kind)
+	for _, part := range body {
+		data = append(data, part...)
+	}
+	binary.BigEndian.PutUint32(data, uint32(len(data)))
+	return data
+}
+
+func TestParseMP4(t *testing.T) {
+
+	info, err := parseMP4(bytes.NewReader(readFixture(t, "live.mov")), int64(len(readFixture(t, "live.mov"))))
+	if err != nil {
+		t.Fatalf("error = %v", err)
+	}
+
+	want := VideoInfo{
+		Duration:   2.5,
+		Width:      1080, // turned a quarter, 1920x1080 stored
+		Height:     1920,
+		Rotation:   90,
+		VideoCodec: "hvc1",
+		AudioCodec: "mp4a",
+		// The ilst location of the metadata keys comes after the udta one
+		CreationTime:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Latitude:          35.7,
+		Longitude:         51.4,
+		HasLocation:       true,
+		ContentIdentifier: "8D6C1E5A-2B3F-4A7E-9C1D-0F2E3A4B5C6D",
+	}
+	if *info != want {
+		t.Errorf("info = %+v\nwant   %+v", *info, want)
+	}
+}
+
+func TestParseMP4Malformed(t *testing.T) {
+
+	ftyp := mp4Atom("ftyp", []byte("isom\x00\x00\x00\x00isom"))
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 4000)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		err      bool
+		duration float64
+	}{
+		{name: "moov only", data: mp4Atom("moov", mp4Atom("mvhd", mvhd)), duration: 4},
+		{name: "no moov", data: ftyp, err: true},
+		{name: "not mp4", data: []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00}, err: true},
+		{name: "empty", err: true},
+		{name: "size below the header", data: []byte("\x00\x00\x00\x04moov"), err: true},
+		{name: "size past the end", data: slices.Concat(ftyp, []byte("\x00\x00\x10\x00moov")), err: true},
+		{name: "size to the end", data: slices.Concat(ftyp, []byte("\x00\x00\x00\x00moov"), mp4Atom("mvhd", mvhd)), duration: 4},
+		{
+			name:     "64 bit size",
+			data:     slices.Concat(ftyp, []byte("\x00\x00\x00\x01moov\x00\x00\x00\x00\x00\x00\x00\x7C"), mp4Atom("mvhd", mvhd)),
+			duration: 4,
+		},
+		{name: "64 bit size overflow", data: slices.Concat(ftyp, []byte("\x00\x00\x00\x01moov\x7F\xFF\xFF\xFF\xFF\xFF\xFF\xF0")), err: true},
+		{name: "64 bit size truncated", data: slices.Concat(ftyp, []byte("\x00\x00\x00\x01moov\x00\x00")), err: true},
+		{name: "child past its parent", data: mp4Atom("moov", []byte("\x00\x00\x10\x00trak")), duration: 0},
+		{name: "short mvhd", data: mp4Atom("moov", mp4Atom("mvhd", mvhd[:12]))},
+		{name: "short tkhd", data: mp4Atom("moov", mp4Atom("trak", mp4Atom("tkhd", []byte{1, 0, 0})))},
+		{name: "empty tkhd", data: mp4Atom("moov", mp4Atom("trak", mp4Atom("tkhd")))},
+		{name: "keys past the end", data: mp4Atom("moov", mp4Atom("meta", mp4Atom("keys", []byte("\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x10\x00mdta"))))},
+		{name: "ilst index without keys", data: mp4Atom("moov", mp4Atom("meta", mp4Atom("ilst", []byte("\x00\x00\x00\x18\x00\x00\x00\x01\x00\x00\x00\x10data\x00\x00\x00\x01\x00\x00\x00\x00"))))},
+		{
+			name: "deep nesting",
+			data: mp4Atom("moov", mp4Atom("udta", mp4Atom("udta", mp4Atom("udta", mp4Atom("udta", mp4Atom("udta",
+				mp4Atom("udta", mp4Atom("udta", mp4Atom("udta", mp4Atom("udta", mp4Atom("udta", mp4Atom("mvhd", mvhd)))))))))))),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := parseMP4(bytes.NewReader(test.data), int64(len(test.data)))
+			if test.err {
+				if !errors.Is(err, errNotMP4) {
+					t.Fatalf("info = %+v, error = %v, want %v", info, err, errNotMP4)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if info.Duration != test.duration {
+				t.Errorf("duration = %v, want %v", info.Duration, test.duration)
+			}
+		})
+	}
+}
+
+// Every prefix of a video is parsed without panicking, as a file still uploading
+func TestParseMP4Truncated(t *testing.T) {
+
+	data := readFixture(t, "live.mov")
+	for n := 0; n < len(data); n++ {
+		info, err := parseMP4(bytes.NewReader(data[:n]), int64(n))
+		if err == nil && info == nil {
+			t.Fatalf("cut at %d: no info and no error", n)
+		}
+		if err != nil && !errors.Is(err, errNotMP4) {
+			t.Fatalf("cut at %d: error = %v", n, err)
+		}
+	}
+}
+
+func TestSetLocation(t *testing.T) {
+
+	tests := []struct {
+		text      string
+		valid     bool
+		latitude  float64
+		longitude float64
+	}{
+		{"+35.6895+139.6917+012.000/", true, 35.6895, 139.6917},
+		{"-33.8688+151.2093/", true, -33.8688, 151.2093},
+		{"+35.6895-078.1234", true, 35.6895, -78.1234},
+		{"+35.6895+139.6917/\x00\x00", true, 35.6895, 139.6917},
+		{"+95.0000+139.6917/", false, 0, 0},
+		{"+35.6895+189.6917/", false, 0, 0},
+		{"+35.6895/", false, 0, 0},
+		{"+35.68a5+139.6917/", false, 0, 0},
+		{"", false, 0, 0},
+	}
+
+	for _, test := range tests {
+		p := &mp4Parser{info: &VideoInfo{}}
+		p.setLocation(test.text)
+		if p.info.HasLocation != test.valid || p.info.Latitude != test.latitude || p.info.Longitude != test.longitude {
+			t.Errorf("setLocation(%q) = %v %v,%v, want %v %v,%v", test.text, p.info.HasLocation, p.info.Latitude, p.info.Longitude,
+				test.valid, test.latitude, test.longitude)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	asset_create "github.com/mahdi-cpp/api-go-pkg/exif"
+	"github.com/mahdi-cpp/photocloud_v2/config"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const pairMaxGap = 3 * time.Second // between the capture dates of the two files of a pair
+
+// Kinds of originals, a photo pairs with a video or with a RAW
+const (
+	kindPhoto = "photo"
+	kindVideo = "video"
+	kindRaw   = "raw"
+)
+
+// pairInfo is what tells that an upload is the other half of an asset
+type pairInfo struct {
+	kind              string
+	baseName          string // original name without extension, lower case
+	captured          time.Time
+	contentIdentifier string
+}
+
+// readPairInfo reads what pairs an upload, photos from the metadata already read
+// and videos from their container
+func readPairInfo(format *mediaFormat, originalName string, data []byte, meta *photoMetadata) pairInfo {
+
+	info := pairInfo{kind: mediaKind(format.Name, format.MimeType), baseName: pairBaseName(originalName)}
+
+	switch {
+	case meta != nil:
+		info.captured, info.contentIdentifier = meta.CapturedDate, meta.ContentIdentifier
+	case info.kind == kindVideo:
+		if video, err := parseMP4(bytes.NewReader(data), int64(len(data))); err == nil {
+			info.captured, info.contentIdentifier = video.CreationTime, video.ContentIdentifier
+		}
+	}
+
+	return info
+}
+
+func mediaKind(name string, mimeType string) string {
+	if strings.HasPrefix(mimeType, "video/") {
+		return kindVideo
+	}
+	for _, format := range rawFormats {
+		if format.Name == name {
+			return kindRaw
+		}
+	}
+	return kindPhoto
+}
+
+func pairBaseName(name string) string {
+	name = filepath.Base(name)
+	return strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+// pairResourceType returns the resource type the second file of a pair takes next
+// to the original, empty when the kinds do not pair that way
+func pairResourceType(original string, second string) string {
+	switch {
+	case original == kindPhoto && second == kindVideo:
+		return model.ResourcePairedVideo
+	case original == kindPhoto && second == kindRaw:
+		return model.ResourceAlternatePhoto
+	}
+	return ""
+}
+
+// findPair returns the asset an upload belongs to: the same Live Photo content
+// identifier, or the same original name taken at the same time. Callers hold the lock.
+func (userStorage *UserStorage) findPair(info pairInfo) (*common_models.PHAsset, *model.AssetDetails) {
+
+	for id, details := range userStorage.details {
+		asset, exists := userStorage.assets[id]
+		if !exists {
+			continue
+		}
+
+		kind := mediaKind(details.Format, details.MimeType)
+		resourceType := pairResourceType(kind, info.kind)
+		if resourceType == "" {
+			resourceType = pairResourceType(info.kind, kind)
+		}
+		if resourceType == "" || hasResource(details, resourceType) {
+			continue
+		}
+
+		if info.contentIdentifier != "" && details.ContentIdentifier != "" {
+			if info.contentIdentifier == details.ContentIdentifier {
+				return asset, details
+			}
+			continue
+		}
+
+		if info.baseName != "" && info.baseName == pairBaseName(details.OriginalName) &&
+			!info.captured.IsZero() && !asset.CapturedDate.IsZero() && info.captured.Sub(asset.CapturedDate).Abs() <= pairMaxGap {
+			return asset, details
+		}
+	}
+
+	return nil, nil
+}
+
+func hasResource(details *model.AssetDetails, resourceType string) bool {
+	for _, resource := range details.Resources {
+		if resource.Type == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+// pairUpload adds an upload to the asset it pairs with. A video or a RAW becomes a
+// resource of the photo; a photo becomes the original of the asset, the video or
+// RAW it replaces a resource. Callers hold the write lock.
+func (userStorage *UserStorage) pairUpload(asset *common_models.PHAsset, details *model.AssetDetails, info pairInfo, format *mediaFormat, originalName string, data []byte, meta *photoMetadata) (*common_models.PHAsset, error) {
+
+	kind := mediaKind(details.Format, details.MimeType)
+
+	if resourceType := pairResourceType(kind, info.kind); resourceType != "" {
+		resource, err := userStorage.saveResource(asset.ID, resourceType, format.Ext, data)
+		if err != nil {
+			return nil, err
+		}
+		details.Resources = append(details.Resources, model.AssetResource{
+			Type:         resourceType,
+			Filename:     resource,
+			MimeType:     format.MimeType,
+			Size:         int64(len(data)),
+			OriginalName: originalName,
+		})
+		if details.ContentIdentifier == "" {
+			details.ContentIdentifier = info.contentIdentifier
+		}
+		if _, err := userStorage.DetailsManager.Update(details); err != nil {
+			return nil, err
+		}
+		return asset, nil
+	}
+
+	if err := userStorage.replaceOriginal(asset, details, pairResourceType(info.kind, kind), format, originalName, data, meta); err != nil {
+		return nil, err
+	}
+
+	// The poster of a video that arrived first is made again from the photo
+	if kind == kindVideo {
+		go userStorage.photoPoster(asset.ID)
+	}
+
+	go func() {
+		if _, err := userStorage.ScanFaces([]int{asset.ID}); err != nil {
+			log.Printf("face scan failed for asset %d: %v", asset.ID, err)
+		}
+	}()
+
+	return asset, nil
+}
+
+// replaceOriginal makes a photo the original of an asset and keeps the former
+// original as a resource. What users set on the asset is kept. Callers hold the
+// write lock.
+func (userStorage *UserStorage) replaceOriginal(asset *common_models.PHAsset, details *model.AssetDetails, resourceType string, format *mediaFormat, originalName string, data []byte, meta *photoMetadata) error {
+
+	dir := config.GetUserPath(userStorage.user.PhoneNumber, "assets")
+	wasVideo := IsVideoFile(asset.Filename)
+
+	resource := fmt.Sprintf("%d_%s%s", asset.ID, resourceType, filepath.Ext(asset.Filename))
+	if err := os.Rename(filepath.Join(dir, asset.Filename), filepath.Join(dir, resource)); err != nil {
+		return fmt.Errorf("failed to keep the original as a resource: %w", err)
+	}
+	var resourceSize int64
+	if info, err := os.Stat(filepath.Join(dir, resource)); err == nil {
+		resourceSize = info.Size()
+	}
+
+	filename := fmt.Sprintf("%d%s", asset.ID, format.Ext)
+	if err := os.WriteFile(filepath.Join(dir, filename), data, 0644); err != nil {
+		os.Rename(filepath.Join(dir, resource), filepath.Join(dir, asset.Filename))
+		return fmt.Errorf("failed to save asset: %w", err)
+	}
+	userStorage.addOriginalBytes(int64(len(data)))
+
+	userStorage.unindexAsset(asset)
+	asset.Filename = filename
+	asset.MediaType = asset_create.GetMediaType(format.Ext)
+	if meta != nil {
+		fillPhotoMetadata(asset, meta)
+	}
+	asset.ModificationDate = time.Now()
+	userStorage.indexAsset(asset)
+
+	if err := userStorage.metadata.SaveMetadata(asset); err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	previous := *details
+	userStorage.deleteDetails(asset.ID)
+
+	fresh, err := userStorage.createDetails(asset, format, originalName, data, meta)
+	if err != nil {
+		return err
+	}
+	fresh.Resources = append(previous.Resources, model.AssetResource{
+		Type:         resourceType,
+		Filename:     resource,
+		MimeType:     previous.MimeType,
+		Size:         resourceSize,
+		OriginalName: previous.OriginalName,
+	})
+	if fresh.ContentIdentifier == "" {
+		fresh.ContentIdentifier = previous.ContentIdentifier
+	}
+	if previous.Rating > 0 {
+		fresh.Rating = previous.Rating
+	}
+	if previous.Label != "" {
+		fresh.Label = previous.Label
+	}
+	if previous.Caption != "" {
+		fresh.Caption = previous.Caption
+	}
+	for _, keyword := range previous.Keywords {
+		fresh.Keywords = appendKeyword(fresh.Keywords, keyword)
+	}
+	if _, err := userStorage.DetailsManager.Update(fresh); err != nil {
+		return err
+	}
+
+	// The video is a resource now, the asset is no longer one
+	if wasVideo {
+		userStorage.deleteVideoMetadata(asset.ID)
+	}
+
+	userStorage.derivatives.removeAsset(asset.ID, userStorage.addThumbnailBytes)
+	return nil
+}
+
+// saveResource writes a resource file as "<asset ID>_<type>.<ext>", the name hides
+// it with its asset
+func (userStorage *UserStorage) saveResource(assetID int, resourceType string, ext string, data []byte) (string, error) {
+
+	filename := fmt.Sprintf("%d_%s%s", assetID, resourceType, ext)
+	path := filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "assets"), filename)
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to save resource: %w", err)
+	}
+	userStorage.addOriginalBytes(int64(len(data)))
+
+	return filename, nil
+}
+
+// GetResources lists the files of an asset, the original first
+func (userStorage *UserStorage) GetResources(assetID int) ([]model.AssetResource, error) {
+
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+
+	asset, exists := userStorage.assets[assetID]
+	if !exists {
+		return nil, ErrAssetNotFound
+	}
+
+	original := model.AssetResource{
+		Type:     model.ResourcePhoto,
+		Filename: asset.Filename,
+		MimeType: ContentType(asset.Filename),
+	}
+	if IsVideoFile(asset.Filename) {
+		original.Type = model.ResourceVideo
+	}
+	if info, err := os.Stat(filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "assets"), asset.Filename)); err == nil {
+		original.Size = info.Size()
+	}
+
+	resources := []model.AssetResource{original}
+	if details, exists := userStorage.details[assetID]; exists {
+		resources[0].MimeType, resources[0].OriginalName = details.MimeType, details.OriginalName
+		resources = append(resources, details.Resources...)
+	}
+
+	return resources, nil
+}
+
+// ResourceFilename returns the file of a resource of an asset, the original when
+// the type is empty
+func (userStorage *UserStorage) ResourceFilename(assetID int, resourceType string) (string, error) {
+
+	resources, err := userStorage.GetResources(assetID)
+	if err != nil {
+		return "", err
+	}
+	if resourceType == "" {
+		return resources[0].Filename, nil
+	}
+	for _, resource := range resources {
+		if resource.Type == resourceType {
+			return resource.Filename, nil
+		}
+	}
+	return "", ErrResourceNotFound
+}
@@ -0,0 +1,472 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/config"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+)
+
+const (
+	avatarSize   = 256
+	avatarMargin = 0.25 // of the face box, added on every side of the crop
+)
+
+// Face regions link a box on an asset to a person. The asset Persons list stays the
+// source of truth for collections, regions keep it in sync when they are tagged.
+
+func (userStorage *UserStorage) GetFaceRegions(assetID int, personID int) ([]*model.FaceRegion, error) {
+	return userStorage.FaceRegionManager.GetList(func(a *model.FaceRegion) bool {
+		return (assetID == 0 || a.AssetID == assetID) && (personID == 0 || a.PersonID == personID)
+	})
+}
+
+// TagFaceRegion creates a region or updates the box and person of an existing one
+func (userStorage *UserStorage) TagFaceRegion(request model.FaceRegionRequest) (*model.FaceRegion, error) {
+
+	if request.PersonID != 0 {
+		if _, err := userStorage.PersonManager.Get(request.PersonID); err != nil {
+			return nil, ErrPersonNotFound
+		}
+	}
+
+	region := &model.FaceRegion{AssetID: request.AssetID, Source: model.FaceSourceManual}
+	if request.ID != 0 {
+		item, err := userStorage.FaceRegionManager.Get(request.ID)
+		if err != nil {
+			return nil, ErrFaceRegionNotFound
+		}
+		region = item
+	} else if request.X == nil || request.Y == nil || request.Width == nil || request.Height == nil {
+		return nil, ErrInvalidFaceRegion
+	}
+
+	if _, exists := userStorage.GetAssetCopy(region.AssetID); !exists {
+		return nil, ErrAssetNotFound
+	}
+
+	if request.X != nil {
+		region.X = *request.X
+	}
+	if request.Y != nil {
+		region.Y = *request.Y
+	}
+	if request.Width != nil {
+		region.Width = *request.Width
+	}
+	if request.Height != nil {
+		region.Height = *request.Height
+	}
+	if !validFaceBox(region) {
+		return nil, ErrInvalidFaceRegion
+	}
+
+	previousPersonID := region.PersonID
+	region.PersonID = request.PersonID
+
+	var err error
+	if region.ID == 0 {
+		region, err = userStorage.FaceRegionManager.Create(region)
+	} else {
+		region, err = userStorage.FaceRegionManager.Update(region)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if previousPersonID != 0 && previousPersonID != region.PersonID {
+		if err := userStorage.unlinkPersonIfUntagged(region.AssetID, previousPersonID); err != nil {
+			return nil, err
+		}
+		if person, err := userStorage.PersonManager.Get(previousPersonID); err == nil && person.AvatarRegionID == region.ID {
+			userStorage.clearPersonAvatar(person)
+		}
+	}
+
+	if region.PersonID != 0 {
+		update := common_models.AssetUpdate{AssetIds: []int{region.AssetID}, AddPersons: []int{region.PersonID}}
+		if _, err := userStorage.UpdateAsset(model.AssetUpdate{AssetUpdate: update}); err != nil {
+			return nil, err
+		}
+	}
+
+	userStorage.UpdateCollections()
+
+	return region, nil
+}
+
+// UntagFaceRegion clears the person of a region, the asset leaves the person
+// when no other region of the asset is tagged with them
+func (userStorage *UserStorage) UntagFaceRegion(id int) (*model.FaceRegion, error) {
+
+	region, err := userStorage.FaceRegionManager.Get(id)
+	if err != nil {
+		return nil, ErrFaceRegionNotFound
+	}
+
+	personID := region.PersonID
+	if personID == 0 {
+		return region, nil
+	}
+
+	region.PersonID = 0
+	region, err = userStorage.FaceRegionManager.Update(region)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := userStorage.unlinkPersonIfUntagged(region.AssetID, personID); err != nil {
+		return nil, err
+	}
+
+	if person, err := userStorage.PersonManager.Get(personID); err == nil && person.AvatarRegionID == id {
+		userStorage.clearPersonAvatar(person)
+	}
+
+	userStorage.UpdateCollections()
+
+	return region, nil
+}
+
+// MergePersons moves the assets and face regions of merge.PersonIds into merge.ID and deletes them
+func (userStorage *UserStorage) MergePersons(merge model.PersonMerge) (*model.Person, error) {
+
+	target, err := userStorage.PersonManager.Get(merge.ID)
+	if err != nil {
+		return nil, ErrPersonNotFound
+	}
+
+	var sources []*model.Person
+	sourceSet := make(map[int]bool)
+	for _, id := range merge.PersonIds {
+		if id == target.ID || sourceSet[id] {
+			continue
+		}
+		source, err := userStorage.PersonManager.Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("person %d: %w", id, ErrPersonNotFound)
+		}
+		sources = append(sources, source)
+		sourceSet[id] = true
+	}
+	if len(sources) == 0 {
+		return target, nil
+	}
+
+	sourceIds := make([]int, 0, len(sources))
+	for _, source := range sources {
+		sourceIds = append(sourceIds, source.ID)
+	}
+
+	// Rewrite the Persons list of every asset of the merged persons
+	update := common_models.AssetUpdate{
+		AssetIds:      userStorage.personAssetIds(sourceSet),
+		AddPersons:    []int{target.ID},
+		RemovePersons: sourceIds,
+	}
+	if len(update.AssetIds) > 0 {
+		if _, err := userStorage.UpdateAsset(model.AssetUpdate{AssetUpdate: update}); err != nil {
+			return nil, err
+		}
+	}
+
+	regions, err := userStorage.FaceRegionManager.GetList(func(a *model.FaceRegion) bool {
+		return sourceSet[a.PersonID]
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, region := range regions {
+		region.PersonID = target.ID
+		if _, err := userStorage.FaceRegionManager.Update(region); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, source := range sources {
+		if target.Avatar == "" && source.Avatar != "" {
+			target.Avatar = source.Avatar
+			target.AvatarRegionID = source.AvatarRegionID
+		} else {
+			userStorage.removeAvatarFile(source.Avatar)
+		}
+		if err := userStorage.PersonManager.Delete(source.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	target, err = userStorage.PersonManager.Update(target)
+	if err != nil {
+		return nil, err
+	}
+
+	userStorage.UpdateCollections()
+
+	return target, nil
+}
+
+// SplitPerson moves the selected assets of a person, with their face regions,
+// to another person or to a new one
+func (userStorage *UserStorage) SplitPerson(split model.PersonSplit) (*model.Person, error) {
+
+	person, err := userStorage.PersonManager.Get(split.ID)
+	if err != nil {
+		return nil, ErrPersonNotFound
+	}
+
+	var assetIds []int
+	assetSet := make(map[int]bool)
+	for _, id := range split.AssetIds {
+		asset, exists := userStorage.GetAssetCopy(id)
+		if !exists || !containsInt(asset.Persons, person.ID) {
+			return nil, fmt.Errorf("asset %d: %w", id, ErrAssetNotFound)
+		}
+		if !assetSet[id] {
+			assetIds = append(assetIds, id)
+			assetSet[id] = true
+		}
+	}
+	if len(assetIds) == 0 {
+		return nil, ErrInvalidUpdate
+	}
+
+	var target *model.Person
+	if split.PersonID != 0 {
+		if split.PersonID == person.ID {
+			return nil, ErrInvalidUpdate
+		}
+		target, err = userStorage.PersonManager.Get(split.PersonID)
+		if err != nil {
+			return nil, ErrPersonNotFound
+		}
+	} else {
+		target, err = userStorage.PersonManager.Create(&model.Person{Title: split.Title})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	update := common_models.AssetUpdate{
+		AssetIds:      assetIds,
+		AddPersons:    []int{target.ID},
+		RemovePersons: []int{person.ID},
+	}
+	if _, err := userStorage.UpdateAsset(model.AssetUpdate{AssetUpdate: update}); err != nil {
+		return nil, err
+	}
+
+	regions, err := userStorage.FaceRegionManager.GetList(func(a *model.FaceRegion) bool {
+		return a.PersonID == person.ID && assetSet[a.AssetID]
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, region := range regions {
+		region.PersonID = target.ID
+		if _, err := userStorage.FaceRegionManager.Update(region); err != nil {
+			return nil, err
+		}
+		if person.AvatarRegionID == region.ID {
+			userStorage.clearPersonAvatar(person)
+		}
+	}
+
+	userStorage.UpdateCollections()
+
+	return target, nil
+}
+
+// SetPersonAvatar crops a face region of the person from the original image
+// and saves it next to the thumbnails as the person avatar
+func (userStorage *UserStorage) SetPersonAvatar(request model.PersonAvatar) (*model.Person, error) {
+
+	person, err := userStorage.PersonManager.Get(request.ID)
+	if err != nil {
+		return nil, ErrPersonNotFound
+	}
+
+	region, err := userStorage.FaceRegionManager.Get(request.FaceRegionID)
+	if err != nil || region.PersonID != person.ID {
+		return nil, ErrFaceRegionNotFound
+	}
+
+	asset, exists := userStorage.GetAssetCopy(region.AssetID)
+	if !exists {
+		return nil, ErrAssetNotFound
+	}
+
+	img, err := userStorage.decodeOriginal(asset)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, cropFace(img, region, avatarSize), &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("failed to encode avatar: %w", err)
+	}
+
+	// The region id is part of the name so cached avatars are never served stale
+	filename := fmt.Sprintf("person_%d_%d.jpg", person.ID, region.ID)
+	if err := os.WriteFile(filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "thumbnails"), filename), buf.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to save avatar: %w", err)
+	}
+	userStorage.addThumbnailBytes(int64(buf.Len()), 1)
+
+	if person.Avatar != filename {
+		userStorage.removeAvatarFile(person.Avatar)
+	}
+	person.Avatar = filename
+	person.AvatarRegionID = region.ID
+
+	return userStorage.PersonManager.Update(person)
+}
+
+// unlinkPersonIfUntagged removes the person from the asset when no region of the asset is tagged with them
+func (userStorage *UserStorage) unlinkPersonIfUntagged(assetID int, personID int) error {
+
+	regions, err := userStorage.FaceRegionManager.GetList(func(a *model.FaceRegion) bool {
+		return a.AssetID == assetID && a.PersonID == personID
+	})
+	if err != nil || len(regions) > 0 {
+		return err
+	}
+
+	update := common_models.AssetUpdate{AssetIds: []int{assetID}, RemovePersons: []int{personID}}
+	_, err = userStorage.UpdateAsset(model.AssetUpdate{AssetUpdate: update})
+	return err
+}
+
+func (userStorage *UserStorage) personAssetIds(persons map[int]bool) []int {
+
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+
+	var ids []int
+	for _, asset := range userStorage.assets {
+		for _, id := range asset.Persons {
+			if persons[id] {
+				ids = append(ids, asset.ID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+func (userStorage *UserStorage) clearPersonAvatar(person *model.Person) {
+	userStorage.removeAvatarFile(person.Avatar)
+	person.Avatar = ""
+	person.AvatarRegionID = 0
+	_, _ = userStorage.PersonManager.Update(person)
+}
+
+func (userStorage *UserStorage) removeAvatarFile(filename string) {
+	if filename == "" {
+		return
+	}
+	path := filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "thumbnails"), filename)
+	if info, err := os.Stat(path); err == nil && os.Remove(path) == nil {
+		userStorage.addThumbnailBytes(-info.Size(), -1)
+	}
+}
+
+func validFaceBox(region *model.FaceRegion) bool {
+	return region.X >= 0 && region.Y >= 0 && region.Width > 0 && region.Height > 0 &&
+		region.X+region.Width <= 1 && region.Y+region.Height <= 1
+}
+
+// cropFace cuts a square around the face box with some margin and scales it down to size
+func cropFace(img image.Image, region *model.FaceRegion, size int) image.Image {
+
+	bounds := img.Bounds()
+	w := float64(bounds.Dx())
+	h := float64(bounds.Dy())
+
+	side := max(region.Width*w, region.Height*h) * (1 + 2*avatarMargin)
+	side = min(side, w, h)
+
+	cx := bounds.Min.X + int((region.X+region.Width/2)*w)
+	cy := bounds.Min.Y + int((region.Y+region.Height/2)*h)
+
+	crop := image.Rect(cx-int(side/2), cy-int(side/2), cx+int(side/2), cy+int(side/2))
+
+	// Keep the square inside the image
+	if crop.Min.X < bounds.Min.X {
+		crop = crop.Add(image.Pt(bounds.Min.X-crop.Min.X, 0))
+	}
+	if crop.Min.Y < bounds.Min.Y {
+		crop = crop.Add(image.Pt(0, bounds.Min.Y-crop.Min.Y))
+	}
+	if crop.Max.X > bounds.Max.X {
+		crop = crop.Add(image.Pt(bounds.Max.X-crop.Max.X, 0))
+	}
+	if crop.Max.Y > bounds.Max.Y {
+		crop = crop.Add(image.Pt(0, bounds.Max.Y-crop.Max.Y))
+	}
+	crop = crop.Intersect(bounds)
+
+	return scaleImage(img, crop, size)
+}
+
+// scaleImage scales the src rectangle of img down to fit size, averaging the covered pixels
+func scaleImage(img image.Image, src image.Rectangle, size int) image.Image {
+
+	dw, dh := src.Dx(), src.Dy()
+	if dw > size || dh > size {
+		if dw >= dh {
+			dh = max(1, dh*size/dw)
+			dw = size
+		} else {
+			dw = max(1, dw*size/dh)
+			dh = size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0 := src.Min.Y + y*src.Dy()/dh
+		y1 := max(y0+1, src.Min.Y+(y+1)*src.Dy()/dh)
+
+		for x := 0; x < dw; x++ {
+			x0 := src.Min.X + x*src.Dx()/dw
+			x1 := max(x0+1, src.Min.X+(x+1)*src.Dx()/dw)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
+
+func containsInt(items []int, value int) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
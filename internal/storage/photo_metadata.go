@@ -0,0 +1,438 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"github.com/mahdi-cpp/photocloud_v2/config"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	xmpMaxPacket   = 1 << 20
+	exifDateLayout = "2006:01:02 15:04:05"
+)
+
+// IPTC datasets of record 2 read by the server
+const (
+	iptcObjectName = 5
+	iptcKeywords   = 25
+	iptcCaption    = 120
+)
+
+var iptcDatasetNames = map[byte]string{
+	iptcObjectName: "ObjectName",
+	iptcKeywords:   "Keywords",
+	40:             "SpecialInstructions",
+	55:             "DateCreated",
+	60:             "TimeCreated",
+	80:             "By-line",
+	90:             "City",
+	95:             "Province-State",
+	101:            "Country-PrimaryLocationName",
+	105:            "Headline",
+	110:            "Credit",
+	115:            "Source",
+	116:            "CopyrightNotice",
+	iptcCaption:    "Caption-Abstract",
+}
+
+// XMP namespaces of the properties read by the server
+const (
+	xmpNamespaceXMP       = "http://ns.adobe.com/xap/1.0/"
+	xmpNamespaceDC        = "http://purl.org/dc/elements/1.1/"
+	xmpNamespaceLightroom = "http://ns.adobe.com/lightroom/1.0/"
+)
+
+// photoMetadata is what the in-process extractor reads from an image: EXIF, IPTC
+// and XMP. Keywords and caption come from XMP first, then IPTC.
+type photoMetadata struct {
+	Width        int // stored size, before orientation
+	Height       int
+	Orientation  int
+	CameraMake   string
+	CameraModel  string
+	Lens         string
+	CapturedDate time.Time
+	TimeZone     string // "+03:30", empty when the offset is unknown
+	Latitude     float64
+	Longitude    float64
+	HasLocation  bool
+	Altitude     *float64
+	FocalLength  float64
+	Aperture     float64
+	ExposureTime string
+	ISO          int
+	Flash        *bool
+	Caption      string
+	Keywords     []string
+	Rating       int
+	Label        string
+
+	ContentIdentifier string // Live Photo
+}
+
+// readPhotoMetadata extracts the metadata of an image file
+func readPhotoMetadata(data []byte) *photoMetadata {
+
+	meta := &photoMetadata{Orientation: 1}
+
+	tags := readExif(data)
+	if tags != nil {
+		meta.readExif(tags)
+	}
+
+	iptc := readIPTC(data)
+	xmp := parseXMP(findXMP(data))
+
+	meta.Rating, meta.Label = xmp.rating, xmp.label
+	meta.Keywords = xmp.keywords
+	if len(meta.Keywords) == 0 {
+		meta.Keywords = iptc[iptcKeywords]
+	}
+	switch {
+	case xmp.description != "":
+		meta.Caption = xmp.description
+	case len(iptc[iptcCaption]) > 0:
+		meta.Caption = iptc[iptcCaption][0]
+	case tags != nil:
+		meta.Caption = tags.string(tags.ifd0, exifTagDescription)
+	}
+
+	if meta.Width == 0 || meta.Height == 0 {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			meta.Width, meta.Height = cfg.Width, cfg.Height
+		} else if tags != nil {
+			width, _ := tags.uint(tags.ifd0, exifTagImageWidth)
+			height, _ := tags.uint(tags.ifd0, exifTagImageHeight)
+			meta.Width, meta.Height = int(width), int(height)
+		}
+	}
+
+	return meta
+}
+
+func (meta *photoMetadata) readExif(tags *exifTags) {
+
+	meta.Orientation = tags.orientation()
+	meta.CameraMake = tags.string(tags.ifd0, exifTagMake)
+	meta.CameraModel = tags.string(tags.ifd0, exifTagModel)
+	meta.ContentIdentifier = tags.appleContentIdentifier()
+
+	meta.Lens = tags.string(tags.exif, exifTagLensModel)
+	if lensMake := tags.string(tags.exif, exifTagLensMake); lensMake != "" && meta.Lens != "" && !strings.HasPrefix(meta.Lens, lensMake) {
+		meta.Lens = lensMake + " " + meta.Lens
+	}
+
+	if width, ok := tags.uint(tags.exif, exifTagPixelX); ok {
+		if height, ok := tags.uint(tags.exif, exifTagPixelY); ok && width > 0 && height > 0 {
+			meta.Width, meta.Height = int(width), int(height)
+		}
+	}
+
+	meta.FocalLength, _ = tags.rational(tags.exif, exifTagFocalLength)
+	meta.Aperture, _ = tags.rational(tags.exif, exifTagFNumber)
+	if exposure, ok := tags.rational(tags.exif, exifTagExposureTime); ok && exposure > 0 {
+		meta.ExposureTime = formatExposure(exposure)
+	}
+	if iso, ok := tags.uint(tags.exif, exifTagISO); ok {
+		meta.ISO = int(iso)
+	}
+	if flash, ok := tags.uint(tags.exif, exifTagFlash); ok {
+		fired := flash&1 == 1
+		meta.Flash = &fired
+	}
+
+	// GPS position
+	lat, lon := tags.rationals(tags.gps, gpsTagLatitude), tags.rationals(tags.gps, gpsTagLongitude)
+	if len(lat) == 3 && len(lon) == 3 {
+		latitude := lat[0] + lat[1]/60 + lat[2]/3600
+		longitude := lon[0] + lon[1]/60 + lon[2]/3600
+		if tags.string(tags.gps, gpsTagLatitudeRef) == "S" {
+			latitude = -latitude
+		}
+		if tags.string(tags.gps, gpsTagLongitudeRef) == "W" {
+			longitude = -longitude
+		}
+		if latitude >= -90 && latitude <= 90 && longitude >= -180 && longitude <= 180 && (latitude != 0 || longitude != 0) {
+			meta.Latitude, meta.Longitude, meta.HasLocation = latitude, longitude, true
+		}
+	}
+	if altitude, ok := tags.rational(tags.gps, gpsTagAltitude); ok {
+		if ref, _ := tags.uint(tags.gps, gpsTagAltitudeRef); ref == 1 {
+			altitude = -altitude
+		}
+		meta.Altitude = &altitude
+	}
+
+	meta.readCapturedDate(tags)
+}
+
+// readCapturedDate reads the original date with its offset. Without an offset tag
+// the offset is found from the GPS time, which is UTC, or the server zone is used.
+func (meta *photoMetadata) readCapturedDate(tags *exifTags) {
+
+	text := tags.string(tags.exif, exifTagDateTimeOriginal)
+	if text == "" {
+		text = tags.string(tags.ifd0, exifTagDateTime)
+	}
+	local, err := time.Parse(exifDateLayout, text)
+	if err != nil {
+		return
+	}
+
+	if offset := tags.string(tags.exif, exifTagOffsetOriginal); offset != "" {
+		if zone, err := time.Parse("-07:00", offset); err == nil {
+			_, seconds := zone.Zone()
+			meta.CapturedDate = time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), 0, time.FixedZone(offset, seconds))
+			meta.TimeZone = offset
+			return
+		}
+	}
+
+	stamp := tags.rationals(tags.gps, gpsTagTimeStamp)
+	if day, err := time.Parse("2006:01:02", tags.string(tags.gps, gpsTagDateStamp)); err == nil && len(stamp) == 3 {
+		utc := day.Add(time.Duration(stamp[0]*float64(time.Hour) + stamp[1]*float64(time.Minute) + stamp[2]*float64(time.Second)))
+		// Zones are multiples of a quarter hour
+		quarters := math.Round(local.Sub(utc).Minutes() / 15)
+		if math.Abs(quarters) <= 14*4 {
+			seconds := int(quarters) * 15 * 60
+			meta.TimeZone = formatOffset(seconds)
+			meta.CapturedDate = time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), 0, time.FixedZone(meta.TimeZone, seconds))
+			return
+		}
+	}
+
+	meta.CapturedDate = time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), 0, time.Local)
+}
+
+func formatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign, seconds = '-', -seconds
+	}
+	return fmt.Sprintf("%c%02d:%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+// formatExposure writes exposure times as photographers do: "1/250", "2.5"
+func formatExposure(seconds float64) string {
+	if seconds < 1 {
+		return fmt.Sprintf("1/%d", int(math.Round(1/seconds)))
+	}
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.1f", seconds), "0"), ".")
+}
+
+// readIPTC reads the IIM datasets of record 2 from the Photoshop APP13 segment of
+// a JPEG, by dataset number
+func readIPTC(data []byte) map[byte][]string {
+
+	datasets := make(map[byte][]string)
+
+	resources := findJPEGSegment(data, 0xED, []byte("Photoshop 3.0\x00"))
+	for len(resources) >= 12 && bytes.HasPrefix(resources, []byte("8BIM")) {
+		id := binary.BigEndian.Uint16(resources[4:6])
+
+		// Pascal string name, padded to an even length
+		nameLength := int(resources[6]) + 1
+		nameLength += nameLength % 2
+		if 6+nameLength+4 > len(resources) {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(resources[6+nameLength:]))
+		start := 6 + nameLength + 4
+		if start+size > len(resources) {
+			break
+		}
+
+		if id == 0x0404 {
+			iim := resources[start : start+size]
+			for i := 0; i+5 <= len(iim) && iim[i] == 0x1C; {
+				record, dataset := iim[i+1], iim[i+2]
+				length := int(binary.BigEndian.Uint16(iim[i+3:]))
+				if i+5+length > len(iim) {
+					break
+				}
+				if record == 2 {
+					datasets[dataset] = append(datasets[dataset], strings.TrimSpace(string(iim[i+5:i+5+length])))
+				}
+				i += 5 + length
+			}
+		}
+
+		// The padding byte of the last resource may be missing
+		resources = resources[min(start+size+size%2, len(resources)):]
+	}
+
+	return datasets
+}
+
+// findJPEGSegment returns the payload of the first segment with the marker and
+// prefix, without the prefix
+func findJPEGSegment(data []byte, marker byte, prefix []byte) []byte {
+
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return nil
+	}
+
+	for offset := 2; offset+4 <= len(data) && data[offset] == 0xFF; {
+		if data[offset+1] == 0xDA || data[offset+1] == 0xD9 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		if segment := data[offset+4 : end]; data[offset+1] == marker && bytes.HasPrefix(segment, prefix) {
+			return segment[len(prefix):]
+		}
+		offset = end
+	}
+
+	return nil
+}
+
+// findXMP returns the XMP packet of a file, wherever the format keeps it
+func findXMP(data []byte) []byte {
+
+	start := bytes.Index(data, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return nil
+	}
+	end := bytes.Index(data[start:], []byte("</x:xmpmeta>"))
+	if end < 0 || end > xmpMaxPacket {
+		return nil
+	}
+	return data[start : start+end+len("</x:xmpmeta>")]
+}
+
+type xmpProperties struct {
+	keywords    []string
+	description string
+	rating      int
+	label       string
+}
+
+// parseXMP reads the keywords, description, rating and label of an XMP packet.
+// Lightroom hierarchical keywords are preferred over flat ones, their levels joined
+// with "/".
+func parseXMP(packet []byte) xmpProperties {
+
+	var properties xmpProperties
+	if len(packet) == 0 {
+		return properties
+	}
+
+	var subjects, hierarchical []string
+	var stack []xml.Name
+
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+			for _, attr := range t.Attr {
+				properties.readSimple(attr.Name, attr.Value)
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text != "" && len(stack) > 0 {
+				properties.readSimple(stack[len(stack)-1], text)
+			}
+			if text == "" || len(stack) < 3 || stack[len(stack)-1].Local != "li" {
+				continue
+			}
+			// property > Bag/Seq/Alt > li
+			property := stack[len(stack)-3]
+			switch {
+			case property.Space == xmpNamespaceDC && property.Local == "subject":
+				subjects = append(subjects, text)
+			case property.Space == xmpNamespaceDC && property.Local == "description" && properties.description == "":
+				properties.description = text
+			case property.Space == xmpNamespaceLightroom && property.Local == "hierarchicalSubject":
+				hierarchical = append(hierarchical, strings.ReplaceAll(text, "|", "/"))
+			}
+		}
+	}
+
+	properties.keywords = subjects
+	if len(hierarchical) > 0 {
+		properties.keywords = hierarchical
+	}
+	return properties
+}
+
+// readSimple reads the properties written as attributes or simple elements. Rejected
+// photos, rated -1 by Lightroom, are left unrated.
+func (properties *xmpProperties) readSimple(name xml.Name, value string) {
+	if name.Space != xmpNamespaceXMP {
+		return
+	}
+	switch name.Local {
+	case "Rating":
+		if rating, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			properties.rating = min(ratingMax, max(0, rating))
+		}
+	case "Label":
+		if label := strings.ToLower(strings.TrimSpace(value)); validLabel(label) {
+			properties.label = label
+		}
+	}
+}
+
+// GetRawMetadata reads all the metadata of the original file of an asset
+func (userStorage *UserStorage) GetRawMetadata(assetID int) (*model.RawMetadata, error) {
+
+	asset, exists := userStorage.GetAssetCopy(assetID)
+	if !exists {
+		return nil, ErrAssetNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "assets"), asset.Filename))
+	if err != nil {
+		return nil, ErrAssetNotFound
+	}
+
+	raw := &model.RawMetadata{AssetID: assetID}
+
+	if tags := readExif(data); tags != nil {
+		raw.EXIF = tags.named(tags.ifd0, exifTagNames)
+		for name, value := range tags.named(tags.exif, exifTagNames) {
+			raw.EXIF[name] = value
+		}
+		if len(tags.gps) > 0 {
+			raw.GPS = tags.named(tags.gps, gpsTagNames)
+		}
+	}
+
+	if datasets := readIPTC(data); len(datasets) > 0 {
+		raw.IPTC = make(map[string][]string, len(datasets))
+		for dataset, values := range datasets {
+			name, exists := iptcDatasetNames[dataset]
+			if !exists {
+				name = fmt.Sprintf("2:%d", dataset)
+			}
+			raw.IPTC[name] = values
+		}
+	}
+
+	raw.XMP = string(findXMP(data))
+
+	return raw, nil
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReadPhotoMetadata(t *testing.T) {
+
+	tests := []struct {
+		fixture     string
+		width       int
+		height      int
+		orientation int
+		make        string
+		model       string
+		lens        string
+		captured    string // RFC 3339, empty when unknown
+		timeZone    string
+		latitude    float64
+		longitude   float64
+		caption     string
+		keywords    []string
+		rating      int
+		label       string
+	}{
+		{
+			fixture: "exif_le.jpg", width: 4032, height: 3024, orientation: 6,
+			make: "Apple", model: "iPhone 13", lens: "Apple iPhone 13 back camera",
+			captured: "2024-05-17T18:30:05+03:30", timeZone: "+03:30",
+			latitude: 35.6895, longitude: 51.3917,
+		},
+		{
+			// No offset tag, the zone comes from the GPS time stamp of the next day
+			fixture: "exif_be.jpg", width: 12, height: 10, orientation: 3, make: "Canon",
+			captured: "2023-12-31T23:10:00-03:00", timeZone: "-03:00",
+			latitude: -33.8667, longitude: -70.6667, caption: "Fireworks",
+		},
+		{
+			fixture: "iptc.jpg", width: 8, height: 8, orientation: 1,
+			caption: "IPTC caption", keywords: []string{"beach", "summer"},
+		},
+		{
+			// XMP wins over IPTC, hierarchical keywords over flat ones
+			fixture: "iptc_xmp.jpg", width: 8, height: 8, orientation: 1,
+			caption: "XMP caption", keywords: []string{"Places/Iran/Tehran", "People/Family"},
+			rating: 4, label: "red",
+		},
+		{
+			fixture: "preview.nef", orientation: 8, make: "Nikon",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+
+			meta := readPhotoMetadata(readFixture(t, test.fixture))
+
+			if meta.Width != test.width || meta.Height != test.height {
+				t.Errorf("size = %dx%d, want %dx%d", meta.Width, meta.Height, test.width, test.height)
+			}
+			if meta.Orientation != test.orientation {
+				t.Errorf("orientation = %d, want %d", meta.Orientation, test.orientation)
+			}
+			if meta.CameraMake != test.make || meta.CameraModel != test.model || meta.Lens != test.lens {
+				t.Errorf("camera = %q %q %q, want %q %q %q", meta.CameraMake, meta.CameraModel, meta.Lens, test.make, test.model, test.lens)
+			}
+
+			captured := ""
+			if !meta.CapturedDate.IsZero() {
+				captured = meta.CapturedDate.Format(time.RFC3339)
+			}
+			if captured != test.captured || meta.TimeZone != test.timeZone {
+				t.Errorf("captured = %q %q, want %q %q", captured, meta.TimeZone, test.captured, test.timeZone)
+			}
+
+			hasLocation := test.latitude != 0 || test.longitude != 0
+			if meta.HasLocation != hasLocation ||
+				!near(meta.Latitude, test.latitude, 1e-4) || !near(meta.Longitude, test.longitude, 1e-4) {
+				t.Errorf("location = %v %f,%f, want %f,%f", meta.HasLocation, meta.Latitude, meta.Longitude, test.latitude, test.longitude)
+			}
+
+			if meta.Caption != test.caption {
+				t.Errorf("caption = %q, want %q", meta.Caption, test.caption)
+			}
+			if !slices.Equal(meta.Keywords, test.keywords) {
+				t.Errorf("keywords = %q, want %q", meta.Keywords, test.keywords)
+			}
+			if meta.Rating != test.rating || meta.Label != test.label {
+				t.Errorf("rating = %d %q, want %d %q", meta.Rating, meta.Label, test.rating, test.label)
+			}
+		})
+	}
+}
+
+func TestReadPhotoMetadataExposure(t *testing.T) {
+
+	meta := readPhotoMetadata(readFixture(t, "exif_le.jpg"))
+
+	if meta.ExposureTime != "1/250" || meta.Aperture != 1.8 || meta.FocalLength != 26 || meta.ISO != 200 {
+		t.Errorf("exposure = %s f/%v %vmm ISO %d", meta.ExposureTime, meta.Aperture, meta.FocalLength, meta.ISO)
+	}
+	if meta.Flash == nil || !*meta.Flash {
+		t.Errorf("flash = %v, want fired", meta.Flash)
+	}
+	if meta.Altitude == nil || *meta.Altitude != 1200 {
+		t.Errorf("altitude = %v, want 1200", meta.Altitude)
+	}
+	if meta.ContentIdentifier != "8D6C1E5A-2B3F-4A7E-9C1D-0F2E3A4B5C6D" {
+		t.Errorf("content identifier = %q", meta.ContentIdentifier)
+	}
+}
+
+// Every prefix of the fixtures is read without panicking
+func TestReadPhotoMetadataTruncated(t *testing.T) {
+
+	for _, fixture := range []string{"exif_le.jpg", "exif_be.jpg", "iptc.jpg", "iptc_xmp.jpg", "preview.nef"} {
+		data := readFixture(t, fixture)
+		for n := 0; n < len(data); n++ {
+			meta := readPhotoMetadata(data[:n])
+			if meta.Orientation < 1 || meta.Orientation > 8 {
+				t.Fatalf("%s cut at %d: orientation %d", fixture, n, meta.Orientation)
+			}
+		}
+	}
+}
+
+func TestReadIPTC(t *testing.T) {
+
+	resource := func(size int, payload ...byte) []byte {
+		data := []byte("8BIM\x04\x04\x00\x00")
+		data = append(data, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+		return append(data, payload...)
+	}
+	jpegWith := func(resources []byte) []byte {
+		payload := append([]byte("Photoshop 3.0\x00"), resources...)
+		length := len(payload) + 2
+		return append([]byte{0xFF, 0xD8, 0xFF, 0xED, byte(length >> 8), byte(length)}, payload...)
+	}
+	keyword := []byte{0x1C, 2, iptcKeywords, 0, 3, 's', 'e', 'a'}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{"keyword", jpegWith(resource(len(keyword), keyword...)), []string{"sea"}},
+		{"odd size at the end", jpegWith(resource(5, 0x1C, 2, iptcKeywords, 0, 0)), []string{""}},
+		{"size past the end", jpegWith(resource(100, keyword...)), nil},
+		{"dataset past the end", jpegWith(resource(6, 0x1C, 2, iptcKeywords, 0, 9, 'x')), nil},
+		{"name past the end", jpegWith([]byte("8BIM\x04\x04\xFF\x00\x00\x00\x00\x00")), nil},
+		{"not a resource", jpegWith([]byte("XXXX\x04\x04\x00\x00\x00\x00\x00\x00")), nil},
+		{"no segment", []byte{0xFF, 0xD8, 0xFF, 0xD9}, nil},
+		{"empty", nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := readIPTC(test.data)[iptcKeywords]; !slices.Equal(got, test.want) {
+				t.Errorf("keywords = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFindJPEGSegment(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"found", []byte("\xFF\xD8\xFF\xE1\x00\x07Exif!"), "!"},
+		{"after another segment", []byte("\xFF\xD8\xFF\xE0\x00\x04JF\xFF\xE1\x00\x07Exif!"), "!"},
+		{"other prefix", []byte("\xFF\xD8\xFF\xE1\x00\x07XMP!!"), ""},
+		{"stops at scan", []byte("\xFF\xD8\xFF\xDA\x00\x02\xFF\xE1\x00\x07Exif!"), ""},
+		{"length past the end", []byte("\xFF\xD8\xFF\xE1\x00\xFFExif!"), ""},
+		{"length below 2", []byte("\xFF\xD8\xFF\xE1\x00\x01Exif!"), ""},
+		{"not a marker", []byte("\xFF\xD8\x00\xE1\x00\x07Exif!"), ""},
+		{"not a JPEG", []byte("Exif!"), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(findJPEGSegment(test.data, 0xE1, []byte("Exif"))); got != test.want {
+				t.Errorf("segment = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseXMP(t *testing.T) {
+
+	const open = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:lr="http://ns.adobe.com/lightroom/1.0/"`
+	const close = `</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+	tests := []struct {
+		name     string
+		packet   string
+		keywords []string
+		caption  string
+		rating   int
+		label    string
+	}{
+		{
+			name:     "subjects",
+			packet:   open + `><dc:subject><rdf:Bag><rdf:li>a</rdf:li><rdf:li> b </rdf:li></rdf:Bag></dc:subject>` + close,
+			keywords: []string{"a", "b"},
+		},
+		{
+			name: "hierarchical preferred",
+			packet: open + `><dc:subject><rdf:Bag><rdf:li>c</rdf:li></rdf:Bag></dc:subject>` +
+				`<lr:hierarchicalSubject><rdf:Bag><rdf:li>a|b|c</rdf:li></rdf:Bag></lr:hierarchicalSubject>` + close,
+			keywords: []string{"a/b/c"},
+		},
+		{
+			name:    "first description",
+			packet:  open + `><dc:description><rdf:Alt><rdf:li>one</rdf:li><rdf:li>two</rdf:li></rdf:Alt></dc:description>` + close,
+			caption: "one",
+		},
+		{name: "rating attribute", packet: open + ` xmp:Rating="3" xmp:Label="Blue">` + close, rating: 3, label: "blue"},
+		{name: "rating element", packet: open + `><xmp:Rating>2</xmp:Rating>` + close, rating: 2},
+		{name: "rejected", packet: open + ` xmp:Rating="-1">` + close},
+		{name: "rating above the maximum", packet: open + ` xmp:Rating="9">` + close, rating: ratingMax},
+		{name: "unknown label", packet: open + ` xmp:Label="Approved">` + close},
+		{name: "other namespace", packet: open + ` xmlns:o="urn:other" o:Rating="5">` + close},
+		{
+			name:     "unterminated",
+			packet:   open + ` xmp:Rating="1"><dc:subject><rdf:Bag><rdf:li>a</rdf:li><rdf:li>b`,
+			keywords: []string{"a", "b"}, rating: 1, // what was read before the error is kept
+		},
+		{name: "not XML", packet: "<<<>>>"},
+		{name: "empty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			properties := parseXMP([]byte(test.packet))
+			if !slices.Equal(properties.keywords, test.keywords) {
+				t.Errorf("keywords = %q, want %q", properties.keywords, test.keywords)
+			}
+			if properties.description != test.caption || properties.rating != test.rating || properties.label != test.label {
+				t.Errorf("got %q %d %q, want %q %d %q", properties.description, properties.rating, properties.label,
+					test.caption, test.rating, test.label)
+			}
+		})
+	}
+}
+
+func TestFindXMP(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"found", "..<x:xmpmeta a>b</x:xmpmeta>..", "<x:xmpmeta a>b</x:xmpmeta>"},
+		{"unterminated", "..<x:xmpmeta a>b", ""},
+		{"none", "<xmpmeta/>", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(findXMP([]byte(test.data))); got != test.want {
+				t.Errorf("packet = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFormatExposure(t *testing.T) {
+
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{1.0 / 250, "1/250"},
+		{0.3, "1/3"},
+		{1, "1"},
+		{2.5, "2.5"},
+		{30, "30"},
+	}
+
+	for _, test := range tests {
+		if got := formatExposure(test.seconds); got != test.want {
+			t.Errorf("formatExposure(%v) = %q, want %q", test.seconds, got, test.want)
+		}
+	}
+}
+
+func near(a float64, b float64, tolerance float64) bool {
+	return a-b <= tolerance && b-a <= tolerance
+}
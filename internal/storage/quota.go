@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+)
+
+// SetQuota sets the quota of a user, it applies from the next upload
+func (us *UserStorageManager) SetQuota(request model.QuotaRequest) (*model.Quota, error) {
+
+	if _, exists := us.users[request.UserID]; !exists {
+		return nil, ErrUserNotFound
+	}
+	if (request.MaxBytes != nil && *request.MaxBytes < 0) || (request.MaxAssets != nil && *request.MaxAssets < 0) {
+		return nil, ErrInvalidQuota
+	}
+
+	us.quotaMu.Lock()
+	defer us.quotaMu.Unlock()
+
+	quota := us.getQuota(request.UserID)
+	if request.MaxBytes != nil {
+		quota.MaxBytes = *request.MaxBytes
+	}
+	if request.MaxAssets != nil {
+		quota.MaxAssets = *request.MaxAssets
+	}
+	if request.Override != nil {
+		quota.Override = *request.Override
+	}
+
+	var err error
+	if quota.ID == 0 {
+		quota, err = us.quotaManager.Create(quota)
+	} else {
+		quota, err = us.quotaManager.Update(quota)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	userStorage, err := us.GetUserStorage(nil, request.UserID)
+	if err != nil {
+		return nil, err
+	}
+	userStorage.statsMu.Lock()
+	userStorage.quota = *quota
+	userStorage.statsMu.Unlock()
+
+	return quota, nil
+}
+
+// VerifyAdminKey reports whether the key is the admin key stored next to the data
+func (us *UserStorageManager) VerifyAdminKey(key string) bool {
+	return key != "" && hmac.Equal([]byte(key), []byte(hex.EncodeToString(us.adminKey)))
+}
+
+// getQuota returns the quota of a user, an unlimited one when none is set
+func (us *UserStorageManager) getQuota(userID int) *model.Quota {
+	items, err := us.quotaManager.GetList(func(a *model.Quota) bool {
+		return a.UserID == userID
+	})
+	if err != nil || len(items) == 0 {
+		return &model.Quota{UserID: userID}
+	}
+	return items[0]
+}
+
+// checkQuota returns ErrQuotaExceeded when adding a file of the given size would go
+// over the quota. A file that joins an existing asset, the other half of a Live Photo
+// or RAW+JPEG pair, does not count as a new asset. Callers hold mu.
+func (userStorage *UserStorage) checkQuota(size int64, newAsset bool) error {
+
+	usage := userStorage.quotaUsage(len(userStorage.assets))
+	if usage.Override {
+		return nil
+	}
+
+	if newAsset && usage.MaxAssets > 0 && usage.UsedAssets+1 > usage.MaxAssets {
+		return model.ErrQuotaExceeded.Wrap(ErrQuotaAssets).WithDetails(usage)
+	}
+	if usage.MaxBytes > 0 && usage.UsedBytes+size > usage.MaxBytes {
+		return model.ErrQuotaExceeded.Wrap(ErrQuotaBytes).WithDetails(usage)
+	}
+
+	return nil
+}
+
+// quotaUsage counts the originals and their resources. Thumbnails, posters and the
+// derivative cache are made by the server and left out.
+func (userStorage *UserStorage) quotaUsage(assetCount int) model.QuotaUsage {
+
+	userStorage.statsMu.Lock()
+	defer userStorage.statsMu.Unlock()
+
+	return model.QuotaUsage{
+		MaxBytes:   userStorage.quota.MaxBytes,
+		MaxAssets:  userStorage.quota.MaxAssets,
+		Override:   userStorage.quota.Override,
+		UsedBytes:  userStorage.usage.originalBytes,
+		UsedAssets: assetCount,
+	}
+}
@@ -0,0 +1,400 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const sharePasswordIterations = 100000
+
+// Public share links give people without an account read access to an album, a trip
+// or a set of assets. Tokens carry an HMAC signature so forged tokens are rejected
+// before any lookup.
+
+func (us *UserStorageManager) CreateShareLink(userID int, request model.ShareLinkRequest) (*model.ShareLink, error) {
+
+	userStorage, err := us.GetUserStorage(nil, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &model.ShareLink{
+		UserID:        userID,
+		Title:         request.Title,
+		Type:          request.Type,
+		AllowDownload: request.AllowDownload,
+	}
+
+	switch request.Type {
+	case model.ShareLinkAlbum:
+		album, err := userStorage.AlbumManager.Get(request.CollectionID)
+		if err != nil {
+			return nil, fmt.Errorf("album %d: %w", request.CollectionID, ErrInvalidShareLink)
+		}
+		link.CollectionID = album.ID
+		if link.Title == "" {
+			link.Title = album.Title
+		}
+	case model.ShareLinkTrip:
+		trip, err := userStorage.TripManager.Get(request.CollectionID)
+		if err != nil {
+			return nil, fmt.Errorf("trip %d: %w", request.CollectionID, ErrInvalidShareLink)
+		}
+		link.CollectionID = trip.ID
+		if link.Title == "" {
+			link.Title = trip.Title
+		}
+	case model.ShareLinkAssets:
+		if len(request.AssetIds) == 0 {
+			return nil, ErrInvalidShareLink
+		}
+		for _, assetID := range request.AssetIds {
+			if _, exists := userStorage.GetAssetCopy(assetID); !exists {
+				return nil, fmt.Errorf("asset %d: %w", assetID, ErrAssetNotFound)
+			}
+		}
+		link.AssetIds = request.AssetIds
+	default:
+		return nil, ErrInvalidShareLink
+	}
+
+	if request.ExpiresIn > 0 {
+		expiration := time.Now().Add(time.Duration(request.ExpiresIn) * time.Hour)
+		link.ExpirationDate = &expiration
+	}
+
+	if request.Password != "" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("failed to generate password salt: %w", err)
+		}
+		hash, err := hashSharePassword(request.Password, salt)
+		if err != nil {
+			return nil, err
+		}
+		link.PasswordSalt = hex.EncodeToString(salt)
+		link.PasswordHash = hash
+	}
+
+	link.Token, err = us.newShareToken()
+	if err != nil {
+		return nil, err
+	}
+
+	us.shareMu.Lock()
+	defer us.shareMu.Unlock()
+
+	link, err = us.shareLinkManager.Create(link)
+	if err != nil {
+		return nil, err
+	}
+
+	us.recordActivity(userID, &model.Activity{
+		UserID:         userID,
+		Type:           model.ActivityShare,
+		CollectionType: link.Type,
+		CollectionID:   link.CollectionID,
+		AssetIds:       link.AssetIds,
+		Text:           link.Title,
+	})
+
+	return link, nil
+}
+
+func (us *UserStorageManager) GetShareLinks(userID int) ([]*model.ShareLink, error) {
+	return us.shareLinkManager.GetList(func(a *model.ShareLink) bool {
+		return a.UserID == userID
+	})
+}
+
+func (us *UserStorageManager) DeleteShareLink(userID int, id int) error {
+
+	us.shareMu.Lock()
+	defer us.shareMu.Unlock()
+
+	link, err := us.shareLinkManager.Get(id)
+	if err != nil || link.UserID != userID {
+		return ErrShareLinkNotFound
+	}
+
+	return us.shareLinkManager.Delete(id)
+}
+
+// UnlockShareLink checks the password of a link and returns the access key that
+// authorizes further requests without sending the password again.
+func (us *UserStorageManager) UnlockShareLink(token string, password string) (string, error) {
+
+	link, err := us.findShareLink(token)
+	if err != nil {
+		return "", err
+	}
+
+	if link.PasswordHash == "" {
+		return "", nil
+	}
+
+	salt, err := hex.DecodeString(link.PasswordSalt)
+	if err != nil {
+		return "", ErrShareLinkPassword
+	}
+	hash, err := hashSharePassword(password, salt)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(hash), []byte(link.PasswordHash)) {
+		return "", ErrShareLinkPassword
+	}
+
+	return us.shareAccessKey(link), nil
+}
+
+// ViewShareLink returns the link and its assets, counting the view
+func (us *UserStorageManager) ViewShareLink(token string, access string) (*model.ShareLink, []model.ShareLinkAsset, error) {
+
+	link, err := us.openShareLink(token, access)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	linked, err := us.shareLinkAssets(link)
+	if err != nil {
+		return nil, nil, err
+	}
+	assets := make([]model.ShareLinkAsset, len(linked))
+	for i, asset := range linked {
+		assets[i] = newShareLinkAsset(asset, link.AllowDownload)
+	}
+
+	us.shareMu.Lock()
+	now := time.Now()
+	link.ViewCount++
+	link.LastViewDate = &now
+	link, err = us.shareLinkManager.Update(link)
+	us.shareMu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return link, assets, nil
+}
+
+// newShareLinkAsset keeps what a link shows of an asset, the original only when the
+// link allows downloads
+func newShareLinkAsset(asset *common_models.PHAsset, allowDownload bool) model.ShareLinkAsset {
+
+	view := model.ShareLinkAsset{
+		ID:         asset.ID,
+		Thumbnails: thumbnailFilenames(asset),
+		Width:      asset.PixelWidth,
+		Height:     asset.PixelHeight,
+		MediaType:  asset.MediaType,
+	}
+	if allowDownload {
+		view.Original = asset.Filename
+	}
+	if !asset.CapturedDate.IsZero() {
+		captured := asset.CapturedDate
+		view.CapturedDate = &captured
+	}
+	return view
+}
+
+// ShareLinkThumbnail checks that a thumbnail belongs to one of the linked assets
+// and returns the user whose storage serves it
+func (us *UserStorageManager) ShareLinkThumbnail(token string, access string, filename string) (int, error) {
+
+	link, err := us.openShareLinkFile(token, access, filename)
+	if err != nil {
+		return 0, err
+	}
+
+	return link.UserID, nil
+}
+
+// ShareLinkOriginal checks that an original belongs to one of the linked assets and that
+// the link allows downloads, and returns the user whose storage serves it. Range requests
+// continuing a download pass count as false so they are not counted again.
+func (us *UserStorageManager) ShareLinkOriginal(token string, access string, filename string, count bool) (int, error) {
+
+	link, err := us.openShareLinkFile(token, access, filename)
+	if err != nil {
+		return 0, err
+	}
+
+	if !link.AllowDownload {
+		return 0, ErrShareLinkDownload
+	}
+
+	if count {
+		us.shareMu.Lock()
+		link.DownloadCount++
+		_, err = us.shareLinkManager.Update(link)
+		us.shareMu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return link.UserID, nil
+}
+
+func (us *UserStorageManager) openShareLinkFile(token string, access string, filename string) (*model.ShareLink, error) {
+
+	link, err := us.openShareLink(token, access)
+	if err != nil {
+		return nil, err
+	}
+
+	assets, err := us.shareLinkAssets(link)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the originals and thumbnails of the linked assets, never other files of the owner
+	allowed := make(map[string]bool)
+	for _, asset := range assets {
+		for name := range assetFilenames(asset) {
+			allowed[name] = true
+		}
+	}
+	if !allowed[filename] {
+		return nil, ErrShareLinkNotFound
+	}
+
+	return link, nil
+}
+
+// openShareLink finds a link that is not expired and checks the access key of password protected links
+func (us *UserStorageManager) openShareLink(token string, access string) (*model.ShareLink, error) {
+
+	link, err := us.findShareLink(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if link.PasswordHash != "" && !hmac.Equal([]byte(access), []byte(us.shareAccessKey(link))) {
+		return nil, ErrShareLinkPassword
+	}
+
+	return link, nil
+}
+
+func (us *UserStorageManager) findShareLink(token string) (*model.ShareLink, error) {
+
+	if !us.verifyShareToken(token) {
+		return nil, ErrShareLinkNotFound
+	}
+
+	items, err := us.shareLinkManager.GetList(func(a *model.ShareLink) bool {
+		return a.Token == token
+	})
+	if err != nil || len(items) == 0 {
+		return nil, ErrShareLinkNotFound
+	}
+
+	link := items[0]
+	if link.IsExpired() {
+		return nil, ErrShareLinkExpired
+	}
+
+	return link, nil
+}
+
+// shareLinkAssets resolves the linked assets from the storage of the link owner
+func (us *UserStorageManager) shareLinkAssets(link *model.ShareLink) ([]*common_models.PHAsset, error) {
+
+	userStorage, err := us.GetUserStorage(nil, link.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var with model.AssetFetchOptions
+
+	switch link.Type {
+	case model.ShareLinkAlbum:
+		with.PHFetchOptions = common_models.PHFetchOptions{Albums: []int{link.CollectionID}, SortBy: "capturedDate", SortOrder: "asc"}
+	case model.ShareLinkTrip:
+		with.PHFetchOptions = common_models.PHFetchOptions{Trips: []int{link.CollectionID}, SortBy: "capturedDate", SortOrder: "asc"}
+	default:
+		assets := make([]*common_models.PHAsset, 0, len(link.AssetIds))
+		for _, assetID := range link.AssetIds {
+			if asset, exists := userStorage.GetAssetCopy(assetID); exists && !asset.IsHidden {
+				assets = append(assets, asset)
+			}
+		}
+		return assets, nil
+	}
+
+	assets, _, err := userStorage.FilterAssets(with)
+	return assets, err
+}
+
+func (us *UserStorageManager) newShareToken() (string, error) {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate share token: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(nonce)
+	return encoded + "." + us.shareSignature(encoded), nil
+}
+
+func (us *UserStorageManager) verifyShareToken(token string) bool {
+	nonce, signature, found := strings.Cut(token, ".")
+	if !found || nonce == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(us.shareSignature(nonce)))
+}
+
+func (us *UserStorageManager) shareSignature(value string) string {
+	mac := hmac.New(sha256.New, us.shareSecret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (us *UserStorageManager) shareAccessKey(link *model.ShareLink) string {
+	return us.shareSignature("access:" + link.Token + ":" + link.PasswordHash)
+}
+
+func hashSharePassword(password string, salt []byte) (string, error) {
+	key, err := pbkdf2.Key(sha256.New, password, salt, sharePasswordIterations, 32)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash share password: %w", err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// loadShareSecret reads the signing key for share links, creating it on first start
+func loadShareSecret(path string) ([]byte, error) {
+
+	if data, err := os.ReadFile(path); err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(data)))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(secret)), 0600); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
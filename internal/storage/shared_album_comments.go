@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"strings"
+)
+
+// Comments and reactions are kept in their own collections next to the shared
+// albums and are addressed by album, asset owner and asset id.
+
+func (us *UserStorageManager) GetSharedAlbumFeedback(userID int, request model.SharedAlbumFeedbackRequest) (*model.SharedAlbumFeedback, error) {
+
+	if _, err := us.getSharedAlbumAsset(userID, request); err != nil {
+		return nil, err
+	}
+
+	comments, err := us.sharedCommentManager.GetList(func(a *model.SharedAlbumComment) bool {
+		return a.SharedAlbumID == request.ID && a.AssetUserID == request.AssetUserID && a.AssetID == request.AssetID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reactions, err := us.sharedReactionManager.GetList(func(a *model.SharedAlbumReaction) bool {
+		return a.SharedAlbumID == request.ID && a.AssetUserID == request.AssetUserID && a.AssetID == request.AssetID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.SharedAlbumFeedback{Comments: comments, Reactions: reactions}, nil
+}
+
+func (us *UserStorageManager) AddSharedAlbumComment(userID int, request model.SharedAlbumFeedbackRequest) (*model.SharedAlbumComment, error) {
+
+	text := strings.TrimSpace(request.Text)
+	if text == "" {
+		return nil, ErrInvalidFeedback
+	}
+
+	album, err := us.getSharedAlbumAsset(userID, request)
+	if err != nil {
+		return nil, err
+	}
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	comment, err := us.sharedCommentManager.Create(&model.SharedAlbumComment{
+		SharedAlbumID: request.ID,
+		AssetUserID:   request.AssetUserID,
+		AssetID:       request.AssetID,
+		UserID:        userID,
+		Text:          text,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	us.recordSharedAlbumActivity(album, userID, model.ActivityComment, []int{request.AssetID}, text)
+
+	return comment, nil
+}
+
+// DeleteSharedAlbumComment deletes a comment of the user, the album owner may delete any comment
+func (us *UserStorageManager) DeleteSharedAlbumComment(userID int, id int) error {
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	comment, err := us.sharedCommentManager.Get(id)
+	if err != nil {
+		return ErrFeedbackNotFound
+	}
+
+	if comment.UserID != userID {
+		album, err := us.sharedAlbumManager.Get(comment.SharedAlbumID)
+		if err != nil || album.OwnerID != userID {
+			return ErrSharedAlbumForbidden
+		}
+	}
+
+	return us.sharedCommentManager.Delete(id)
+}
+
+// SetSharedAlbumReaction adds the reaction of the user or replaces their previous one
+func (us *UserStorageManager) SetSharedAlbumReaction(userID int, request model.SharedAlbumFeedbackRequest) (*model.SharedAlbumReaction, error) {
+
+	emoji := strings.TrimSpace(request.Emoji)
+	if emoji == "" || len(emoji) > 32 {
+		return nil, ErrInvalidFeedback
+	}
+
+	if _, err := us.getSharedAlbumAsset(userID, request); err != nil {
+		return nil, err
+	}
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	items, err := us.sharedReactionManager.GetList(func(a *model.SharedAlbumReaction) bool {
+		return a.SharedAlbumID == request.ID && a.AssetUserID == request.AssetUserID && a.AssetID == request.AssetID && a.UserID == userID
+	})
+	if err == nil && len(items) > 0 {
+		items[0].Emoji = emoji
+		return us.sharedReactionManager.Update(items[0])
+	}
+
+	return us.sharedReactionManager.Create(&model.SharedAlbumReaction{
+		SharedAlbumID: request.ID,
+		AssetUserID:   request.AssetUserID,
+		AssetID:       request.AssetID,
+		UserID:        userID,
+		Emoji:         emoji,
+	})
+}
+
+// DeleteSharedAlbumReaction removes the reaction of the user on an asset
+func (us *UserStorageManager) DeleteSharedAlbumReaction(userID int, request model.SharedAlbumFeedbackRequest) error {
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	items, err := us.sharedReactionManager.GetList(func(a *model.SharedAlbumReaction) bool {
+		return a.SharedAlbumID == request.ID && a.AssetUserID == request.AssetUserID && a.AssetID == request.AssetID && a.UserID == userID
+	})
+	if err != nil || len(items) == 0 {
+		return ErrFeedbackNotFound
+	}
+
+	return us.sharedReactionManager.Delete(items[0].ID)
+}
+
+// countSharedAlbumFeedback fills the comment and reaction counts of the albums
+func (us *UserStorageManager) countSharedAlbumFeedback(albums []*model.SharedAlbum) {
+
+	comments := make(map[int]int)
+	if items, err := us.sharedCommentManager.GetAll(); err == nil {
+		for _, item := range items {
+			comments[item.SharedAlbumID]++
+		}
+	}
+
+	reactions := make(map[int]int)
+	if items, err := us.sharedReactionManager.GetAll(); err == nil {
+		for _, item := range items {
+			reactions[item.SharedAlbumID]++
+		}
+	}
+
+	for _, album := range albums {
+		album.CommentCount = comments[album.ID]
+		album.ReactionCount = reactions[album.ID]
+	}
+}
+
+// deleteSharedAlbumFeedback removes comments and reactions matched by remove,
+// callers hold sharedMu
+func (us *UserStorageManager) deleteSharedAlbumFeedback(albumID int, remove func(assetUserID int, assetID int) bool) {
+
+	comments, err := us.sharedCommentManager.GetList(func(a *model.SharedAlbumComment) bool {
+		return a.SharedAlbumID == albumID && remove(a.AssetUserID, a.AssetID)
+	})
+	if err == nil {
+		for _, item := range comments {
+			_ = us.sharedCommentManager.Delete(item.ID)
+		}
+	}
+
+	reactions, err := us.sharedReactionManager.GetList(func(a *model.SharedAlbumReaction) bool {
+		return a.SharedAlbumID == albumID && remove(a.AssetUserID, a.AssetID)
+	})
+	if err == nil {
+		for _, item := range reactions {
+			_ = us.sharedReactionManager.Delete(item.ID)
+		}
+	}
+}
+
+func (us *UserStorageManager) getSharedAlbumAsset(userID int, request model.SharedAlbumFeedbackRequest) (*model.SharedAlbum, error) {
+
+	album, err := us.GetSharedAlbum(userID, request.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !album.HasAsset(request.AssetUserID, request.AssetID) {
+		return nil, ErrAssetNotFound
+	}
+
+	return album, nil
+}
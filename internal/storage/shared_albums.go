@@ -0,0 +1,591 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/mahdi-cpp/api-go-pkg/collection"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/config"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Shared albums live in a single collection owned by the manager, because
+// their members and assets span the storages of several users. The albums of the
+// collection are only read and changed under sharedMu, callers get copies.
+
+func (us *UserStorageManager) CreateSharedAlbum(userID int, album *model.SharedAlbum) (*model.SharedAlbum, error) {
+
+	if _, err := us.GetUserStorage(nil, userID); err != nil {
+		return nil, err
+	}
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	album.ID = 0
+	album.OwnerID = userID
+	album.Count = 0
+	album.InviteToken = ""
+	album.Members = []model.SharedAlbumMember{}
+	album.Assets = []model.SharedAlbumAsset{}
+
+	album, err := us.sharedAlbumManager.Create(album)
+	if err != nil {
+		return nil, err
+	}
+
+	return sharedAlbumView(album, userID), nil
+}
+
+func (us *UserStorageManager) UpdateSharedAlbum(userID int, handler model.SharedAlbumHandler) (*model.SharedAlbum, error) {
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	album, err := us.getOwnedSharedAlbum(userID, handler.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	model.UpdateSharedAlbum(album, handler)
+
+	return us.saveSharedAlbum(album, userID)
+}
+
+func (us *UserStorageManager) DeleteSharedAlbum(userID int, id int) error {
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	if _, err := us.getOwnedSharedAlbum(userID, id); err != nil {
+		return err
+	}
+
+	if err := us.sharedAlbumManager.Delete(id); err != nil {
+		return err
+	}
+
+	us.deleteSharedAlbumFeedback(id, func(int, int) bool { return true })
+	return nil
+}
+
+// GetSharedAlbum returns an album the user has joined
+func (us *UserStorageManager) GetSharedAlbum(userID int, id int) (*model.SharedAlbum, error) {
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	album, err := us.sharedAlbumManager.Get(id)
+	if err != nil {
+		return nil, ErrSharedAlbumNotFound
+	}
+
+	if !album.CanView(userID) {
+		return nil, ErrSharedAlbumForbidden
+	}
+
+	return sharedAlbumView(album, userID), nil
+}
+
+// GetSharedAlbums returns the albums a user owns, joined or is invited to
+func (us *UserStorageManager) GetSharedAlbums(userID int) ([]*model.SharedAlbum, error) {
+
+	us.sharedMu.Lock()
+	items, err := us.sharedAlbumManager.GetList(func(a *model.SharedAlbum) bool {
+		_, ok := a.GetMember(userID)
+		return ok
+	})
+	for i := range items {
+		items[i] = sharedAlbumView(items[i], userID)
+	}
+	us.sharedMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	us.countSharedAlbumFeedback(items)
+
+	return items, nil
+}
+
+func (us *UserStorageManager) InviteSharedAlbumMembers(userID int, invite model.SharedAlbumInvite) (*model.SharedAlbum, error) {
+
+	role := invite.Role
+	if role == "" {
+		role = model.SharedRoleViewer
+	}
+	if role != model.SharedRoleViewer && role != model.SharedRoleContributor {
+		return nil, ErrInvalidRole
+	}
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	album, err := us.getOwnedSharedAlbum(userID, invite.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, memberID := range invite.UserIDs {
+
+		if memberID == album.OwnerID {
+			continue
+		}
+		if _, exists := us.users[memberID]; !exists {
+			return nil, fmt.Errorf("invite user %d: %w", memberID, ErrUserNotFound)
+		}
+
+		// Existing members only get their role changed
+		if member, ok := album.GetMember(memberID); ok {
+			member.Role = role
+			continue
+		}
+
+		album.Members = append(album.Members, model.SharedAlbumMember{
+			UserID:      memberID,
+			Role:        role,
+			Status:      model.SharedMemberInvited,
+			InvitedDate: time.Now(),
+		})
+	}
+
+	album, err = us.saveSharedAlbum(album, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	us.recordSharedAlbumActivity(album, userID, model.ActivityShare, nil, "")
+
+	return album, nil
+}
+
+// CreateSharedAlbumLink generates a new invite token, replacing the previous one
+func (us *UserStorageManager) CreateSharedAlbumLink(userID int, id int) (*model.SharedAlbum, error) {
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	album, err := us.getOwnedSharedAlbum(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		return nil, err
+	}
+	album.InviteToken = token
+
+	return us.saveSharedAlbum(album, userID)
+}
+
+// JoinSharedAlbum accepts a pending invite or joins through an invite link as viewer
+func (us *UserStorageManager) JoinSharedAlbum(userID int, join model.SharedAlbumJoin) (*model.SharedAlbum, error) {
+
+	if _, exists := us.users[userID]; !exists {
+		return nil, ErrUserNotFound
+	}
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	var album *model.SharedAlbum
+
+	if join.InviteToken != "" {
+		items, err := us.sharedAlbumManager.GetList(func(a *model.SharedAlbum) bool {
+			return a.InviteToken != "" && a.InviteToken == join.InviteToken
+		})
+		if err != nil || len(items) == 0 {
+			return nil, ErrInvalidInviteToken
+		}
+		album = items[0]
+	} else {
+		item, err := us.sharedAlbumManager.Get(join.ID)
+		if err != nil {
+			return nil, ErrSharedAlbumNotFound
+		}
+		album = item
+	}
+
+	member, ok := album.GetMember(userID)
+	switch {
+	case !ok && join.InviteToken == "":
+		return nil, ErrSharedAlbumForbidden
+	case !ok:
+		album.Members = append(album.Members, model.SharedAlbumMember{
+			UserID:      userID,
+			Role:        model.SharedRoleViewer,
+			Status:      model.SharedMemberJoined,
+			InvitedDate: time.Now(),
+			JoinedDate:  time.Now(),
+		})
+	case member.Status == model.SharedMemberInvited:
+		member.Status = model.SharedMemberJoined
+		member.JoinedDate = time.Now()
+	default:
+		return sharedAlbumView(album, userID), nil
+	}
+
+	album, err := us.saveSharedAlbum(album, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	us.recordSharedAlbumActivity(album, userID, model.ActivityJoin, nil, "")
+
+	return album, nil
+}
+
+// RemoveSharedAlbumMember lets the owner remove a member or a member leave the album
+func (us *UserStorageManager) RemoveSharedAlbumMember(userID int, id int, memberID int) (*model.SharedAlbum, error) {
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	album, err := us.sharedAlbumManager.Get(id)
+	if err != nil {
+		return nil, ErrSharedAlbumNotFound
+	}
+
+	if memberID == album.OwnerID || (userID != album.OwnerID && userID != memberID) {
+		return nil, ErrSharedAlbumForbidden
+	}
+
+	members := make([]model.SharedAlbumMember, 0, len(album.Members))
+	for _, member := range album.Members {
+		if member.UserID != memberID {
+			members = append(members, member)
+		}
+	}
+	album.Members = members
+
+	// Assets added by the removed member leave with them
+	assets := make([]model.SharedAlbumAsset, 0, len(album.Assets))
+	for _, item := range album.Assets {
+		if item.UserID != memberID {
+			assets = append(assets, item)
+		}
+	}
+	album.Assets = assets
+	album.Count = len(album.Assets)
+
+	us.deleteSharedAlbumFeedback(id, func(assetUserID int, _ int) bool { return assetUserID == memberID })
+
+	return us.saveSharedAlbum(album, userID)
+}
+
+// AddSharedAlbumAssets adds assets from the library of a contributor
+func (us *UserStorageManager) AddSharedAlbumAssets(userID int, request model.SharedAlbumAssets) (*model.SharedAlbum, error) {
+
+	userStorage, err := us.GetUserStorage(nil, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	album, err := us.sharedAlbumManager.Get(request.ID)
+	if err != nil {
+		return nil, ErrSharedAlbumNotFound
+	}
+
+	if !album.CanContribute(userID) {
+		return nil, ErrSharedAlbumForbidden
+	}
+
+	for _, assetID := range request.AssetIds {
+		if _, exists := userStorage.GetAssetCopy(assetID); !exists {
+			return nil, fmt.Errorf("asset %d: %w", assetID, ErrAssetNotFound)
+		}
+	}
+
+	var added []int
+	for _, assetID := range request.AssetIds {
+		if album.HasAsset(userID, assetID) {
+			continue
+		}
+		album.Assets = append(album.Assets, model.SharedAlbumAsset{
+			UserID:    userID,
+			AssetID:   assetID,
+			AddedDate: time.Now(),
+		})
+		added = append(added, assetID)
+	}
+	album.Count = len(album.Assets)
+
+	album, err = us.saveSharedAlbum(album, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(added) > 0 {
+		us.recordSharedAlbumActivity(album, userID, model.ActivityAlbumAdd, added, "")
+	}
+
+	return album, nil
+}
+
+// RemoveSharedAlbumAssets removes assets of the requesting user, the owner may remove
+// the assets of any member
+func (us *UserStorageManager) RemoveSharedAlbumAssets(userID int, request model.SharedAlbumAssets) (*model.SharedAlbum, error) {
+
+	us.sharedMu.Lock()
+	defer us.sharedMu.Unlock()
+
+	album, err := us.sharedAlbumManager.Get(request.ID)
+	if err != nil {
+		return nil, ErrSharedAlbumNotFound
+	}
+
+	if !album.CanContribute(userID) {
+		return nil, ErrSharedAlbumForbidden
+	}
+
+	// Only the owner removes the assets of other members
+	assetUserID := request.UserID
+	if assetUserID == 0 {
+		assetUserID = userID
+	}
+	if assetUserID != userID && userID != album.OwnerID {
+		return nil, ErrSharedAlbumForbidden
+	}
+
+	removeSet := make(map[int]bool)
+	for _, id := range request.AssetIds {
+		removeSet[id] = true
+	}
+
+	var removed []int
+	assets := make([]model.SharedAlbumAsset, 0, len(album.Assets))
+	for _, item := range album.Assets {
+		if item.UserID == assetUserID && removeSet[item.AssetID] {
+			removed = append(removed, item.AssetID)
+			continue
+		}
+		assets = append(assets, item)
+	}
+	album.Assets = assets
+	album.Count = len(album.Assets)
+
+	us.deleteSharedAlbumFeedback(album.ID, func(assetUserID int, assetID int) bool {
+		return !album.HasAsset(assetUserID, assetID)
+	})
+
+	album, err = us.saveSharedAlbum(album, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(removed) > 0 {
+		us.recordSharedAlbumActivity(album, userID, model.ActivityAlbumRemove, removed, "")
+	}
+
+	return album, nil
+}
+
+// FetchSharedAlbumAssets resolves the album assets from the storages of the users who added them,
+// newest additions first.
+func (us *UserStorageManager) FetchSharedAlbumAssets(userID int, id int, offset int, limit int) ([]*common_models.PHAsset, int, error) {
+
+	album, err := us.GetSharedAlbum(userID, id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	items := make([]model.SharedAlbumAsset, len(album.Assets))
+	copy(items, album.Assets)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].AddedDate.After(items[j].AddedDate)
+	})
+
+	visible := us.sharedAlbumAssets(items)
+	assets := make([]*common_models.PHAsset, 0, len(items))
+	for _, item := range items {
+		if asset, exists := visible[item.UserID][item.AssetID]; exists {
+			assets = append(assets, asset)
+		}
+	}
+
+	total := len(assets)
+
+	start := offset
+	if start < 0 {
+		start = 0
+	}
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total || limit <= 0 {
+		end = total
+	}
+
+	return assets[start:end], total, nil
+}
+
+// ResolveSharedAlbumFile checks that a file belongs to an asset of a shared album the user has joined
+// and returns the id of the user whose storage holds it.
+func (us *UserStorageManager) ResolveSharedAlbumFile(userID int, id int, filename string) (int, error) {
+
+	album, err := us.GetSharedAlbum(userID, id)
+	if err != nil {
+		return 0, err
+	}
+
+	visible := us.sharedAlbumAssets(album.Assets)
+	for _, item := range album.Assets {
+		if asset, exists := visible[item.UserID][item.AssetID]; exists && assetFilenames(asset)[filename] {
+			return item.UserID, nil
+		}
+	}
+
+	return 0, ErrSharedAlbumForbidden
+}
+
+// sharedAlbumAssets copies the assets of a shared album that are not hidden, by
+// user and asset ID. Each member storage is read under its own read lock.
+func (us *UserStorageManager) sharedAlbumAssets(items []model.SharedAlbumAsset) map[int]map[int]*common_models.PHAsset {
+
+	idsByUser := make(map[int][]int)
+	for _, item := range items {
+		idsByUser[item.UserID] = append(idsByUser[item.UserID], item.AssetID)
+	}
+
+	visible := make(map[int]map[int]*common_models.PHAsset, len(idsByUser))
+	for userID, ids := range idsByUser {
+		userStorage, err := us.GetUserStorage(nil, userID)
+		if err != nil {
+			continue
+		}
+		visible[userID] = userStorage.visibleAssetCopies(ids)
+	}
+	return visible
+}
+
+func (us *UserStorageManager) getOwnedSharedAlbum(userID int, id int) (*model.SharedAlbum, error) {
+
+	album, err := us.sharedAlbumManager.Get(id)
+	if err != nil {
+		return nil, ErrSharedAlbumNotFound
+	}
+
+	if album.OwnerID != userID {
+		return nil, ErrSharedAlbumForbidden
+	}
+
+	return album, nil
+}
+
+// migrateUserSharedAlbums moves the albums of the per user shared album files into
+// the shared collection, owned by that user and without members yet. A migrated
+// file is renamed so it is read only once.
+func (us *UserStorageManager) migrateUserSharedAlbums() {
+
+	for _, user := range us.users {
+		path := config.GetUserPath(user.PhoneNumber, "data/shared_albums.json")
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		legacy, err := collection.NewCollectionManager[*model.SharedAlbum](path)
+		if err != nil {
+			log.Printf("failed to read shared albums of user %d: %v", user.ID, err)
+			continue
+		}
+		items, err := legacy.GetAll()
+		if err != nil {
+			log.Printf("failed to read shared albums of user %d: %v", user.ID, err)
+			continue
+		}
+
+		migrated := true
+		for _, item := range items {
+			album := &model.SharedAlbum{
+				OwnerID:      user.ID,
+				Name:         item.Name,
+				AlbumType:    item.AlbumType,
+				IsCollection: item.IsCollection,
+				IsHidden:     item.IsHidden,
+				Members:      []model.SharedAlbumMember{},
+				Assets:       []model.SharedAlbumAsset{},
+			}
+			if _, err := us.sharedAlbumManager.Create(album); err != nil {
+				log.Printf("failed to migrate shared album %q of user %d: %v", item.Name, user.ID, err)
+				migrated = false
+			}
+		}
+
+		if migrated {
+			if err := os.Rename(path, path+".migrated"); err != nil {
+				log.Printf("failed to rename migrated shared albums of user %d: %v", user.ID, err)
+			}
+		}
+	}
+}
+
+// saveSharedAlbum persists a changed album and returns the copy the user sees. Callers hold sharedMu.
+func (us *UserStorageManager) saveSharedAlbum(album *model.SharedAlbum, userID int) (*model.SharedAlbum, error) {
+
+	album, err := us.sharedAlbumManager.Update(album)
+	if err != nil {
+		return nil, err
+	}
+
+	return sharedAlbumView(album, userID), nil
+}
+
+// sharedAlbumView is the copy of an album a user gets, only the owner sees the
+// invite token so members cannot pass the link on
+func sharedAlbumView(album *model.SharedAlbum, userID int) *model.SharedAlbum {
+	view := cloneSharedAlbum(album)
+	if userID != album.OwnerID {
+		view.InviteToken = ""
+	}
+	return view
+}
+
+// cloneSharedAlbum copies an album with its members and assets, so it can be read
+// after sharedMu is released
+func cloneSharedAlbum(album *model.SharedAlbum) *model.SharedAlbum {
+	clone := *album
+	clone.Members = slices.Clone(album.Members)
+	clone.Assets = slices.Clone(album.Assets)
+	return &clone
+}
+
+// assetFilenames returns the files an asset may be downloaded as: its original and
+// the thumbnails and poster named after its ID, "<id>_<size>.jpg"
+func assetFilenames(asset *common_models.PHAsset) map[string]bool {
+	names := map[string]bool{asset.Filename: true}
+	for _, name := range thumbnailFilenames(asset) {
+		names[name] = true
+	}
+	return names
+}
+
+// thumbnailFilenames lists the pre-generated thumbnails of an asset, "<id>_<size>.jpg"
+func thumbnailFilenames(asset *common_models.PHAsset) []string {
+	sizes := append([]int{videoPosterSize}, config.ThumbnailSizes...)
+	slices.Sort(sizes)
+	sizes = slices.Compact(sizes)
+	names := make([]string, len(sizes))
+	for i, size := range sizes {
+		names[i] = fmt.Sprintf("%d_%d.jpg", asset.ID, size)
+	}
+	return names
+}
+
+func newInviteToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate invite token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,579 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/config"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	xmpSyncInterval = 10 * time.Minute
+	jpegMaxSegment  = 0xFFFF - 2 // payload of a JPEG segment, after its length
+)
+
+// APP1 headers of the XMP packet of a JPEG and of its extension segments
+var (
+	jpegXMPHeader      = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegExtendedHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+)
+
+const xmpNamespaceRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// Namespaces of the properties written by the server, by the prefix they are
+// written with
+var (
+	xmpPrefixes   = []string{"xmp", "tiff", "exif", "aux", "photoshop", "dc", "lr"}
+	xmpNamespaces = map[string]string{
+		"xmp":       xmpNamespaceXMP,
+		"tiff":      "http://ns.adobe.com/tiff/1.0/",
+		"exif":      "http://ns.adobe.com/exif/1.0/",
+		"aux":       "http://ns.adobe.com/exif/1.0/aux/",
+		"photoshop": "http://ns.adobe.com/photoshop/1.0/",
+		"dc":        xmpNamespaceDC,
+		"lr":        xmpNamespaceLightroom,
+	}
+)
+
+// xmpManaged lists the properties written by the server by namespace. Merging
+// replaces them in an existing packet and keeps every other property.
+var xmpManaged = map[string][]string{
+	xmpNamespaceXMP:                      {"MetadataDate", "CreateDate", "Rating", "Label"},
+	"http://ns.adobe.com/tiff/1.0/":      {"Make", "Model"},
+	"http://ns.adobe.com/exif/1.0/":      {"DateTimeOriginal", "GPSLatitude", "GPSLongitude", "GPSAltitude", "GPSAltitudeRef"},
+	"http://ns.adobe.com/exif/1.0/aux/":  {"Lens"},
+	"http://ns.adobe.com/photoshop/1.0/": {"DateCreated"},
+	xmpNamespaceDC:                       {"description", "subject"},
+	xmpNamespaceLightroom:                {"hierarchicalSubject"},
+}
+
+// xmpSyncWorker writes metadata edits back to the original files, right after an
+// edit and periodically for the edits made before write back was enabled
+func (userStorage *UserStorage) xmpSyncWorker() {
+
+	userStorage.syncXMP()
+
+	ticker := time.NewTicker(xmpSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-userStorage.maintenanceCtx.Done():
+			return
+		case <-userStorage.xmpSyncRequests:
+			userStorage.syncXMP()
+		case <-ticker.C:
+			userStorage.syncXMP()
+		}
+	}
+}
+
+// requestXMPSync wakes the sync worker up, edits made meanwhile are written together
+func (userStorage *UserStorage) requestXMPSync() {
+	select {
+	case userStorage.xmpSyncRequests <- struct{}{}:
+	default:
+	}
+}
+
+// syncXMP writes the assets edited since their last write back
+func (userStorage *UserStorage) syncXMP() {
+
+	if !config.XMPSidecars && !config.XMPEmbedJPEG {
+		return
+	}
+
+	userStorage.mu.RLock()
+	var pending []int
+	for id, asset := range userStorage.assets {
+		if xmpPending(asset, userStorage.details[id]) {
+			pending = append(pending, id)
+		}
+	}
+	userStorage.mu.RUnlock()
+
+	for _, id := range pending {
+		if userStorage.maintenanceCtx.Err() != nil {
+			return
+		}
+
+		if err := userStorage.writeXMP(id); err != nil {
+			log.Printf("failed to write metadata of asset %d: %v", id, err)
+		}
+	}
+}
+
+// xmpPending reports whether an asset was edited after upload and since it was last
+// written. Assets without details wait for probeDetails.
+func xmpPending(asset *common_models.PHAsset, details *model.AssetDetails) bool {
+	if details == nil {
+		return false
+	}
+	edited := asset.ModificationDate.Sub(asset.CreationDate) > time.Second
+	return edited && asset.ModificationDate.After(details.SyncDate)
+}
+
+// writeXMP writes the metadata of an asset to its sidecar, and into the original
+// when it is a JPEG and embedding is enabled, merged with the XMP already there.
+// The files are read and written aside without the lock, and only put in place
+// when the asset did not change meanwhile; otherwise the next sync writes them.
+func (userStorage *UserStorage) writeXMP(assetID int) error {
+
+	userStorage.mu.RLock()
+	asset, exists := userStorage.assets[assetID]
+	details := userStorage.details[assetID]
+	if !exists || details == nil {
+		userStorage.mu.RUnlock()
+		return ErrDetailsNotFound
+	}
+	fields := newXMPFields(asset, details)
+	filename, format, modified := asset.Filename, details.Format, asset.ModificationDate
+	userStorage.mu.RUnlock()
+
+	dir := config.GetUserPath(userStorage.user.PhoneNumber, "assets")
+
+	var staged []*stagedFile
+	defer func() {
+		for _, file := range staged {
+			file.discard()
+		}
+	}()
+
+	if config.XMPSidecars {
+		path := filepath.Join(dir, SidecarFilename(filename))
+		packet := fields.packet()
+		if existing, err := os.ReadFile(path); err == nil {
+			if packet, err = mergeXMP(existing, fields); err != nil {
+				return err
+			}
+		}
+		file, err := stageFile(path, packet)
+		if err != nil {
+			return err
+		}
+		staged = append(staged, file)
+	}
+
+	if config.XMPEmbedJPEG && format == "jpeg" {
+		path := filepath.Join(dir, filename)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		embedded, err := embedXMP(data, fields)
+		if err != nil {
+			return err
+		}
+		file, err := stageFile(path, embedded)
+		if err != nil {
+			return err
+		}
+		staged = append(staged, file)
+	}
+
+	userStorage.mu.Lock()
+	defer userStorage.mu.Unlock()
+
+	asset, exists = userStorage.assets[assetID]
+	details = userStorage.details[assetID]
+	if !exists || details == nil || asset.Filename != filename || !asset.ModificationDate.Equal(modified) {
+		return nil
+	}
+
+	for _, file := range staged {
+		size, err := file.commit()
+		if err != nil {
+			return err
+		}
+		userStorage.addOriginalBytes(size)
+	}
+	staged = nil
+
+	details.SyncDate = modified
+	_, err := userStorage.DetailsManager.Update(details)
+	return err
+}
+
+// SidecarFilename returns the name of the XMP sidecar of an original, the
+// extension replaced as Lightroom names them
+func SidecarFilename(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".xmp"
+}
+
+// stagedFile is the new content of a file of the assets folder, written next to
+// it and renamed over it, so a reader never sees it half written
+type stagedFile struct {
+	path string
+	temp string
+	size int64
+}
+
+func stageFile(path string, data []byte) (*stagedFile, error) {
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		os.Remove(temp)
+		return nil, err
+	}
+	return &stagedFile{path: path, temp: temp, size: int64(len(data))}, nil
+}
+
+// commit puts the file in place and returns how much the folder grew
+func (file *stagedFile) commit() (int64, error) {
+
+	var previous int64
+	if info, err := os.Stat(file.path); err == nil {
+		previous = info.Size()
+	}
+	if err := os.Rename(file.temp, file.path); err != nil {
+		file.discard()
+		return 0, err
+	}
+	return file.size - previous, nil
+}
+
+func (file *stagedFile) discard() {
+	os.Remove(file.temp)
+}
+
+// xmpFields are the properties of an asset written to XMP: capture date, camera,
+// location, rating, label, caption and keywords. Hierarchical keywords are written
+// for Lightroom with their levels joined with "|".
+type xmpFields struct {
+	attributes   [][2]string // simple properties, written as attributes of the description
+	caption      string
+	subjects     []string
+	hierarchical []string
+}
+
+func newXMPFields(asset *common_models.PHAsset, details *model.AssetDetails) *xmpFields {
+
+	fields := &xmpFields{caption: details.Caption}
+	add := func(name, value string) {
+		if value != "" {
+			fields.attributes = append(fields.attributes, [2]string{name, value})
+		}
+	}
+
+	add("xmp:MetadataDate", asset.ModificationDate.Format(time.RFC3339))
+	add("tiff:Make", asset.CameraMake)
+	add("tiff:Model", asset.CameraModel)
+	if !asset.CapturedDate.IsZero() {
+		captured := asset.CapturedDate.Format(time.RFC3339)
+		add("xmp:CreateDate", captured)
+		add("exif:DateTimeOriginal", captured)
+		add("photoshop:DateCreated", captured)
+	}
+	if location, located := assetCoordinate(asset); located {
+		add("exif:GPSLatitude", xmpCoordinate(location.Latitude, 'N', 'S'))
+		add("exif:GPSLongitude", xmpCoordinate(location.Longitude, 'E', 'W'))
+	}
+
+	add("aux:Lens", details.Lens)
+	if details.Rating > 0 {
+		add("xmp:Rating", strconv.Itoa(details.Rating))
+	}
+	if details.Label != "" {
+		add("xmp:Label", strings.ToUpper(details.Label[:1])+details.Label[1:])
+	}
+	if details.Altitude != nil {
+		ref := "0"
+		if *details.Altitude < 0 {
+			ref = "1"
+		}
+		add("exif:GPSAltitude", fmt.Sprintf("%d/100", int(math.Round(math.Abs(*details.Altitude)*100))))
+		add("exif:GPSAltitudeRef", ref)
+	}
+
+	hierarchical := false
+	for _, keyword := range details.Keywords {
+		levels := strings.Split(keyword, "/")
+		hierarchical = hierarchical || len(levels) > 1
+		fields.subjects = appendKeyword(fields.subjects, levels[len(levels)-1])
+	}
+	if hierarchical {
+		for _, keyword := range details.Keywords {
+			fields.hierarchical = append(fields.hierarchical, strings.ReplaceAll(keyword, "/", "|"))
+		}
+	}
+
+	return fields
+}
+
+// packet writes the fields as a new XMP packet
+func (fields *xmpFields) packet() []byte {
+
+	var b bytes.Buffer
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"" + xmpNamespaceRDF + "\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\"")
+	for _, prefix := range xmpPrefixes {
+		b.WriteString("\n    xmlns:" + prefix + "=\"" + xmpNamespaces[prefix] + "\"")
+	}
+	for _, attribute := range fields.attributes {
+		b.WriteString("\n    " + attribute[0] + "=\"" + xmlEscape(attribute[1]) + "\"")
+	}
+	b.WriteString(">\n")
+	fields.writeElements(&b)
+	b.WriteString("  </rdf:Description>\n </rdf:RDF>\n</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>")
+
+	return b.Bytes()
+}
+
+// writeElements writes the caption and keyword arrays, the children of the description
+func (fields *xmpFields) writeElements(b *bytes.Buffer) {
+
+	if fields.caption != "" {
+		b.WriteString("   <dc:description>\n    <rdf:Alt>\n")
+		b.WriteString("     <rdf:li xml:lang=\"x-default\">" + xmlEscape(fields.caption) + "</rdf:li>\n")
+		b.WriteString("    </rdf:Alt>\n   </dc:description>\n")
+	}
+	if len(fields.subjects) > 0 {
+		writeBag(b, "dc:subject", fields.subjects)
+	}
+	if len(fields.hierarchical) > 0 {
+		writeBag(b, "lr:hierarchicalSubject", fields.hierarchical)
+	}
+}
+
+func writeBag(b *bytes.Buffer, property string, items []string) {
+	b.WriteString("   <" + property + ">\n    <rdf:Bag>\n")
+	for _, item := range items {
+		b.WriteString("     <rdf:li>" + xmlEscape(item) + "</rdf:li>\n")
+	}
+	b.WriteString("    </rdf:Bag>\n   </" + property + ">\n")
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// xmpCoordinate formats a latitude or longitude as XMP GPS coordinates, "35,41.2345N"
+func xmpCoordinate(value float64, positive, negative byte) string {
+	ref := positive
+	if value < 0 {
+		ref, value = negative, -value
+	}
+	degrees := math.Floor(value)
+	return fmt.Sprintf("%d,%.6f%c", int(degrees), (value-degrees)*60, ref)
+}
+
+// mergeXMP writes the fields into an existing XMP packet. The managed properties
+// are removed from every description, then the fields are added to the first one;
+// everything else, from other applications, is kept as it is.
+func mergeXMP(existing []byte, fields *xmpFields) ([]byte, error) {
+
+	type element struct {
+		namespaces  map[string]string // declared on the element, by prefix
+		description bool
+	}
+	var open []element
+
+	resolve := func(prefix string) string {
+		for i := len(open) - 1; i >= 0; i-- {
+			if namespace, declared := open[i].namespaces[prefix]; declared {
+				return namespace
+			}
+		}
+		return ""
+	}
+	managed := func(name xml.Name) bool {
+		if name.Space == "" || name.Space == "xmlns" {
+			return false
+		}
+		return slices.Contains(xmpManaged[resolve(name.Space)], name.Local)
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(existing))
+	var out bytes.Buffer
+	skipping := 0 // depth of the managed property being dropped
+	merging := 0  // depth of the description the fields are added to
+	merged := false
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMetadataCorrupted, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			current := element{namespaces: map[string]string{}}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" {
+					current.namespaces[attr.Name.Local] = attr.Value
+				}
+			}
+			inDescription := len(open) > 0 && open[len(open)-1].description
+			open = append(open, current)
+			if skipping > 0 {
+				continue
+			}
+			if inDescription && managed(t.Name) {
+				skipping = len(open)
+				continue
+			}
+
+			open[len(open)-1].description = resolve(t.Name.Space) == xmpNamespaceRDF && t.Name.Local == "Description"
+			attributes := make([]xml.Attr, 0, len(t.Attr))
+			for _, attr := range t.Attr {
+				if !open[len(open)-1].description || !managed(attr.Name) {
+					attributes = append(attributes, attr)
+				}
+			}
+			if open[len(open)-1].description && !merged {
+				for _, prefix := range xmpPrefixes {
+					if resolve(prefix) != xmpNamespaces[prefix] {
+						attributes = append(attributes, xml.Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: xmpNamespaces[prefix]})
+						open[len(open)-1].namespaces[prefix] = xmpNamespaces[prefix]
+					}
+				}
+				for _, attribute := range fields.attributes {
+					prefix, local, _ := strings.Cut(attribute[0], ":")
+					attributes = append(attributes, xml.Attr{Name: xml.Name{Space: prefix, Local: local}, Value: attribute[1]})
+				}
+				merging, merged = len(open), true
+			}
+			out.WriteString("<" + xmlName(t.Name))
+			for _, attr := range attributes {
+				out.WriteString(" " + xmlName(attr.Name) + "=\"" + xmlAttributeEscaper.Replace(attr.Value) + "\"")
+			}
+			out.WriteString(">")
+
+		case xml.EndElement:
+			depth := len(open)
+			open = open[:depth-1]
+			if skipping > 0 {
+				if depth == skipping {
+					skipping = 0
+				}
+				continue
+			}
+			if depth == merging {
+				out.WriteString("\n")
+				fields.writeElements(&out)
+				out.WriteString("  ")
+				merging = 0
+			}
+			out.WriteString("</" + xmlName(t.Name) + ">")
+
+		case xml.CharData:
+			if skipping == 0 {
+				out.WriteString(xmlTextEscaper.Replace(string(t)))
+			}
+		case xml.Comment:
+			if skipping == 0 {
+				out.WriteString("<!--" + string(t) + "-->")
+			}
+		case xml.ProcInst:
+			out.WriteString("<?" + t.Target)
+			if len(t.Inst) > 0 {
+				out.WriteString(" " + string(t.Inst))
+			}
+			out.WriteString("?>")
+		case xml.Directive:
+			out.WriteString("<!" + string(t) + ">")
+		}
+	}
+
+	if !merged {
+		// Nothing described, no property to keep
+		return fields.packet(), nil
+	}
+	return out.Bytes(), nil
+}
+
+var (
+	xmlTextEscaper      = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;", "\n", "&#xA;", "\t", "&#x9;", "\r", "&#xD;")
+)
+
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// embedXMP writes the fields into the XMP packet of a JPEG, merged with the one
+// already there, or as a new packet placed after the JFIF and EXIF segments. The
+// extension segments and the image data are copied as they are.
+func embedXMP(data []byte, fields *xmpFields) ([]byte, error) {
+
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return nil, ErrUnsupportedFormat
+	}
+
+	packet := fields.packet()
+	existing := findJPEGSegment(data, 0xE1, jpegXMPHeader)
+	if existing != nil {
+		var err error
+		if packet, err = mergeXMP(existing, fields); err != nil {
+			return nil, err
+		}
+	}
+	if len(jpegXMPHeader)+len(packet) > jpegMaxSegment {
+		return nil, fmt.Errorf("xmp packet of %d bytes does not fit in a JPEG segment", len(packet))
+	}
+
+	segment := make([]byte, 4, 4+len(jpegXMPHeader)+len(packet))
+	segment[0], segment[1] = 0xFF, 0xE1
+	binary.BigEndian.PutUint16(segment[2:], uint16(2+len(jpegXMPHeader)+len(packet)))
+	segment = append(append(segment, jpegXMPHeader...), packet...)
+
+	out := make([]byte, 0, len(data)+len(segment))
+	out = append(out, data[:2]...)
+	inserted := false
+
+	offset := 2
+	for offset+4 <= len(data) && data[offset] == 0xFF {
+		marker := data[offset+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrMetadataCorrupted
+		}
+
+		if marker == 0xE1 && bytes.HasPrefix(data[offset+4:end], jpegXMPHeader) {
+			// Replaced in place; a second standard packet is invalid and dropped
+			if !inserted {
+				out = append(out, segment...)
+				inserted = true
+			}
+			offset = end
+			continue
+		}
+		if !inserted && existing == nil && marker != 0xE0 && marker != 0xE1 {
+			out = append(out, segment...)
+			inserted = true
+		}
+		out = append(out, data[offset:end]...)
+		offset = end
+	}
+
+	if !inserted {
+		out = append(out, segment...)
+	}
+	return append(out, data[offset:]...), nil
+}
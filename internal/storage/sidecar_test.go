@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// A packet written by another application, with properties of its own
+const lightroomPacket = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:crs="http://ns.adobe.com/camera-raw-settings/1.0/"
+    xmp:Rating="2"
+    crs:Exposure2012="+0.50">
+   <dc:subject xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <rdf:Bag>
+     <rdf:li>old</rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+   <crs:ToneCurvePV2012>
+    <rdf:Seq>
+     <rdf:li>0, 0</rdf:li>
+    </rdf:Seq>
+   </crs:ToneCurvePV2012>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func TestMergeXMP(t *testing.T) {
+
+	fields := &xmpFields{
+		attributes: [][2]string{{"xmp:Rating", "5"}, {"tiff:Make", `A & "B"`}},
+		caption:    "sunset <over> the sea",
+		subjects:   []string{"new"},
+	}
+
+	tests := []struct {
+		name     string
+		existing string
+		keeps    []string // text the merged packet still holds
+		err      bool
+	}{
+		{name: "other application", existing: lightroomPacket, keeps: []string{`crs:Exposure2012="+0.50"`, "<crs:ToneCurvePV2012>", "<rdf:li>0, 0</rdf:li>"}},
+		{name: "own packet", existing: string(fields.packet())},
+		{name: "no description", existing: `<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`},
+		{name: "unterminated", existing: `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF`, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, err := mergeXMP([]byte(test.existing), fields)
+			if test.err {
+				if !errors.Is(err, ErrMetadataCorrupted) {
+					t.Fatalf("error = %v, want %v", err, ErrMetadataCorrupted)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+
+			properties := parseXMP(merged)
+			if properties.rating != 5 || properties.description != fields.caption || !slices.Equal(properties.keywords, fields.subjects) {
+				t.Errorf("properties = %+v", properties)
+			}
+			if strings.Contains(string(merged), "old") || strings.Count(string(merged), "Rating=") != 1 {
+				t.Errorf("managed properties were not replaced:\n%s", merged)
+			}
+			for _, kept := range test.keeps {
+				if !bytes.Contains(merged, []byte(kept)) {
+					t.Errorf("%q was dropped:\n%s", kept, merged)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbedXMP(t *testing.T) {
+
+	app1 := func(header []byte, payload string) []byte {
+		segment := []byte{0xFF, 0xE1, 0, 0}
+		binary.BigEndian.PutUint16(segment[2:], uint16(2+len(header)+len(payload)))
+		return slices.Concat(segment, header, []byte(payload))
+	}
+	extension := app1(jpegExtendedHeader, "0123456789ABCDEF0123456789ABCDEF\x00\x00\x00\x04\x00\x00\x00\x00data")
+	image := []byte{0xFF, 0xDA, 0x00, 0x02, 1, 2, 3, 0xFF, 0xD9}
+	fields := &xmpFields{attributes: [][2]string{{"xmp:Rating", "4"}}}
+
+	data := slices.Concat([]byte{0xFF, 0xD8}, app1(jpegXMPHeader, lightroomPacket), extension, image)
+	embedded, err := embedXMP(data, fields)
+	if err != nil {
+		t.Fatalf("error = %v", err)
+	}
+
+	packet := findJPEGSegment(embedded, 0xE1, jpegXMPHeader)
+	if parseXMP(packet).rating != 4 || !bytes.Contains(packet, []byte("crs:ToneCurvePV2012")) {
+		t.Errorf("packet was not merged:\n%s", packet)
+	}
+	if findJPEGSegment(embedded, 0xE1, jpegExtendedHeader) == nil {
+		t.Errorf("the extension segment was dropped")
+	}
+	if !bytes.HasSuffix(embedded, image) {
+		t.Errorf("the image data changed")
+	}
+
+	// Without a packet, one is added after the EXIF segment
+	exif := exifJPEG(tiffWithEntry(exifTagOrientation, 3, 1, 6))
+	embedded, err = embedXMP(slices.Concat(exif, image), fields)
+	if err != nil {
+		t.Fatalf("error = %v", err)
+	}
+	if !bytes.HasPrefix(embedded, exif) || parseXMP(findJPEGSegment(embedded, 0xE1, jpegXMPHeader)).rating != 4 {
+		t.Errorf("packet was not added after the EXIF segment")
+	}
+
+	if _, err := embedXMP([]byte("not a jpeg"), fields); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("error = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
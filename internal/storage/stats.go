@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"container/list"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/config"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const statsTopCameras = 10
+
+// assetCounters are kept up to date with the date index, so the statistics
+// never walk the assets. Hidden assets are not counted.
+type assetCounters struct {
+	total      int
+	mediaTypes map[string]int
+	years      map[int]int
+	cameras    map[cameraKey]int
+}
+
+type cameraKey struct {
+	make  string
+	model string
+}
+
+func newAssetCounters() *assetCounters {
+	return &assetCounters{
+		mediaTypes: make(map[string]int),
+		years:      make(map[int]int),
+		cameras:    make(map[cameraKey]int),
+	}
+}
+
+func (counters *assetCounters) add(asset *common_models.PHAsset, delta int) {
+
+	if asset.IsHidden {
+		return
+	}
+
+	counters.total += delta
+	counters.mediaTypes[string(asset.MediaType)] += delta
+	counters.years[assetTakenDate(asset).Year()] += delta
+	if asset.CameraMake != "" || asset.CameraModel != "" {
+		counters.cameras[cameraKey{asset.CameraMake, asset.CameraModel}] += delta
+	}
+}
+
+// diskUsage is measured once by walking the folders of the user, then updated
+// as files are written. Guarded by statsMu.
+type diskUsage struct {
+	ready          bool
+	originalBytes  int64
+	thumbnailBytes int64
+	thumbnailCount int
+}
+
+// cacheCounter follows the requests of an image loader to estimate its hit rate.
+// The loader does not report hits itself, so a request counts as a hit when the
+// same file was loaded within the loader TTL and capacity. The files are kept in
+// least recently used order, so each request costs constant time.
+type cacheCounter struct {
+	mu       sync.Mutex
+	name     string
+	capacity int
+	ttl      time.Duration // zero keeps files until evicted by capacity
+	seen     map[string]*list.Element
+	order    *list.List // of *cacheEntry, most recent first
+	hits     int64
+	misses   int64
+}
+
+type cacheEntry struct {
+	filename string
+	last     time.Time
+}
+
+func newCacheCounter(name string, capacity int, ttl time.Duration) *cacheCounter {
+	return &cacheCounter{
+		name:     name,
+		capacity: capacity,
+		ttl:      ttl,
+		seen:     make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (counter *cacheCounter) record(filename string) {
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	now := time.Now()
+	if element, exists := counter.seen[filename]; exists {
+		entry := element.Value.(*cacheEntry)
+		if counter.ttl == 0 || now.Sub(entry.last) < counter.ttl {
+			counter.hits++
+		} else {
+			counter.misses++
+		}
+		entry.last = now
+		counter.order.MoveToFront(element)
+	} else {
+		counter.misses++
+		counter.seen[filename] = counter.order.PushFront(&cacheEntry{filename, now})
+	}
+
+	counter.evict(now)
+}
+
+// evict drops the least recently used files over the capacity and the expired ones,
+// which are all at the back of the list
+func (counter *cacheCounter) evict(now time.Time) {
+	for back := counter.order.Back(); back != nil; back = counter.order.Back() {
+		entry := back.Value.(*cacheEntry)
+		if counter.order.Len() <= counter.capacity && (counter.ttl == 0 || now.Sub(entry.last) < counter.ttl) {
+			return
+		}
+		counter.order.Remove(back)
+		delete(counter.seen, entry.filename)
+	}
+}
+
+func (counter *cacheCounter) stats() *model.CacheStats {
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	stats := &model.CacheStats{
+		Name:      counter.name,
+		Size:      len(counter.seen),
+		Hits:      counter.hits,
+		Misses:    counter.misses,
+		Estimated: true,
+	}
+	if total := counter.hits + counter.misses; total > 0 {
+		stats.HitRate = float64(counter.hits) / float64(total)
+	}
+	return stats
+}
+
+// GetStats returns the statistics of the user library
+func (us *UserStorageManager) GetStats(userID int) (*model.LibraryStats, error) {
+
+	userStorage, err := us.GetUserStorage(nil, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := userStorage.getStats()
+	stats.Caches = append(stats.Caches, us.iconCache.stats())
+
+	return stats, nil
+}
+
+func (userStorage *UserStorage) getStats() *model.LibraryStats {
+
+	stats := &model.LibraryStats{
+		MediaTypes: make(map[string]int),
+	}
+
+	userStorage.mu.RLock()
+	counters := userStorage.counters
+	stats.TotalAssets = counters.total
+	for mediaType, count := range counters.mediaTypes {
+		if count > 0 {
+			stats.MediaTypes[mediaType] = count
+		}
+	}
+	for year, count := range counters.years {
+		if count > 0 {
+			stats.Years = append(stats.Years, &model.YearCount{Year: year, Count: count})
+		}
+	}
+	for camera, count := range counters.cameras {
+		if count > 0 {
+			stats.TopCameras = append(stats.TopCameras, &model.CameraCount{CameraMake: camera.make, CameraModel: camera.model, Count: count})
+		}
+	}
+	stats.Index = model.IndexStatus{
+		LastRebuild: userStorage.lastRebuild,
+		AssetCount:  len(userStorage.assets),
+		IndexSize:   len(userStorage.timeline),
+	}
+	stats.Quota = userStorage.quotaUsage(len(userStorage.assets))
+	userStorage.mu.RUnlock()
+
+	sort.Slice(stats.Years, func(i, j int) bool { return stats.Years[i].Year > stats.Years[j].Year })
+	sort.Slice(stats.TopCameras, func(i, j int) bool {
+		if stats.TopCameras[i].Count != stats.TopCameras[j].Count {
+			return stats.TopCameras[i].Count > stats.TopCameras[j].Count
+		}
+		return stats.TopCameras[i].CameraModel < stats.TopCameras[j].CameraModel
+	})
+	if len(stats.TopCameras) > statsTopCameras {
+		stats.TopCameras = stats.TopCameras[:statsTopCameras]
+	}
+
+	userStorage.statsMu.Lock()
+	stats.OriginalBytes = userStorage.usage.originalBytes
+	stats.ThumbnailBytes = userStorage.usage.thumbnailBytes
+	stats.ThumbnailCount = userStorage.usage.thumbnailCount
+	stats.Index.RebuildInProgress = !userStorage.usage.ready
+	userStorage.statsMu.Unlock()
+
+	now := time.Now()
+	uploads, _ := userStorage.ActivityManager.GetList(func(a *model.Activity) bool {
+		return a.Type == model.ActivityUpload && now.Sub(a.CreationDate) < 7*24*time.Hour
+	})
+	for _, upload := range uploads {
+		stats.Uploads7d += len(upload.AssetIds)
+		if now.Sub(upload.CreationDate) < 24*time.Hour {
+			stats.Uploads24h += len(upload.AssetIds)
+		}
+	}
+
+	stats.Caches = []*model.CacheStats{userStorage.originalCache.stats(), userStorage.tinyCache.stats(), userStorage.derivatives.stats()}
+
+	return stats
+}
+
+// prepareDiskUsage measures the folders of the user once, later writes update the totals
+func (userStorage *UserStorage) prepareDiskUsage() {
+
+	originalBytes, _ := folderSize(config.GetUserPath(userStorage.user.PhoneNumber, "assets"))
+	thumbnailBytes, thumbnailCount := folderSize(config.GetUserPath(userStorage.user.PhoneNumber, "thumbnails"))
+
+	userStorage.statsMu.Lock()
+	defer userStorage.statsMu.Unlock()
+
+	// Files written since the storage was loaded were added as they were written
+	userStorage.usage.originalBytes += originalBytes
+	userStorage.usage.thumbnailBytes += thumbnailBytes
+	userStorage.usage.thumbnailCount += thumbnailCount
+	userStorage.usage.ready = true
+}
+
+// addOriginalBytes and addThumbnailBytes account for files written or removed
+func (userStorage *UserStorage) addOriginalBytes(n int64) {
+	userStorage.statsMu.Lock()
+	userStorage.usage.originalBytes += n
+	userStorage.statsMu.Unlock()
+}
+
+func (userStorage *UserStorage) addThumbnailBytes(n int64, files int) {
+	userStorage.statsMu.Lock()
+	userStorage.usage.thumbnailBytes += n
+	userStorage.usage.thumbnailCount += files
+	userStorage.statsMu.Unlock()
+}
+
+func folderSize(dir string) (int64, int) {
+
+	var size int64
+	var count int
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		size += info.Size()
+		count++
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to measure %s: %v", dir, err)
+	}
+
+	return size, count
+}
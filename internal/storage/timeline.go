@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// timelineDay is an entry of the date index: the assets taken on one day
+type timelineDay struct {
+	date     time.Time
+	assetIds []int
+	visible  int // assets that are not hidden
+}
+
+// GetTimeline counts the assets matching the fetch options per year, month and day,
+// the filters on ratings, labels and keywords included.
+// Without filters, or with the hidden filter only, the counts come from the counters
+// of each day and cost one step per day. Other filters check the assets of each day,
+// nothing is sorted or copied. The user, sorting and paging options are ignored.
+func (userStorage *UserStorage) GetTimeline(options model.AssetFetchOptions) *model.Timeline {
+
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+
+	with := options.PHFetchOptions
+	with.UserID = 0
+	with.SortBy, with.SortOrder = "", ""
+	with.FetchOffset, with.FetchLimit = 0, 0
+
+	hidden := with.IsHidden
+	with.IsHidden = nil
+	filtered := !reflect.ValueOf(with).IsZero() || detailsFiltered(options)
+	with.IsHidden = hidden
+	criteria := assetBuildCriteria(with, userStorage.details)
+	if detailsFiltered(options) {
+		criteria = detailsCriteria(options, userStorage.details, criteria)
+	}
+
+	days := make([]*timelineDay, 0, len(userStorage.timeline))
+	for _, day := range userStorage.timeline {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].date.After(days[j].date) })
+
+	timeline := &model.Timeline{}
+	var year *model.TimelineYear
+	var month *model.TimelineMonth
+
+	for _, day := range days {
+
+		count := day.visible
+		switch {
+		case filtered:
+			count = 0
+			for _, id := range day.assetIds {
+				if asset, exists := userStorage.assets[id]; exists && criteria(*asset) {
+					count++
+				}
+			}
+		case hidden != nil && *hidden:
+			count = len(day.assetIds) - day.visible
+		}
+		if count == 0 {
+			continue
+		}
+
+		if year == nil || year.Year != day.date.Year() {
+			year = &model.TimelineYear{Year: day.date.Year()}
+			month = nil
+			timeline.Years = append(timeline.Years, year)
+		}
+		if month == nil || month.Month != int(day.date.Month()) {
+			month = &model.TimelineMonth{Month: int(day.date.Month())}
+			year.Months = append(year.Months, month)
+		}
+
+		month.Days = append(month.Days, &model.TimelineDay{Day: day.date.Day(), Count: count})
+		month.Count += count
+		year.Count += count
+		timeline.Total += count
+	}
+
+	return timeline
+}
+
+// prepareTimeline builds the date index and the asset counters of the statistics
+// when the storage is opened. Afterwards they are kept up to date by indexAsset and
+// unindexAsset around every change of an asset.
+func (userStorage *UserStorage) prepareTimeline() {
+
+	userStorage.mu.Lock()
+	defer userStorage.mu.Unlock()
+
+	userStorage.timeline = make(map[string]*timelineDay)
+	userStorage.counters = newAssetCounters()
+	for _, asset := range userStorage.assets {
+		userStorage.indexAsset(asset)
+	}
+	userStorage.lastRebuild = time.Now()
+}
+
+// indexAsset adds an asset to the date index and the counters, callers hold the write lock
+func (userStorage *UserStorage) indexAsset(asset *common_models.PHAsset) {
+
+	userStorage.counters.add(asset, 1)
+
+	taken := assetTakenDate(asset)
+	key := taken.Format("2006-01-02")
+
+	day, exists := userStorage.timeline[key]
+	if !exists {
+		day = &timelineDay{date: time.Date(taken.Year(), taken.Month(), taken.Day(), 0, 0, 0, 0, time.UTC)}
+		userStorage.timeline[key] = day
+	}
+
+	day.assetIds = append(day.assetIds, asset.ID)
+	if !asset.IsHidden {
+		day.visible++
+	}
+}
+
+// unindexAsset removes an asset from the date index and the counters, callers hold the write lock
+func (userStorage *UserStorage) unindexAsset(asset *common_models.PHAsset) {
+
+	userStorage.counters.add(asset, -1)
+
+	key := assetTakenDate(asset).Format("2006-01-02")
+
+	day, exists := userStorage.timeline[key]
+	if !exists {
+		return
+	}
+
+	for i, id := range day.assetIds {
+		if id == asset.ID {
+			day.assetIds = append(day.assetIds[:i], day.assetIds[i+1:]...)
+			if !asset.IsHidden {
+				day.visible--
+			}
+			break
+		}
+	}
+
+	if len(day.assetIds) == 0 {
+		delete(userStorage.timeline, key)
+	}
+}
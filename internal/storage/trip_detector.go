@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"math"
+	"sort"
+	"time"
+)
+
+// Trip detection walks the geotagged assets in capture order. Assets taken far from
+// the inferred home location are grouped while the gap between them stays short,
+// any asset taken at home closes the running group.
+const (
+	tripMinDistance    = 50.0           // kilometers from home
+	tripMaxGap         = 36 * time.Hour // between two assets of the same trip
+	tripMinAssets      = 5
+	homeCellSize       = 0.05 // degrees, about 5 km
+	villageMaxDistance = 30.0 // kilometers, for naming a trip after a village
+)
+
+type tripCluster struct {
+	assets []*common_models.PHAsset
+	coords []Coordinate
+}
+
+// DetectTrips refreshes the trip suggestions from the library and returns the pending ones, newest first
+func (userStorage *UserStorage) DetectTrips() ([]*model.TripSuggestion, error) {
+
+	userStorage.tripMu.Lock()
+	defer userStorage.tripMu.Unlock()
+
+	userStorage.mu.Lock()
+	defer userStorage.mu.Unlock()
+
+	var located []*common_models.PHAsset
+	for _, asset := range userStorage.assets {
+		if _, ok := assetCoordinate(asset); !ok || asset.CapturedDate.IsZero() || asset.IsHidden {
+			continue
+		}
+		located = append(located, asset)
+	}
+
+	sort.Slice(located, func(i, j int) bool {
+		return located[i].CapturedDate.Before(located[j].CapturedDate)
+	})
+
+	if home, ok := inferHomeLocation(located); ok {
+
+		existing, err := userStorage.TripSuggestionManager.GetAll()
+		if err != nil {
+			return nil, err
+		}
+
+		villages, _ := userStorage.VillageManager.GetAll()
+
+		for _, cluster := range clusterTrips(located, home) {
+
+			if cluster.inTrips() {
+				continue
+			}
+
+			suggestion := newTripSuggestion(cluster, home, villages)
+
+			match := findTripSuggestion(existing, suggestion.AssetIds)
+			switch {
+			case match == nil:
+				created, err := userStorage.TripSuggestionManager.Create(suggestion)
+				if err != nil {
+					return nil, err
+				}
+				existing = append(existing, created)
+			case match.Status == model.TripSuggestionPending:
+				// The trip grew or shrank since the last run
+				match.AssetIds = suggestion.AssetIds
+				match.StartDate = suggestion.StartDate
+				match.EndDate = suggestion.EndDate
+				match.Distance = suggestion.Distance
+				if _, err := userStorage.TripSuggestionManager.Update(match); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return userStorage.getPendingTripSuggestions()
+}
+
+// AcceptTripSuggestion creates the trip of a suggestion and assigns its assets to it.
+// The status is checked under tripMu so a suggestion makes one trip; assigning a
+// trip moves no asset, the update does not run DetectTrips again.
+func (userStorage *UserStorage) AcceptTripSuggestion(request model.TripSuggestionRequest) (*model.Trip, error) {
+
+	userStorage.tripMu.Lock()
+	defer userStorage.tripMu.Unlock()
+
+	suggestion, err := userStorage.TripSuggestionManager.Get(request.ID)
+	if err != nil || suggestion.Status != model.TripSuggestionPending {
+		return nil, ErrTripSuggestionNotFound
+	}
+
+	title := suggestion.Title
+	if request.Title != "" {
+		title = request.Title
+	}
+
+	trip, err := userStorage.TripManager.Create(&model.Trip{
+		Title:    title,
+		Subtitle: suggestion.Subtitle,
+		TripType: model.TripTypeDetected,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	update := common_models.AssetUpdate{AssetIds: suggestion.AssetIds, AddTrips: []int{trip.ID}}
+	if _, err := userStorage.UpdateAsset(model.AssetUpdate{AssetUpdate: update}); err != nil {
+		return nil, err
+	}
+
+	suggestion.Status = model.TripSuggestionAccepted
+	suggestion.TripID = trip.ID
+	if _, err := userStorage.TripSuggestionManager.Update(suggestion); err != nil {
+		return nil, err
+	}
+
+	userStorage.UpdateCollections()
+
+	return trip, nil
+}
+
+// DismissTripSuggestion hides a suggestion, it will not be proposed again
+func (userStorage *UserStorage) DismissTripSuggestion(request model.TripSuggestionRequest) error {
+
+	userStorage.tripMu.Lock()
+	defer userStorage.tripMu.Unlock()
+
+	suggestion, err := userStorage.TripSuggestionManager.Get(request.ID)
+	if err != nil || suggestion.Status != model.TripSuggestionPending {
+		return ErrTripSuggestionNotFound
+	}
+
+	suggestion.Status = model.TripSuggestionDismissed
+	_, err = userStorage.TripSuggestionManager.Update(suggestion)
+	return err
+}
+
+func (userStorage *UserStorage) getPendingTripSuggestions() ([]*model.TripSuggestion, error) {
+
+	items, err := userStorage.TripSuggestionManager.GetList(func(a *model.TripSuggestion) bool {
+		return a.Status == model.TripSuggestionPending
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].StartDate.After(items[j].StartDate)
+	})
+
+	return items, nil
+}
+
+// inferHomeLocation returns the center of the area where assets were taken on the most distinct days
+func inferHomeLocation(assets []*common_models.PHAsset) (Coordinate, bool) {
+
+	type cell struct{ lat, lon int }
+
+	days := make(map[cell]map[string]bool)
+	coords := make(map[cell][]Coordinate)
+
+	for _, asset := range assets {
+		coord, _ := assetCoordinate(asset)
+		key := cell{
+			lat: int(math.Floor(coord.Latitude / homeCellSize)),
+			lon: int(math.Floor(coord.Longitude / homeCellSize)),
+		}
+		if days[key] == nil {
+			days[key] = make(map[string]bool)
+		}
+		days[key][asset.CapturedDate.Format("2006-01-02")] = true
+		coords[key] = append(coords[key], coord)
+	}
+
+	var home cell
+	best := 0
+	for key, set := range days {
+		if len(set) > best {
+			home = key
+			best = len(set)
+		}
+	}
+	if best == 0 {
+		return Coordinate{}, false
+	}
+
+	return centerOf(coords[home]), true
+}
+
+// clusterTrips groups the assets, sorted by capture date, taken away from home
+func clusterTrips(assets []*common_models.PHAsset, home Coordinate) []tripCluster {
+
+	var clusters []tripCluster
+	var current tripCluster
+
+	closeCurrent := func() {
+		if len(current.assets) >= tripMinAssets {
+			clusters = append(clusters, current)
+		}
+		current = tripCluster{}
+	}
+
+	for _, asset := range assets {
+
+		coord, _ := assetCoordinate(asset)
+		if haversineDistance(home, coord) < tripMinDistance {
+			closeCurrent()
+			continue
+		}
+
+		if n := len(current.assets); n > 0 && asset.CapturedDate.Sub(current.assets[n-1].CapturedDate) > tripMaxGap {
+			closeCurrent()
+		}
+
+		current.assets = append(current.assets, asset)
+		current.coords = append(current.coords, coord)
+	}
+	closeCurrent()
+
+	return clusters
+}
+
+// inTrips reports whether most assets of the cluster already belong to a trip
+func (cluster tripCluster) inTrips() bool {
+	count := 0
+	for _, asset := range cluster.assets {
+		if len(asset.Trips) > 0 {
+			count++
+		}
+	}
+	return count*2 > len(cluster.assets)
+}
+
+func newTripSuggestion(cluster tripCluster, home Coordinate, villages []*model.Village) *model.TripSuggestion {
+
+	center := centerOf(cluster.coords)
+	start := cluster.assets[0].CapturedDate
+	end := cluster.assets[len(cluster.assets)-1].CapturedDate
+
+	distance := 0.0
+	for _, coord := range cluster.coords {
+		distance = math.Max(distance, haversineDistance(home, coord))
+	}
+
+	suggestion := &model.TripSuggestion{
+		Latitude:  center.Latitude,
+		Longitude: center.Longitude,
+		Distance:  math.Round(distance*10) / 10,
+		StartDate: start,
+		EndDate:   end,
+		AssetIds:  make([]int, len(cluster.assets)),
+		Status:    model.TripSuggestionPending,
+	}
+
+	for i, asset := range cluster.assets {
+		suggestion.AssetIds[i] = asset.ID
+	}
+
+	if village := nearestVillage(villages, center, villageMaxDistance); village != nil {
+		suggestion.Place = village.Name
+		suggestion.Title = "Trip to " + village.Name
+	} else {
+		suggestion.Title = "Trip in " + start.Format("January 2006")
+	}
+
+	if start.Format("2006-01-02") == end.Format("2006-01-02") {
+		suggestion.Subtitle = start.Format("2 Jan 2006")
+	} else {
+		suggestion.Subtitle = fmt.Sprintf("%s - %s", start.Format("2 Jan"), end.Format("2 Jan 2006"))
+	}
+
+	return suggestion
+}
+
+// findTripSuggestion returns the suggestion sharing assets with assetIds
+func findTripSuggestion(items []*model.TripSuggestion, assetIds []int) *model.TripSuggestion {
+
+	ids := make(map[int]bool, len(assetIds))
+	for _, id := range assetIds {
+		ids[id] = true
+	}
+
+	for _, item := range items {
+		for _, id := range item.AssetIds {
+			if ids[id] {
+				return item
+			}
+		}
+	}
+
+	return nil
+}
+
+// nearestVillage returns the closest village within maxDistance kilometers, or nil
+func nearestVillage(villages []*model.Village, point Coordinate, maxDistance float64) *model.Village {
+
+	var nearest *model.Village
+	for _, village := range villages {
+		distance := haversineDistance(point, Coordinate{Latitude: village.Latitude, Longitude: village.Longitude})
+		if distance <= maxDistance {
+			nearest = village
+			maxDistance = distance
+		}
+	}
+
+	return nearest
+}
+
+func centerOf(coords []Coordinate) Coordinate {
+	var center Coordinate
+	if len(coords) == 0 {
+		return center
+	}
+	for _, coord := range coords {
+		center.Latitude += coord.Latitude
+		center.Longitude += coord.Longitude
+	}
+	center.Latitude /= float64(len(coords))
+	center.Longitude /= float64(len(coords))
+	return center
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"math"
+	"sort"
+	"time"
+)
+
+const villageVisitDistance = 10.0 // kilometers, for counting a village as visited
+
+// GetTripDetail builds the day-by-day itinerary of a trip
+func (userStorage *UserStorage) GetTripDetail(id int) (*model.TripDetail, error) {
+
+	trip, err := userStorage.TripManager.Get(id)
+	if err != nil {
+		return nil, ErrTripNotFound
+	}
+
+	assets, _, err := userStorage.FetchAssets(common_models.PHFetchOptions{
+		UserID: userStorage.user.ID,
+		Trips:  []int{id},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(assets, func(i, j int) bool {
+		return assetTakenDate(assets[i]).Before(assetTakenDate(assets[j]))
+	})
+
+	villages, _ := userStorage.VillageManager.GetAll()
+
+	detail := &model.TripDetail{
+		Trip:   trip,
+		Places: []string{},
+		Days:   []model.TripDay{},
+		Route:  model.GeoJSONFeature{Type: "Feature"},
+	}
+
+	if len(assets) == 0 {
+		detail.Route.Properties = map[string]any{"tripID": trip.ID, "distance": 0.0}
+		return detail, nil
+	}
+
+	detail.StartDate = assetTakenDate(assets[0])
+	detail.EndDate = assetTakenDate(assets[len(assets)-1])
+
+	var last *Coordinate
+	var day *model.TripDay
+	var route [][]float64
+
+	for _, asset := range assets {
+
+		date := assetTakenDate(asset).Format("2006-01-02")
+		if day == nil || day.Date != date {
+			detail.Days = append(detail.Days, model.TripDay{Date: date, Places: []string{}})
+			day = &detail.Days[len(detail.Days)-1]
+		}
+		day.Assets = append(day.Assets, asset)
+
+		coord, ok := assetCoordinate(asset)
+		if !ok {
+			continue
+		}
+
+		if village := nearestVillage(villages, coord, villageVisitDistance); village != nil {
+			detail.Places = appendPlace(detail.Places, village.Name)
+			day.Places = appendPlace(day.Places, village.Name)
+		}
+
+		if last != nil && *last == coord {
+			continue
+		}
+		if last != nil {
+			step := haversineDistance(*last, coord)
+			detail.Distance += step
+			day.Distance += step
+		}
+		route = append(route, []float64{coord.Longitude, coord.Latitude})
+		last = &coord
+	}
+
+	detail.Distance = math.Round(detail.Distance*10) / 10
+	for i := range detail.Days {
+		detail.Days[i].Distance = math.Round(detail.Days[i].Distance*10) / 10
+	}
+
+	detail.Route.Geometry = routeGeometry(route)
+	detail.Route.Properties = map[string]any{
+		"tripID":    trip.ID,
+		"distance":  detail.Distance,
+		"startDate": detail.StartDate,
+		"endDate":   detail.EndDate,
+	}
+
+	return detail, nil
+}
+
+// routeGeometry makes the geometry of a route: none without positions, a Point
+// for a single one, as a LineString needs two
+func routeGeometry(route [][]float64) *model.GeoJSONGeometry {
+	switch len(route) {
+	case 0:
+		return nil
+	case 1:
+		return &model.GeoJSONGeometry{Type: "Point", Coordinates: route[0]}
+	default:
+		return &model.GeoJSONGeometry{Type: "LineString", Coordinates: route}
+	}
+}
+
+// assetTakenDate is the capture date, or the creation date for assets without one
+func assetTakenDate(asset *common_models.PHAsset) time.Time {
+	if asset.CapturedDate.IsZero() {
+		return asset.CreationDate
+	}
+	return asset.CapturedDate
+}
+
+func appendPlace(places []string, name string) []string {
+	for _, place := range places {
+		if place == name {
+			return places
+		}
+	}
+	return append(places, name)
+}
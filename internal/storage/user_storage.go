@@ -5,12 +5,20 @@ import (
 	"fmt"
 	"github.com/mahdi-cpp/api-go-pkg/collection"
 	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	asset_create "github.com/mahdi-cpp/api-go-pkg/exif"
 	"github.com/mahdi-cpp/api-go-pkg/image_loader"
 	"github.com/mahdi-cpp/api-go-pkg/metadata"
 	"github.com/mahdi-cpp/api-go-pkg/thumbnail"
+	"github.com/mahdi-cpp/photocloud_v2/config"
 	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -19,102 +27,251 @@ import (
 
 type UserStorage struct {
 	//config              Config
-	mu                  sync.RWMutex // Protects all indexes and maps
-	user                common_models.User
-	originalImageLoader *image_loader.ImageLoader
-	tinyImageLoader     *image_loader.ImageLoader
-	assets              map[int]*common_models.PHAsset
-	cameras             map[string]*common_models.PHCollection[model.Camera]
-	AlbumManager        *collection.Manager[*model.Album]
-	TripManager         *collection.Manager[*model.Trip]
-	PersonManager       *collection.Manager[*model.Person]
-	PinnedManager       *collection.Manager[*model.Pinned]
-	SharedAlbumManager  *collection.Manager[*model.SharedAlbum]
-	VillageManager      *collection.Manager[*model.Village]
-	metadata            *metadata.AssetMetadataManager
-	thumbnail           *thumbnail.ThumbnailManager
-	lastID              int
-	lastRebuild         time.Time
-	maintenanceCtx      context.Context
-	cancelMaintenance   context.CancelFunc
-	statsMu             sync.Mutex
+	mu                      sync.RWMutex // Protects all indexes and maps
+	user                    common_models.User
+	originalImageLoader     *image_loader.ImageLoader
+	tinyImageLoader         *image_loader.ImageLoader
+	assets                  map[int]*common_models.PHAsset
+	hiddenFiles             map[string]bool         // File names of hidden assets, without extension
+	timeline                map[string]*timelineDay // Date index, by the day the asset was taken
+	counters                *assetCounters
+	cameras                 map[string]*common_models.PHCollection[model.Camera]
+	AlbumManager            *collection.Manager[*model.Album]
+	TripManager             *collection.Manager[*model.Trip]
+	PersonManager           *collection.Manager[*model.Person]
+	FaceRegionManager       *collection.Manager[*model.FaceRegion]
+	FaceScanManager         *collection.Manager[*model.FaceScan]
+	PersonSuggestionManager *collection.Manager[*model.PersonSuggestion]
+	faceDetector            FaceDetector
+	faceMu                  sync.Mutex // Serializes face scans and clustering
+	tripMu                  sync.Mutex // Serializes trip detection and changes to suggestions, taken before mu
+	PinnedManager           *collection.Manager[*model.Pinned]
+	VillageManager          *collection.Manager[*model.Village]
+	ActivityManager         *collection.Manager[*model.Activity]
+	TripSuggestionManager   *collection.Manager[*model.TripSuggestion]
+	VideoManager            *collection.Manager[*model.VideoMetadata]
+	DetailsManager          *collection.Manager[*model.AssetDetails]
+	details                 map[int]*model.AssetDetails // By asset ID
+	EditManager             *collection.Manager[*model.AssetEdit]
+	edits                   map[int]*model.AssetEdit // By asset ID
+	xmpSyncRequests         chan struct{}
+	posterExtractor         PosterExtractor
+	encoders                map[string]ImageEncoder
+	metadata                *metadata.AssetMetadataManager
+	thumbnail               *thumbnail.ThumbnailManager
+	lastID                  int
+	lastRebuild             time.Time
+	maintenanceCtx          context.Context
+	cancelMaintenance       context.CancelFunc
+	statsMu                 sync.Mutex
+	usage                   diskUsage
+	quota                   model.Quota
+	originalCache           *cacheCounter
+	tinyCache               *cacheCounter
+	rewrittenMu             sync.Mutex
+	rewritten               map[string]time.Time // Thumbnails rewritten in place, read past tinyImageLoader until its copies expire
+	derivatives             *derivativeCache
 	//stats               Stats
 }
 
-//
-//func (userStorage *UserStorage) UploadAsset(userID int, file multipart.File, header *multipart.FileHeader) (*common_models.PHAsset, error) {
-//
-//	// Check file size
-//	//if header.Size > userStorage.config.MaxUploadSize {
-//	//	return nil, ErrFileTooLarge
-//	//}
-//
-//	// Read file content
-//	fileBytes, err := io.ReadAll(file)
-//	if err != nil {
-//		return nil, fmt.Errorf("failed to read file: %w", err)
-//	}
-//
-//	// Handler asset filename
-//	ext := filepath.Ext(header.Filename)
-//	filename := fmt.Sprintf("%d%s", 1, ext)
-//	assetPath := filepath.Join(userStorage.config.AssetsDir, filename)
-//
-//	// Save asset file
-//	if err := os.WriteFile(assetPath, fileBytes, 0644); err != nil {
-//		return nil, fmt.Errorf("failed to save asset: %w", err)
-//	}
-//
-//	// Initialize the ImageExtractor with the path to exiftool
-//	extractor := asset_create.NewMetadataExtractor("/usr/local/bin/exiftool")
-//
-//	// Extract metadata
-//	width, height, camera, err := extractor.ExtractMetadata(assetPath)
-//	if err != nil {
-//		log.Printf("Metadata extraction failed: %v", err)
-//	}
-//	mediaType := asset_create.GetMediaType(ext)
-//
-//	// Handler asset
-//	asset := &common_models.PHAsset{
-//		ID:           userStorage.lastID,
-//		UserID:       userID,
-//		Filename:     filename,
-//		CreationDate: time.Now(),
-//		MediaType:    mediaType,
-//		PixelWidth:   width,
-//		PixelHeight:  height,
-//		CameraModel:  camera,
-//	}
-//
-//	// Save metadata
-//	if err := userStorage.metadata.SaveMetadata(asset); err != nil {
-//		// Clean up asset file if metadata save fails
-//		os.Remove(assetPath)
-//		return nil, fmt.Errorf("failed to save metadata: %w", err)
-//	}
-//
-//	// Add to indexes
-//	//userStorage.addToIndexes(asset)
-//
-//	// Update stats
-//	userStorage.statsMu.Lock()
-//	userStorage.stats.TotalAssets++
-//	userStorage.stats.Uploads24h++
-//	userStorage.statsMu.Unlock()
-//
-//	return asset, nil
-//}
+// UploadAsset adds an uploaded file to the library. The body is read and parsed
+// before taking the write lock, a slow transfer does not hold up the library.
+func (userStorage *UserStorage) UploadAsset(userID int, file multipart.File, header *multipart.FileHeader) (*common_models.PHAsset, error) {
+
+	// Uploads over the byte quota are refused before their body is read, the asset
+	// count waits until pairing is known
+	userStorage.mu.RLock()
+	err := userStorage.checkQuota(header.Size, false)
+	userStorage.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
 
+	// Read file content
+	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// The content decides the format, the extension of the upload may be wrong
+	format, err := detectFormat(fileBytes, header.Filename)
+	if err != nil {
+		return nil, model.ErrUnsupportedMedia.Wrap(err)
+	}
+
+	// Images are read in process: EXIF, IPTC and XMP
+	var meta *photoMetadata
+	if !IsVideoFile(format.Ext) {
+		meta = readPhotoMetadata(fileBytes)
+	}
+	pair := readPairInfo(format, header.Filename, fileBytes, meta)
+
+	userStorage.mu.Lock()
+	defer userStorage.mu.Unlock()
+
+	// The video of a Live Photo and the RAW of a RAW+JPEG pair join the asset of
+	// the other file instead of making one of their own
+	paired, details := userStorage.findPair(pair)
+	if err := userStorage.checkQuota(int64(len(fileBytes)), paired == nil); err != nil {
+		return nil, err
+	}
+	if paired != nil {
+		return userStorage.pairUpload(paired, details, pair, format, header.Filename, fileBytes, meta)
+	}
+
+	// Handler asset filename
+	id := userStorage.nextID()
+	ext := format.Ext
+	filename := fmt.Sprintf("%d%s", id, ext)
+	assetPath := filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "assets"), filename)
+
+	// Save asset file
+	if err := os.WriteFile(assetPath, fileBytes, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save asset: %w", err)
+	}
+	userStorage.addOriginalBytes(int64(len(fileBytes)))
+
+	mediaType := asset_create.GetMediaType(ext)
+
+	// Handler asset
+	asset := &common_models.PHAsset{
+		ID:               id,
+		UserID:           userID,
+		Filename:         filename,
+		CreationDate:     time.Now(),
+		ModificationDate: time.Now(),
+		MediaType:        mediaType,
+	}
+
+	if meta != nil {
+		applyPhotoMetadata(asset, meta)
+	}
+
+	// Videos get their size, date and location from the container
+	var video *model.VideoMetadata
+	if IsVideoFile(filename) {
+		video, err = userStorage.processVideo(asset)
+		if err != nil {
+			log.Printf("Video metadata extraction failed: %v", err)
+		}
+	}
+
+	// Save metadata
+	if err := userStorage.metadata.SaveMetadata(asset); err != nil {
+		// Clean up asset file if metadata save fails
+		os.Remove(assetPath)
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	if details, err := userStorage.createDetails(asset, format, header.Filename, fileBytes, meta); err != nil {
+		log.Printf("failed to save details of asset %d: %v", asset.ID, err)
+	} else if meta == nil && pair.contentIdentifier != "" {
+		details.ContentIdentifier = pair.contentIdentifier
+		if _, err := userStorage.DetailsManager.Update(details); err != nil {
+			log.Printf("failed to save details of asset %d: %v", asset.ID, err)
+		}
+	}
+
+	userStorage.assets[asset.ID] = asset
+	userStorage.indexAsset(asset)
+
+	if video != nil {
+		go userStorage.attachPoster(video, filename)
+	}
+
+	// Faces are detected in the background, the scan waits for the lock we hold
+	go func() {
+		if _, err := userStorage.ScanFaces([]int{asset.ID}); err != nil {
+			log.Printf("face scan failed for asset %d: %v", asset.ID, err)
+		}
+	}()
+
+	userStorage.recordActivity(&model.Activity{
+		UserID:   userID,
+		Type:     model.ActivityUpload,
+		AssetIds: []int{asset.ID},
+	})
+
+	return asset, nil
+}
+
+// nextID generates the next asset ID
+func (userStorage *UserStorage) nextID() int {
+	if userStorage.lastID == 0 {
+		for id := range userStorage.assets {
+			if id > userStorage.lastID {
+				userStorage.lastID = id
+			}
+		}
+	}
+	userStorage.lastID++
+	return userStorage.lastID
+}
+
+// GetAsset returns an asset of the library itself. Callers hold mu, the others
+// use GetAssetCopy.
 func (userStorage *UserStorage) GetAsset(assetId int) (*common_models.PHAsset, bool) {
 	asset, exists := userStorage.assets[assetId]
 	return asset, exists
 }
 
+// GetAssetCopy returns a copy of an asset taken under the read lock, safe to read
+// while uploads and updates change the asset
+func (userStorage *UserStorage) GetAssetCopy(assetId int) (*common_models.PHAsset, bool) {
+
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+
+	asset, exists := userStorage.assets[assetId]
+	if !exists {
+		return nil, false
+	}
+	return copyAsset(asset), true
+}
+
+// visibleAssetCopies copies the assets that exist and are not hidden under one read
+// lock, by ID
+func (userStorage *UserStorage) visibleAssetCopies(ids []int) map[int]*common_models.PHAsset {
+
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+
+	assets := make(map[int]*common_models.PHAsset, len(ids))
+	for _, id := range ids {
+		if asset, exists := userStorage.assets[id]; exists && !asset.IsHidden {
+			assets[id] = copyAsset(asset)
+		}
+	}
+	return assets
+}
+
+// copyAsset copies an asset with its collections. Callers hold mu.
+func copyAsset(asset *common_models.PHAsset) *common_models.PHAsset {
+	copied := *asset
+	copied.Albums = slices.Clone(asset.Albums)
+	copied.Trips = slices.Clone(asset.Trips)
+	copied.Persons = slices.Clone(asset.Persons)
+	return &copied
+}
+
+// GetAllAssets returns the assets of the library itself. Callers hold mu.
 func (userStorage *UserStorage) GetAllAssets() map[int]*common_models.PHAsset {
 	return userStorage.assets
 }
 
+// GetAssetIds lists the IDs of the assets under the read lock
+func (userStorage *UserStorage) GetAssetIds() []int {
+
+	userStorage.mu.RLock()
+	defer userStorage.mu.RUnlock()
+
+	ids := make([]int, 0, len(userStorage.assets))
+	for id := range userStorage.assets {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 //func (userStorage *UserStorage) GetAssetContent(id int) ([]byte, error) {
 //	// Get asset to resolve filename
 //	asset, exists := userStorage.GetAsset(id)
@@ -126,17 +283,53 @@ func (userStorage *UserStorage) GetAllAssets() map[int]*common_models.PHAsset {
 //	return os.ReadFile(assetPath)
 //}
 
-func (userStorage *UserStorage) UpdateAsset(update common_models.AssetUpdate) (string, error) {
+// UpdateAsset applies the shared asset update with the metadata edits. Assets whose
+// capture date or location changed move in the date index, and leave the detected
+// trips they no longer fit in; trip suggestions are refreshed afterwards.
+func (userStorage *UserStorage) UpdateAsset(update model.AssetUpdate) (string, error) {
+
+	edit, err := userStorage.prepareMetadataEdit(update)
+	if err != nil {
+		return "", err
+	}
+
+	movedIds, err := userStorage.updateAssets(update, edit)
+	if err != nil {
+		return "", err
+	}
+
+	if len(movedIds) > 0 {
+		if _, err := userStorage.DetectTrips(); err != nil {
+			log.Printf("failed to refresh trip suggestions for user %d: %v", userStorage.user.ID, err)
+		}
+	}
+
+	// Merging strings with the integer ID
+	merged := fmt.Sprintf(" %s, %d:", "update assets count: ", len(update.AssetIds))
+
+	return merged, nil
+}
+
+// updateAssets applies an update under the write lock and returns the moved assets
+func (userStorage *UserStorage) updateAssets(update model.AssetUpdate, edit *metadataEdit) ([]int, error) {
 
 	userStorage.mu.Lock()
 	defer userStorage.mu.Unlock()
 
+	var updatedIds, movedIds []int
+	addedToAlbums := make(map[int][]int) // asset IDs by album, for the activity feed
+	removedFromAlbums := make(map[int][]int)
+
 	for _, assetId := range update.AssetIds {
 
 		asset, exists := userStorage.GetAsset(assetId)
 		if !exists {
 			continue
 		}
+		updatedIds = append(updatedIds, assetId)
+
+		// The date index and counters follow the hidden flag, media type and camera
+		userStorage.unindexAsset(asset)
 
 		// Apply updates
 		if update.Filename != nil {
@@ -163,6 +356,10 @@ func (userStorage *UserStorage) UpdateAsset(update common_models.AssetUpdate) (s
 		if update.IsHidden != nil {
 			asset.IsHidden = *update.IsHidden
 		}
+		if edit.moves() {
+			edit.applyMoves(asset)
+			movedIds = append(movedIds, assetId)
+		}
 
 		// Handle album operations
 		switch {
@@ -182,6 +379,7 @@ func (userStorage *UserStorage) UpdateAsset(update common_models.AssetUpdate) (s
 				if !albumSet[id] {
 					asset.Albums = append(asset.Albums, id)
 					albumSet[id] = true
+					addedToAlbums[id] = append(addedToAlbums[id], assetId)
 				}
 			}
 
@@ -198,6 +396,11 @@ func (userStorage *UserStorage) UpdateAsset(update common_models.AssetUpdate) (s
 						newAlbums = append(newAlbums, id)
 					}
 				}
+				for id := range removeSet {
+					if albumSet[id] {
+						removedFromAlbums[id] = append(removedFromAlbums[id], assetId)
+					}
+				}
 				asset.Albums = newAlbums
 			}
 		}
@@ -279,11 +482,13 @@ func (userStorage *UserStorage) UpdateAsset(update common_models.AssetUpdate) (s
 		}
 
 		asset.ModificationDate = time.Now()
+		userStorage.indexAsset(asset)
 
 		// Save updated metadata
 		if err := userStorage.metadata.SaveMetadata(asset); err != nil {
-			return "", err
+			return nil, err
 		}
+		userStorage.applyDetails(asset, update, edit)
 
 		//for _, asset := range userStorage.assets {
 		//	if asset.ID == asset.ID {
@@ -299,10 +504,39 @@ func (userStorage *UserStorage) UpdateAsset(update common_models.AssetUpdate) (s
 		//userStorage.memory.Put(assetId, asset)
 	}
 
-	// Merging strings with the integer ID
-	merged := fmt.Sprintf(" %s, %d:", "update assets count: ", len(update.AssetIds))
+	// Activity only for the assets whose albums changed, in the order of the request
+	for _, albumID := range update.AddAlbums {
+		if assetIds := addedToAlbums[albumID]; len(assetIds) > 0 {
+			delete(addedToAlbums, albumID)
+			userStorage.recordActivity(&model.Activity{
+				UserID:         userStorage.user.ID,
+				Type:           model.ActivityAlbumAdd,
+				CollectionType: model.ActivityCollectionAlbum,
+				CollectionID:   albumID,
+				AssetIds:       assetIds,
+			})
+		}
+	}
+	for _, albumID := range update.RemoveAlbums {
+		if assetIds := removedFromAlbums[albumID]; len(assetIds) > 0 {
+			delete(removedFromAlbums, albumID)
+			userStorage.recordActivity(&model.Activity{
+				UserID:         userStorage.user.ID,
+				Type:           model.ActivityAlbumRemove,
+				CollectionType: model.ActivityCollectionAlbum,
+				CollectionID:   albumID,
+				AssetIds:       assetIds,
+			})
+		}
+	}
 
-	return merged, nil
+	userStorage.leaveDetectedTrips(movedIds)
+
+	if len(updatedIds) > 0 {
+		userStorage.requestXMPSync()
+	}
+
+	return movedIds, nil
 }
 
 func (userStorage *UserStorage) UpdateCollections() {
@@ -311,6 +545,7 @@ func (userStorage *UserStorage) UpdateCollections() {
 	userStorage.prepareTrips()
 	userStorage.preparePersons()
 	userStorage.preparePinned()
+	userStorage.prepareHidden()
 }
 
 //func (userStorage *UserStorage) GetSystemStats() Stats {
@@ -320,14 +555,25 @@ func (userStorage *UserStorage) UpdateCollections() {
 //}
 
 func (userStorage *UserStorage) FetchAssets(with common_models.PHFetchOptions) ([]*common_models.PHAsset, int, error) {
+	return userStorage.FilterAssets(model.AssetFetchOptions{PHFetchOptions: with})
+}
+
+// FilterAssets fetches assets with the shared options and the filters on ratings,
+// labels and keywords
+func (userStorage *UserStorage) FilterAssets(options model.AssetFetchOptions) ([]*common_models.PHAsset, int, error) {
 
 	userStorage.mu.RLock()
 	defer userStorage.mu.RUnlock()
 
 	//startTime := time.Now()
 
+	with := options.PHFetchOptions
+
 	// Step 1: Build criteria from with
-	criteria := assetBuildCriteria(with)
+	criteria := assetBuildCriteria(with, userStorage.details)
+	if detailsFiltered(options) {
+		criteria = detailsCriteria(options, userStorage.details, criteria)
+	}
 
 	// Step 2: Find all matching assets (store pointers to original assets)
 	var matches []*common_models.PHAsset
@@ -463,7 +709,7 @@ func (userStorage *UserStorage) prepareCameras() {
 	}
 
 	for _, asset := range userStorage.assets {
-		if asset.CameraModel == "" {
+		if asset.CameraModel == "" || asset.IsHidden {
 			continue
 		}
 
@@ -555,9 +801,18 @@ func (userStorage *UserStorage) preparePinned() {
 			assets = append(assets, &asset)
 			userStorage.PinnedManager.ItemAssets[item.ID] = assets
 			break
+		case "memories":
+			memories := userStorage.GetMemories(time.Now())
+			item.Count = len(memories.Collections)
+			var assets []*common_models.PHAsset
+			if item.Count > 0 && len(memories.Collections[0].Assets) > 0 {
+				assets = memories.Collections[0].Assets[:1]
+			}
+			userStorage.PinnedManager.ItemAssets[item.ID] = assets
+			break
 		case "album":
 			album, err := userStorage.AlbumManager.Get(item.AlbumID)
-			if err != nil {
+			if err != nil || album.IsHidden {
 				continue
 			}
 			item.Title = album.Title
@@ -607,11 +862,28 @@ func (userStorage *UserStorage) DeleteAsset(id int) error {
 	//	return fmt.Errorf("failed to delete asset file: %w", err)
 	//}
 
+	asset, exists := userStorage.assets[id]
+	if !exists {
+		return ErrAssetNotFound
+	}
+
 	// Delete metadata
 	if err := userStorage.metadata.DeleteMetadata(id); err != nil {
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
 
+	delete(userStorage.assets, id)
+	userStorage.unindexAsset(asset)
+	userStorage.deleteDetails(id)
+	userStorage.deleteEdit(id)
+	userStorage.derivatives.removeAsset(id, userStorage.addThumbnailBytes)
+
+	userStorage.recordActivity(&model.Activity{
+		UserID:   userStorage.user.ID,
+		Type:     model.ActivityDelete,
+		AssetIds: []int{id},
+	})
+
 	// Delete thumbnail (if exists)
 	//userStorage.thumbnail.DeleteThumbnails(id)
 
@@ -629,7 +901,7 @@ func (userStorage *UserStorage) DeleteAsset(id int) error {
 	return nil
 }
 
-func assetBuildCriteria(with common_models.PHFetchOptions) assetSearchCriteria[common_models.PHAsset] {
+func assetBuildCriteria(with common_models.PHFetchOptions, details map[int]*model.AssetDetails) assetSearchCriteria[common_models.PHAsset] {
 
 	return func(asset common_models.PHAsset) bool {
 
@@ -679,6 +951,10 @@ func assetBuildCriteria(with common_models.PHFetchOptions) assetSearchCriteria[c
 		if with.IsScreenshot != nil && *with.IsScreenshot != asset.IsScreenshot {
 			return false
 		}
+		// Hidden assets are only returned when asked for explicitly
+		if with.IsHidden == nil && asset.IsHidden {
+			return false
+		}
 		if with.IsHidden != nil && *with.IsHidden != asset.IsHidden {
 			return false
 		}
@@ -698,9 +974,10 @@ func assetBuildCriteria(with common_models.PHFetchOptions) assetSearchCriteria[c
 			return false
 		}
 
-		// Filter by landscape orientation
+		// Filter by landscape orientation, as the asset is displayed
 		if with.IsLandscape != nil {
-			isLandscape := asset.PixelWidth > asset.PixelHeight
+			width, height := displaySize(&asset, details[asset.ID])
+			isLandscape := width > height
 			if isLandscape != *with.IsLandscape {
 				return false
 			}
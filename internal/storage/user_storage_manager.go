@@ -13,25 +13,49 @@ import (
 	"github.com/mahdi-cpp/photocloud_v2/config"
 	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
 	"log"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
+// Time a thumbnail stays in tinyImageLoader
+const tinyImageTTL = 60 * time.Minute
+
 type UserStorageManager struct {
-	mu           sync.RWMutex
-	users        map[int]*common_models.User
-	userStorages map[int]*UserStorage // Maps user IDs to their UserStorage
-	iconLoader   *image_loader.ImageLoader
-	ctx          context.Context
+	mu                    sync.RWMutex
+	users                 map[int]*common_models.User
+	userStorages          map[int]*UserStorage // Maps user IDs to their UserStorage
+	sharedMu              sync.Mutex           // Serializes shared album membership and asset changes
+	sharedAlbumManager    *collection.Manager[*model.SharedAlbum]
+	sharedCommentManager  *collection.Manager[*model.SharedAlbumComment]
+	sharedReactionManager *collection.Manager[*model.SharedAlbumReaction]
+	shareMu               sync.Mutex // Serializes share link counters
+	shareLinkManager      *collection.Manager[*model.ShareLink]
+	shareSecret           []byte
+	hiddenMu              sync.Mutex // Serializes PIN checks and attempt counters
+	hiddenPinManager      *collection.Manager[*model.HiddenPin]
+	quotaMu               sync.Mutex // Serializes quota changes
+	quotaManager          *collection.Manager[*model.Quota]
+	adminKey              []byte
+	faceDetector          FaceDetector
+	posterExtractor       PosterExtractor
+	encoders              map[string]ImageEncoder // by format, replaced as a whole by SetImageEncoder
+	etags                 etagCache
+	iconLoader            *image_loader.ImageLoader
+	iconCache             *cacheCounter
+	ctx                   context.Context
 }
 
 func NewUserStorageManager() (*UserStorageManager, error) {
 
 	// Handler the manager
 	manager := &UserStorageManager{
-		userStorages: make(map[int]*UserStorage),
-		users:        make(map[int]*common_models.User),
-		ctx:          context.Background(),
+		userStorages:    make(map[int]*UserStorage),
+		users:           make(map[int]*common_models.User),
+		posterExtractor: NewFFmpegPosterExtractor("ffmpeg"),
+		encoders:        defaultImageEncoders(),
+		ctx:             context.Background(),
 	}
 
 	userControl := network.NewNetworkControl[[]common_models.User]("http://localhost:8080/api/v1/user/")
@@ -52,12 +76,70 @@ func NewUserStorageManager() (*UserStorageManager, error) {
 		manager.users[user.ID] = &user
 	}
 
+	manager.sharedAlbumManager, err = collection.NewCollectionManager[*model.SharedAlbum](config.GetPath("/data/shared_albums.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load shared albums: %w", err)
+	}
+	manager.migrateUserSharedAlbums()
+
+	manager.sharedCommentManager, err = collection.NewCollectionManager[*model.SharedAlbumComment](config.GetPath("/data/shared_album_comments.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load shared album comments: %w", err)
+	}
+
+	manager.sharedReactionManager, err = collection.NewCollectionManager[*model.SharedAlbumReaction](config.GetPath("/data/shared_album_reactions.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load shared album reactions: %w", err)
+	}
+
+	manager.shareLinkManager, err = collection.NewCollectionManager[*model.ShareLink](config.GetPath("/data/share_links.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load share links: %w", err)
+	}
+
+	manager.shareSecret, err = loadShareSecret(config.GetPath("/data/share_links.key"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load share link key: %w", err)
+	}
+
+	manager.hiddenPinManager, err = collection.NewCollectionManager[*model.HiddenPin](config.GetPath("/data/hidden_pins.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load hidden album pins: %w", err)
+	}
+
+	manager.quotaManager, err = collection.NewCollectionManager[*model.Quota](config.GetPath("/data/quotas.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load quotas: %w", err)
+	}
+
+	manager.adminKey, err = loadShareSecret(config.GetPath("/data/admin.key"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load admin key: %w", err)
+	}
+
 	manager.iconLoader = image_loader.NewImageLoader(1000, config.GetPath("/data/icons"), 0)
+	manager.iconCache = newCacheCounter("icons", 1000, 0)
 	manager.loadAllIcons()
 
 	return manager, nil
 }
 
+// SetFaceDetector installs the face detector, storages opened afterwards use it.
+// There is none by default: faces are only scanned once a real detector is set.
+// NewLocalFaceDetector is a stand-in for tests and development.
+func (us *UserStorageManager) SetFaceDetector(detector FaceDetector) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.faceDetector = detector
+}
+
+// SetPosterExtractor replaces the ffmpeg poster extractor, storages opened afterwards use it
+func (us *UserStorageManager) SetPosterExtractor(extractor PosterExtractor) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.posterExtractor = extractor
+}
+
 func (us *UserStorageManager) loadAllIcons() {
 	us.iconLoader.GetLocalBasePath()
 
@@ -106,14 +188,57 @@ func (us *UserStorageManager) periodicMaintenance() {
 }
 
 func (us *UserStorageManager) RepositoryGetOriginalImage(userID int, filename string) ([]byte, error) {
-	return us.userStorages[userID].originalImageLoader.LoadImage(us.ctx, filename)
+	userStorage, err := us.GetUserStorage(nil, userID)
+	if err != nil {
+		return nil, err
+	}
+	userStorage.originalCache.record(filename)
+	return userStorage.originalImageLoader.LoadImage(us.ctx, filename)
 }
 
 func (us *UserStorageManager) RepositoryGetTinyImage(userID int, filename string) ([]byte, error) {
-	return us.userStorages[userID].tinyImageLoader.LoadImage(us.ctx, filename)
+	userStorage, err := us.GetUserStorage(nil, userID)
+	if err != nil {
+		return nil, err
+	}
+	userStorage.tinyCache.record(filename)
+	if userStorage.isRewritten(filename) {
+		return os.ReadFile(filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "thumbnails"), filename))
+	}
+	return userStorage.tinyImageLoader.LoadImage(us.ctx, filename)
+}
+
+// markRewritten records that a thumbnail was replaced in place. tinyImageLoader cannot
+// drop an entry, so the file is read from disk until the copy it may hold expires.
+func (userStorage *UserStorage) markRewritten(filename string) {
+
+	userStorage.rewrittenMu.Lock()
+	defer userStorage.rewrittenMu.Unlock()
+
+	now := time.Now()
+	for name, at := range userStorage.rewritten {
+		if now.Sub(at) >= tinyImageTTL {
+			delete(userStorage.rewritten, name)
+		}
+	}
+	userStorage.rewritten[filename] = now
+}
+
+func (userStorage *UserStorage) isRewritten(filename string) bool {
+
+	userStorage.rewrittenMu.Lock()
+	defer userStorage.rewrittenMu.Unlock()
+
+	at, exists := userStorage.rewritten[filename]
+	if exists && time.Since(at) >= tinyImageTTL {
+		delete(userStorage.rewritten, filename)
+		return false
+	}
+	return exists
 }
 
 func (us *UserStorageManager) RepositoryGetIcon(filename string) ([]byte, error) {
+	us.iconCache.record(filename)
 	return us.iconLoader.LoadImage(us.ctx, filename)
 }
 
@@ -128,13 +253,16 @@ func (us *UserStorageManager) GetUserStorage(c *gin.Context, userID int) (*UserS
 		return nil, fmt.Errorf("user id is Invalid")
 	}
 
-	var user = us.users[userID]
-
 	// Check if userStorage already exists for this user
 	if storage, exists := us.userStorages[userID]; exists {
 		return storage, nil
 	}
 
+	user, exists := us.users[userID]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
 	fmt.Println("ali1")
 	// Handler context for background workers
 	ctx, cancel := context.WithCancel(context.Background())
@@ -166,16 +294,25 @@ func (us *UserStorageManager) GetUserStorage(c *gin.Context, userID int) (*UserS
 		user:              *user,
 		metadata:          metadata.NewMetadataManager(config.GetUserPath(user.PhoneNumber, "metadata")),
 		thumbnail:         thumbnail.NewThumbnailManager(config.GetUserPath(user.PhoneNumber, "thumbnails")),
+		faceDetector:      us.faceDetector,
+		posterExtractor:   us.posterExtractor,
+		encoders:          us.encoders,
+		xmpSyncRequests:   make(chan struct{}, 1),
+		quota:             *us.getQuota(userID),
 		maintenanceCtx:    ctx,
 		cancelMaintenance: cancel,
 	}
 
 	userStorage.originalImageLoader = image_loader.NewImageLoader(50, config.GetUserPath(user.PhoneNumber, "assets"), 5*time.Minute)
-	userStorage.tinyImageLoader = image_loader.NewImageLoader(30000, config.GetUserPath(user.PhoneNumber, "thumbnails"), 60*time.Minute)
+	userStorage.tinyImageLoader = image_loader.NewImageLoader(30000, config.GetUserPath(user.PhoneNumber, "thumbnails"), tinyImageTTL)
+	userStorage.originalCache = newCacheCounter("originals", 50, 5*time.Minute)
+	userStorage.tinyCache = newCacheCounter("thumbnails", 30000, tinyImageTTL)
+	userStorage.rewritten = make(map[string]time.Time)
+	userStorage.derivatives = newDerivativeCache(config.GetUserPath(user.PhoneNumber, "thumbnails/derivatives"), config.DerivativeCacheBytes)
 
 	userStorage.assets, err = userStorage.metadata.LoadUserAllMetadata()
 	if err != nil {
-		return nil, fmt.Errorf("failed to load metadata for user %s: %w", userID, err)
+		return nil, fmt.Errorf("failed to load metadata for user %d: %w", userID, err)
 	}
 
 	userStorage.AlbumManager, err = collection.NewCollectionManager[*model.Album](config.GetUserPath(user.PhoneNumber, "data/albums.json"))
@@ -183,22 +320,32 @@ func (us *UserStorageManager) GetUserStorage(c *gin.Context, userID int) (*UserS
 		panic(err)
 	}
 
-	userStorage.SharedAlbumManager, err = collection.NewCollectionManager[*model.SharedAlbum](config.GetUserPath(user.PhoneNumber, "data/shared_albums.json"))
+	userStorage.TripManager, err = collection.NewCollectionManager[*model.Trip](config.GetUserPath(user.PhoneNumber, "data/trips.json"))
 	if err != nil {
 		panic(err)
 	}
 
-	userStorage.TripManager, err = collection.NewCollectionManager[*model.Trip](config.GetUserPath(user.PhoneNumber, "data/trips.json"))
+	userStorage.PersonManager, err = collection.NewCollectionManager[*model.Person](config.GetUserPath(user.PhoneNumber, "data/persons.json"))
 	if err != nil {
 		panic(err)
 	}
 
-	userStorage.PersonManager, err = collection.NewCollectionManager[*model.Person](config.GetUserPath(user.PhoneNumber, "data/persons.json"))
+	userStorage.PinnedManager, err = collection.NewCollectionManager[*model.Pinned](config.GetUserPath(user.PhoneNumber, "data/pinned.json"))
 	if err != nil {
 		panic(err)
 	}
 
-	userStorage.PinnedManager, err = collection.NewCollectionManager[*model.Pinned](config.GetUserPath(user.PhoneNumber, "data/pinned.json"))
+	userStorage.FaceRegionManager, err = collection.NewCollectionManager[*model.FaceRegion](config.GetUserPath(user.PhoneNumber, "data/face_regions.json"))
+	if err != nil {
+		panic(err)
+	}
+
+	userStorage.FaceScanManager, err = collection.NewCollectionManager[*model.FaceScan](config.GetUserPath(user.PhoneNumber, "data/face_scans.json"))
+	if err != nil {
+		panic(err)
+	}
+
+	userStorage.PersonSuggestionManager, err = collection.NewCollectionManager[*model.PersonSuggestion](config.GetUserPath(user.PhoneNumber, "data/person_suggestions.json"))
 	if err != nil {
 		panic(err)
 	}
@@ -208,11 +355,48 @@ func (us *UserStorageManager) GetUserStorage(c *gin.Context, userID int) (*UserS
 		panic(err)
 	}
 
+	userStorage.ActivityManager, err = collection.NewCollectionManager[*model.Activity](config.GetUserPath(user.PhoneNumber, "data/activity.json"))
+	if err != nil {
+		panic(err)
+	}
+
+	userStorage.TripSuggestionManager, err = collection.NewCollectionManager[*model.TripSuggestion](config.GetUserPath(user.PhoneNumber, "data/trip_suggestions.json"))
+	if err != nil {
+		panic(err)
+	}
+
+	userStorage.VideoManager, err = collection.NewCollectionManager[*model.VideoMetadata](config.GetUserPath(user.PhoneNumber, "data/videos.json"))
+	if err != nil {
+		panic(err)
+	}
+
+	userStorage.DetailsManager, err = collection.NewCollectionManager[*model.AssetDetails](config.GetUserPath(user.PhoneNumber, "data/asset_details.json"))
+	if err != nil {
+		panic(err)
+	}
+
+	userStorage.EditManager, err = collection.NewCollectionManager[*model.AssetEdit](config.GetUserPath(user.PhoneNumber, "data/asset_edits.json"))
+	if err != nil {
+		panic(err)
+	}
+
+	userStorage.prepareDetails()
+	userStorage.prepareEdits()
 	userStorage.prepareAlbums()
 	userStorage.prepareTrips()
 	userStorage.preparePersons()
 	userStorage.prepareCameras()
 	userStorage.preparePinned()
+	userStorage.prepareHidden()
+	userStorage.prepareTimeline()
+
+	if userStorage.faceDetector != nil {
+		go userStorage.faceScanWorker()
+	}
+	go userStorage.prepareDiskUsage()
+	go userStorage.probeVideos()
+	go userStorage.probeDetails()
+	go userStorage.xmpSyncWorker()
 
 	// Store the new userStorage
 	us.userStorages[userID] = userStorage
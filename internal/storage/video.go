@@ -0,0 +1,374 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mahdi-cpp/api-go-pkg/common_models"
+	"github.com/mahdi-cpp/photocloud_v2/config"
+	"github.com/mahdi-cpp/photocloud_v2/internal/domain/model"
+	"image"
+	"image/jpeg"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	videoPosterSize    = 270 // pixels of the longest side, like the other thumbnails
+	videoPosterTimeout = 30 * time.Second
+)
+
+// PosterExtractor grabs a frame of a video to make its poster thumbnail.
+// Implementations must be safe for concurrent use.
+type PosterExtractor interface {
+	ExtractFrame(ctx context.Context, path string, at time.Duration) (image.Image, error)
+}
+
+// FFmpegPosterExtractor runs ffmpeg to decode one frame as JPEG
+type FFmpegPosterExtractor struct {
+	Path string
+}
+
+func NewFFmpegPosterExtractor(path string) *FFmpegPosterExtractor {
+	return &FFmpegPosterExtractor{Path: path}
+}
+
+func (extractor *FFmpegPosterExtractor) ExtractFrame(ctx context.Context, path string, at time.Duration) (image.Image, error) {
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, extractor.Path,
+		"-loglevel", "error",
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64),
+		"-i", path,
+		"-frames:v", "1",
+		"-f", "image2pipe",
+		"-vcodec", "mjpeg",
+		"-")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return jpeg.Decode(&stdout)
+}
+
+// GetVideoMetadata returns what was read from the container of a video asset
+func (userStorage *UserStorage) GetVideoMetadata(assetID int) (*model.VideoMetadata, error) {
+
+	if _, exists := userStorage.GetAssetCopy(assetID); !exists {
+		return nil, ErrAssetNotFound
+	}
+
+	items, err := userStorage.VideoManager.GetList(func(a *model.VideoMetadata) bool {
+		return a.AssetID == assetID
+	})
+	if err != nil || len(items) == 0 {
+		return nil, ErrVideoNotFound
+	}
+	return items[0], nil
+}
+
+// processVideo reads the container metadata of a video asset and copies the capture
+// date, size and location to the asset. Callers hold the write lock and save the
+// asset, the poster is made afterwards with attachPoster.
+func (userStorage *UserStorage) processVideo(asset *common_models.PHAsset) (*model.VideoMetadata, error) {
+
+	path := filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "assets"), asset.Filename)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := parseMP4(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	video := &model.VideoMetadata{
+		AssetID:    asset.ID,
+		Duration:   info.Duration,
+		Width:      info.Width,
+		Height:     info.Height,
+		Rotation:   info.Rotation,
+		VideoCodec: info.VideoCodec,
+		AudioCodec: info.AudioCodec,
+	}
+
+	asset.MediaType = "video"
+	if info.Width > 0 && info.Height > 0 {
+		asset.PixelWidth, asset.PixelHeight = info.Width, info.Height
+	}
+	if !info.CreationTime.IsZero() {
+		captured := info.CreationTime
+		video.CapturedDate = &captured
+		if asset.CapturedDate.IsZero() {
+			asset.CapturedDate = captured
+		}
+	}
+	if info.HasLocation {
+		lat, lon := info.Latitude, info.Longitude
+		video.Latitude, video.Longitude = &lat, &lon
+		if _, exists := assetCoordinate(asset); !exists {
+			asset.Place.Latitude, asset.Place.Longitude = lat, lon
+		}
+	}
+
+	return userStorage.VideoManager.Create(video)
+}
+
+// attachPoster makes the poster thumbnail of a video, it runs without the lock
+// as decoding a frame can take a while
+func (userStorage *UserStorage) attachPoster(video *model.VideoMetadata, filename string) {
+
+	poster, err := userStorage.makePoster(filename, video.Duration)
+	if err != nil {
+		log.Printf("no poster for video %d: %v", video.AssetID, err)
+		return
+	}
+
+	// A photo may have replaced the video as the original meanwhile, its poster wins
+	userStorage.mu.RLock()
+	asset, exists := userStorage.assets[video.AssetID]
+	replaced := exists && asset.Filename != filename
+	userStorage.mu.RUnlock()
+	if replaced {
+		userStorage.photoPoster(video.AssetID)
+		return
+	}
+
+	video.Poster = poster
+	if _, err := userStorage.VideoManager.Update(video); err != nil {
+		log.Printf("failed to save poster of video %d: %v", video.AssetID, err)
+	}
+}
+
+// photoPoster makes the poster thumbnail of an asset again from its photo, once the
+// photo of a Live Photo replaced the video that arrived first as the original. It
+// runs without the lock.
+func (userStorage *UserStorage) photoPoster(assetID int) {
+
+	asset, exists := userStorage.GetAssetCopy(assetID)
+	if !exists || IsVideoFile(asset.Filename) {
+		return
+	}
+	filename := asset.Filename
+
+	img, err := userStorage.decodeOriginal(asset)
+	if err != nil {
+		log.Printf("no poster for asset %d: %v", assetID, err)
+		return
+	}
+	orientation, _ := userStorage.renderState(asset)
+
+	if _, err := userStorage.savePoster(filename, orientImage(img, orientation)); err != nil {
+		log.Printf("no poster for asset %d: %v", assetID, err)
+		return
+	}
+	userStorage.derivatives.removeAsset(assetID, userStorage.addThumbnailBytes)
+}
+
+// deleteVideoMetadata drops the container metadata of an asset that is no longer
+// a video. Callers hold the write lock.
+func (userStorage *UserStorage) deleteVideoMetadata(assetID int) {
+
+	items, err := userStorage.VideoManager.GetList(func(a *model.VideoMetadata) bool {
+		return a.AssetID == assetID
+	})
+	if err != nil {
+		log.Printf("failed to load video metadata of asset %d: %v", assetID, err)
+		return
+	}
+	for _, video := range items {
+		if err := userStorage.VideoManager.Delete(video.ID); err != nil {
+			log.Printf("failed to delete video metadata of asset %d: %v", assetID, err)
+		}
+	}
+}
+
+// makePoster saves a frame taken one second in, or halfway through short videos
+func (userStorage *UserStorage) makePoster(filename string, duration float64) (string, error) {
+
+	if userStorage.posterExtractor == nil {
+		return "", fmt.Errorf("no poster extractor")
+	}
+
+	at := time.Second
+	if duration > 0 && duration < 2 {
+		at = time.Duration(duration / 2 * float64(time.Second))
+	}
+
+	path := filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "assets"), filename)
+
+	ctx, cancel := context.WithTimeout(userStorage.maintenanceCtx, videoPosterTimeout)
+	defer cancel()
+
+	frame, err := userStorage.posterExtractor.ExtractFrame(ctx, path, at)
+	if err != nil {
+		return "", err
+	}
+
+	return userStorage.savePoster(filename, frame)
+}
+
+// savePoster writes the poster thumbnail of an original, replacing the one there
+func (userStorage *UserStorage) savePoster(filename string, img image.Image) (string, error) {
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleImage(img, img.Bounds(), videoPosterSize), &jpeg.Options{Quality: 85}); err != nil {
+		return "", fmt.Errorf("failed to encode poster: %w", err)
+	}
+
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	poster := fmt.Sprintf("%s_%d.jpg", base, videoPosterSize)
+	path := filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "thumbnails"), poster)
+
+	var previous int64
+	info, statErr := os.Stat(path)
+	if statErr == nil {
+		previous = info.Size()
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("failed to save poster: %w", err)
+	}
+	if statErr == nil {
+		userStorage.markRewritten(poster)
+		userStorage.addThumbnailBytes(int64(buf.Len())-previous, 0)
+	} else {
+		userStorage.addThumbnailBytes(int64(buf.Len()), 1)
+	}
+
+	return poster, nil
+}
+
+// probeVideos processes the videos that have no metadata yet, for libraries
+// uploaded before videos were handled
+func (userStorage *UserStorage) probeVideos() {
+
+	videos, err := userStorage.VideoManager.GetAll()
+	if err != nil {
+		log.Printf("failed to load video metadata for user %d: %v", userStorage.user.ID, err)
+		return
+	}
+	probed := make(map[int]bool, len(videos))
+	for _, video := range videos {
+		probed[video.AssetID] = true
+	}
+
+	userStorage.mu.RLock()
+	var pending []int
+	for id, asset := range userStorage.assets {
+		if !probed[id] && IsVideoFile(asset.Filename) {
+			pending = append(pending, id)
+		}
+	}
+	userStorage.mu.RUnlock()
+
+	for _, id := range pending {
+		if userStorage.maintenanceCtx.Err() != nil {
+			return
+		}
+
+		var video *model.VideoMetadata
+		var filename string
+
+		userStorage.mu.Lock()
+		if asset, exists := userStorage.assets[id]; exists {
+			userStorage.unindexAsset(asset)
+			video, err = userStorage.processVideo(asset)
+			userStorage.indexAsset(asset)
+			if err != nil {
+				log.Printf("failed to read video %d: %v", id, err)
+			} else if err := userStorage.metadata.SaveMetadata(asset); err != nil {
+				log.Printf("failed to save video %d: %v", id, err)
+			}
+			filename = asset.Filename
+		}
+		userStorage.mu.Unlock()
+
+		if video != nil {
+			userStorage.attachPoster(video, filename)
+		}
+	}
+}
+
+// OpenOriginal opens the original file of a user for streaming
+func (us *UserStorageManager) OpenOriginal(userID int, filename string) (*os.File, os.FileInfo, error) {
+
+	userStorage, err := us.GetUserStorage(nil, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if filename == "" || filepath.Base(filename) != filename {
+		return nil, nil, ErrAssetNotFound
+	}
+
+	file, err := os.Open(filepath.Join(config.GetUserPath(userStorage.user.PhoneNumber, "assets"), filename))
+	if err != nil {
+		return nil, nil, ErrAssetNotFound
+	}
+
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		file.Close()
+		return nil, nil, ErrAssetNotFound
+	}
+
+	return file, stat, nil
+}
+
+var videoExtensions = map[string]bool{
+	".mp4": true,
+	".m4v": true,
+	".mov": true,
+	".3gp": true,
+}
+
+// IsVideoFile reports whether a file is a video, videos are streamed from disk
+func IsVideoFile(filename string) bool {
+	return videoExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+var contentTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".avif": "image/avif",
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".mov":  "video/quicktime",
+	".3gp":  "video/3gpp",
+	".xmp":  "application/rdf+xml",
+}
+
+// ContentType returns the MIME type of a file from its extension
+func ContentType(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if contentType, exists := contentTypes[ext]; exists {
+		return contentType
+	}
+	if format, exists := rawFormats[ext]; exists {
+		return format.MimeType
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,730 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"slices"
+	"sort"
+)
+
+// WebP in the lossless format (VP8L) of RFC 9649. The encoder uses the subtract
+// green and predictor transforms, LZ77 backward references and one set of prefix
+// codes for the whole image. It does not try the color transform, color indexing
+// or the color cache, so the files are larger than those of libwebp but need no
+// binary. Near lossless encoding rounds the predictor residuals of the colors to
+// multiples of a power of two, which leaves far fewer symbols to code.
+
+const (
+	vp8lSignature      = 0x2f
+	vp8lMaxSize        = 1 << 14
+	vp8lPredictorBits  = 4 // tiles of 16x16 pixels share a predictor
+	vp8lPredictorModes = 14
+	vp8lMaxCodeLength  = 15
+	vp8lMaxLengthCode  = 7 // of the code that codes the code lengths
+	vp8lLengthPrefixes = 24
+	vp8lDistancePrefix = 40
+	vp8lMinMatch       = 3
+	vp8lMaxMatch       = 4096
+	vp8lMaxDistance    = 1<<20 - 120
+	vp8lHashBits       = 16
+	vp8lMaxChain       = 32 // candidates tried per pixel
+)
+
+const (
+	vp8lTransformPredictor     = 0
+	vp8lTransformSubtractGreen = 2
+)
+
+// Order in which the lengths of the code length code are written
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// Short distance codes 1 to 120 as (8 - x offset) | y offset << 4, closest pixels first
+var vp8lDistanceMap = [120]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+// WebPEncoder writes WebP in pure Go, it is always available. NearLossless is the
+// number of low bits of each color channel that may change, from 0 (lossless) to 4.
+// Alpha is always kept.
+type WebPEncoder struct {
+	NearLossless int
+}
+
+func (encoder *WebPEncoder) Encode(ctx context.Context, img image.Image) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return encodeWebP(img, min(max(encoder.NearLossless, 0), 4))
+}
+
+func encodeWebP(img image.Image, nearLossless int) ([]byte, error) {
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= 0 || height <= 0 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return nil, fmt.Errorf("webp: invalid image size %dx%d", width, height)
+	}
+
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || nrgba.Rect.Min != (image.Point{}) {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(nrgba, nrgba.Rect, img, bounds.Min, draw.Src)
+	}
+
+	pixels := make([]uint32, width*height)
+	alpha := false
+	for y := 0; y < height; y++ {
+		row := nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+width*4]
+		for x := 0; x < width; x++ {
+			r, g, b, a := row[x*4], row[x*4+1], row[x*4+2], row[x*4+3]
+			pixels[y*width+x] = uint32(a)<<24 | uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+			alpha = alpha || a != 0xff
+		}
+	}
+
+	w := &bitWriter{}
+	w.write(vp8lSignature, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if alpha {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	w.write(0, 3) // version
+
+	w.write(1, 1)
+	w.write(vp8lTransformSubtractGreen, 2)
+	subtractGreen(pixels)
+
+	w.write(1, 1)
+	w.write(vp8lTransformPredictor, 2)
+	w.write(vp8lPredictorBits-2, 3)
+	modes, tilesX := choosePredictors(pixels, width, height)
+	writeEntropyImage(w, modes, tilesX, false)
+	pixels = predictResiduals(pixels, width, height, modes, tilesX, nearLossless)
+
+	w.write(0, 1) // no more transforms
+	writeEntropyImage(w, pixels, width, true)
+
+	data := w.bytes()
+	padding := len(data) & 1
+
+	out := make([]byte, 0, 20+len(data)+padding)
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(12+len(data)+padding))
+	out = append(out, "WEBPVP8L"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+	out = append(out, data...)
+	if padding != 0 {
+		out = append(out, 0)
+	}
+
+	return out, nil
+}
+
+// bitWriter packs values least significant bit first, as VP8L reads them
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	count uint
+}
+
+func (w *bitWriter) write(value uint32, bits uint) {
+	w.acc |= uint64(value) << w.count
+	w.count += bits
+	for w.count >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.count -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.count > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.count = 0, 0
+	}
+	return w.buf
+}
+
+func subtractGreen(pixels []uint32) {
+	for i, p := range pixels {
+		green := (p >> 8) & 0xff
+		red := (p>>16 - green) & 0xff
+		blue := (p - green) & 0xff
+		pixels[i] = p&0xff00ff00 | red<<16 | blue
+	}
+}
+
+// choosePredictors picks the predictor of each tile with the smallest residuals.
+// The modes are returned as the green channel of the predictor image.
+func choosePredictors(pixels []uint32, width int, height int) ([]uint32, int) {
+
+	tileSize := 1 << vp8lPredictorBits
+	tilesX := (width + tileSize - 1) >> vp8lPredictorBits
+	tilesY := (height + tileSize - 1) >> vp8lPredictorBits
+	modes := make([]uint32, tilesX*tilesY)
+
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+
+			best, bestCost := 0, -1
+			for mode := 0; mode < vp8lPredictorModes; mode++ {
+				cost := 0
+				for y := ty * tileSize; y < min((ty+1)*tileSize, height); y++ {
+					for x := tx * tileSize; x < min((tx+1)*tileSize, width); x++ {
+						i := y*width + x
+						cost += residualCost(pixels[i], predictPixel(pixels, width, x, y, mode))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+
+			modes[ty*tilesX+tx] = 0xff000000 | uint32(best)<<8
+		}
+	}
+
+	return modes, tilesX
+}
+
+func residualCost(pixel uint32, prediction uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		d := int(int8(uint8(pixel>>shift) - uint8(prediction>>shift)))
+		if d < 0 {
+			d = -d
+		}
+		cost += d
+	}
+	return cost
+}
+
+// predictResiduals returns the differences to the predictions. With near lossless
+// bits the colors are replaced by the closest values whose residuals are multiples
+// of 1<<bits, and later pixels are predicted from those as the decoder will.
+func predictResiduals(pixels []uint32, width int, height int, modes []uint32, tilesX int, bits int) []uint32 {
+
+	residuals := make([]uint32, len(pixels))
+	if bits > 0 {
+		pixels = slices.Clone(pixels)
+	}
+	step := uint32(1) << bits
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			mode := int(modes[(y>>vp8lPredictorBits)*tilesX+x>>vp8lPredictorBits]>>8) & 0xff
+			i := y*width + x
+			prediction := predictPixel(pixels, width, x, y, mode)
+
+			if bits > 0 {
+				// Rounded in the original colors, red and blue are stored minus green
+				p := pixels[i]
+				green := nearestCongruent(p>>8&0xff, prediction>>8, step)
+				red := nearestCongruent((p>>16+p>>8)&0xff, prediction>>16+green, step)
+				blue := nearestCongruent((p+p>>8)&0xff, prediction+green, step)
+				pixels[i] = p&0xff000000 | (red-green)&0xff<<16 | green<<8 | (blue-green)&0xff
+			}
+
+			residuals[i] = subPixels(pixels[i], prediction)
+		}
+	}
+
+	return residuals
+}
+
+// nearestCongruent returns the byte closest to value that equals base modulo step
+func nearestCongruent(value uint32, base uint32, step uint32) uint32 {
+	remainder := int(base & (step - 1))
+	candidate := remainder + (int(value)-remainder+int(step/2))/int(step)*int(step)
+	if candidate > 255 {
+		candidate -= int(step)
+	}
+	return uint32(candidate)
+}
+
+// predictPixel applies a predictor mode, the first row and column use fixed predictors
+func predictPixel(pixels []uint32, width int, x int, y int, mode int) uint32 {
+
+	i := y*width + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return pixels[i-1]
+	case x == 0:
+		return pixels[i-width]
+	}
+
+	// The top right pixel of the last column is the first pixel of the current row
+	left, top, topLeft, topRight := pixels[i-1], pixels[i-width], pixels[i-width-1], pixels[i-width+1]
+
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return left
+	case 2:
+		return top
+	case 3:
+		return topRight
+	case 4:
+		return topLeft
+	case 5:
+		return average2(average2(left, topRight), top)
+	case 6:
+		return average2(left, topLeft)
+	case 7:
+		return average2(left, top)
+	case 8:
+		return average2(topLeft, top)
+	case 9:
+		return average2(top, topRight)
+	case 10:
+		return average2(average2(left, topLeft), average2(top, topRight))
+	case 11:
+		return selectPredictor(left, top, topLeft)
+	case 12:
+		return clampAddSubtractFull(left, top, topLeft)
+	default:
+		return clampAddSubtractHalf(average2(left, top), topLeft)
+	}
+}
+
+func average2(a uint32, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+func selectPredictor(left uint32, top uint32, topLeft uint32) uint32 {
+	distLeft, distTop := 0, 0
+	for shift := 0; shift < 32; shift += 8 {
+		l, t, tl := int(left>>shift&0xff), int(top>>shift&0xff), int(topLeft>>shift&0xff)
+		estimate := l + t - tl
+		distLeft += abs(estimate - l)
+		distTop += abs(estimate - t)
+	}
+	if distLeft < distTop {
+		return left
+	}
+	return top
+}
+
+func clampAddSubtractFull(a uint32, b uint32, c uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(a>>shift&0xff) + int(b>>shift&0xff) - int(c>>shift&0xff)
+		out |= uint32(clampByte(v)) << shift
+	}
+	return out
+}
+
+func clampAddSubtractHalf(a uint32, b uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		va, vb := int(a>>shift&0xff), int(b>>shift&0xff)
+		out |= uint32(clampByte(va+(va-vb)/2)) << shift
+	}
+	return out
+}
+
+func clampByte(v int) uint8 {
+	return uint8(min(max(v, 0), 255))
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// subPixels subtracts each channel modulo 256
+func subPixels(a uint32, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	redBlue := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// vp8lToken is a literal pixel, or a backward reference when length is set
+type vp8lToken struct {
+	pixel    uint32
+	length   int
+	distance int // distance code, short codes first
+}
+
+// backwardReferences finds repeated runs of pixels greedily with a hash chain,
+// the pixels to the left and above are tried first as they have the shortest codes
+func backwardReferences(pixels []uint32, width int) []vp8lToken {
+
+	shortCodes := make(map[int]int, len(vp8lDistanceMap))
+	for code := len(vp8lDistanceMap); code >= 1; code-- {
+		offset := int(vp8lDistanceMap[code-1])
+		distance := max((offset>>4)*width+8-offset&0xf, 1)
+		shortCodes[distance] = code
+	}
+
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	chain := make([]int32, len(pixels))
+
+	hash := func(i int) uint32 {
+		h := pixels[i]*0x1e35a7bd ^ pixels[i+1]*0x9e3779b1 ^ pixels[i+2]*0x85ebca6b
+		return h >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+vp8lMinMatch <= len(pixels) {
+			h := hash(i)
+			chain[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	matchLength := func(from int, i int) int {
+		limit := min(len(pixels)-i, vp8lMaxMatch)
+		n := 0
+		for n < limit && pixels[from+n] == pixels[i+n] {
+			n++
+		}
+		return n
+	}
+
+	var tokens []vp8lToken
+	for i := 0; i < len(pixels); {
+
+		bestLength, bestDistance := 0, 0
+		if i+vp8lMinMatch <= len(pixels) {
+			try := func(from int) {
+				if from < 0 || from >= i || i-from > vp8lMaxDistance {
+					return
+				}
+				if n := matchLength(from, i); n > bestLength {
+					bestLength, bestDistance = n, i-from
+				}
+			}
+			try(i - 1)
+			try(i - width)
+			for from, n := head[hash(i)], 0; from >= 0 && n < vp8lMaxChain; from, n = chain[from], n+1 {
+				try(int(from))
+			}
+		}
+
+		if bestLength < vp8lMinMatch {
+			tokens = append(tokens, vp8lToken{pixel: pixels[i]})
+			insert(i)
+			i++
+			continue
+		}
+
+		code, short := shortCodes[bestDistance]
+		if !short {
+			code = bestDistance + len(vp8lDistanceMap)
+		}
+		tokens = append(tokens, vp8lToken{length: bestLength, distance: code})
+		for end := i + bestLength; i < end; i++ {
+			insert(i)
+		}
+	}
+
+	return tokens
+}
+
+// prefixCode splits a length or distance code into a prefix symbol and extra bits
+func prefixCode(value int) (int, int, uint) {
+	v := value - 1
+	if v < 4 {
+		return v, 0, 0
+	}
+	highest := 0
+	for v>>(highest+1) != 0 {
+		highest++
+	}
+	second := (v >> (highest - 1)) & 1
+	bits := uint(highest - 1)
+	return 2*highest + second, v & (1<<bits - 1), bits
+}
+
+// writeEntropyImage writes pixels with one set of prefix codes and no color cache.
+// The main image also declares that it has no meta prefix codes.
+func writeEntropyImage(w *bitWriter, pixels []uint32, width int, main bool) {
+
+	tokens := backwardReferences(pixels, width)
+
+	histograms := [5][]int{
+		make([]int, 256+vp8lLengthPrefixes),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, vp8lDistancePrefix),
+	}
+	for _, token := range tokens {
+		if token.length == 0 {
+			histograms[0][token.pixel>>8&0xff]++
+			histograms[1][token.pixel>>16&0xff]++
+			histograms[2][token.pixel&0xff]++
+			histograms[3][token.pixel>>24]++
+			continue
+		}
+		lengthSymbol, _, _ := prefixCode(token.length)
+		distanceSymbol, _, _ := prefixCode(token.distance)
+		histograms[0][256+lengthSymbol]++
+		histograms[4][distanceSymbol]++
+	}
+
+	w.write(0, 1) // no color cache
+	if main {
+		w.write(0, 1) // no meta prefix codes
+	}
+
+	var codes [5]prefixCodes
+	for i, histogram := range histograms {
+		codes[i] = newPrefixCodes(histogram, vp8lMaxCodeLength)
+		codes[i].writeHeader(w)
+	}
+
+	for _, token := range tokens {
+		if token.length == 0 {
+			codes[0].writeSymbol(w, int(token.pixel>>8&0xff))
+			codes[1].writeSymbol(w, int(token.pixel>>16&0xff))
+			codes[2].writeSymbol(w, int(token.pixel&0xff))
+			codes[3].writeSymbol(w, int(token.pixel>>24))
+			continue
+		}
+		symbol, extra, bits := prefixCode(token.length)
+		codes[0].writeSymbol(w, 256+symbol)
+		w.write(uint32(extra), bits)
+		symbol, extra, bits = prefixCode(token.distance)
+		codes[4].writeSymbol(w, symbol)
+		w.write(uint32(extra), bits)
+	}
+}
+
+// prefixCodes is a canonical Huffman code. A code with a single symbol takes no
+// bits per symbol, as in the decoders.
+type prefixCodes struct {
+	lengths []uint8
+	codes   []uint16 // bit reversed, ready to be written
+	symbols int
+}
+
+func newPrefixCodes(histogram []int, maxLength int) prefixCodes {
+
+	lengths := huffmanLengths(histogram, maxLength)
+
+	var count [vp8lMaxCodeLength + 1]int
+	symbols := 0
+	for _, length := range lengths {
+		if length > 0 {
+			count[length]++
+			symbols++
+		}
+	}
+
+	var next [vp8lMaxCodeLength + 1]int
+	code := 0
+	for bits := 1; bits <= vp8lMaxCodeLength; bits++ {
+		code = (code + count[bits-1]) << 1
+		next[bits] = code
+	}
+
+	codes := make([]uint16, len(lengths))
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		code := next[length]
+		next[length]++
+		var reversed uint16
+		for bit := 0; bit < int(length); bit++ {
+			reversed = reversed<<1 | uint16(code>>bit&1)
+		}
+		codes[symbol] = reversed
+	}
+
+	return prefixCodes{lengths: lengths, codes: codes, symbols: symbols}
+}
+
+func (p *prefixCodes) writeSymbol(w *bitWriter, symbol int) {
+	if p.symbols > 1 {
+		w.write(uint32(p.codes[symbol]), uint(p.lengths[symbol]))
+	}
+}
+
+// writeHeader writes the code lengths, as a simple code when one or two symbols
+// below 256 are used
+func (p *prefixCodes) writeHeader(w *bitWriter) {
+
+	var used []int
+	for symbol, length := range p.lengths {
+		if length > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) == 0 {
+		used = []int{0}
+	}
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		w.write(1, 1)
+		w.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			w.write(0, 1)
+			w.write(uint32(used[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			w.write(uint32(used[1]), 8)
+		}
+		return
+	}
+
+	// Runs of lengths are coded with 16 (repeat the previous length 3-6 times),
+	// 17 (3-10 zeros) and 18 (11-138 zeros)
+	type lengthToken struct {
+		symbol int
+		extra  uint32
+		bits   uint
+	}
+	var tokens []lengthToken
+	for i := 0; i < len(p.lengths); {
+		length := p.lengths[i]
+		run := 1
+		for i+run < len(p.lengths) && p.lengths[i+run] == length {
+			run++
+		}
+		i += run
+
+		if length == 0 {
+			for run >= 3 {
+				if run >= 11 {
+					n := min(run, 138)
+					tokens = append(tokens, lengthToken{symbol: 18, extra: uint32(n - 11), bits: 7})
+					run -= n
+				} else {
+					n := min(run, 10)
+					tokens = append(tokens, lengthToken{symbol: 17, extra: uint32(n - 3), bits: 3})
+					run -= n
+				}
+			}
+		} else {
+			tokens = append(tokens, lengthToken{symbol: int(length)})
+			run--
+			for run >= 3 {
+				n := min(run, 6)
+				tokens = append(tokens, lengthToken{symbol: 16, extra: uint32(n - 3), bits: 2})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, lengthToken{symbol: int(length)})
+		}
+	}
+
+	histogram := make([]int, len(vp8lCodeLengthOrder))
+	for _, token := range tokens {
+		histogram[token.symbol]++
+	}
+	lengthCodes := newPrefixCodes(histogram, vp8lMaxLengthCode)
+
+	count := len(vp8lCodeLengthOrder)
+	for count > 4 && lengthCodes.lengths[vp8lCodeLengthOrder[count-1]] == 0 {
+		count--
+	}
+
+	w.write(0, 1) // normal code
+	w.write(uint32(count-4), 4)
+	for _, symbol := range vp8lCodeLengthOrder[:count] {
+		w.write(uint32(lengthCodes.lengths[symbol]), 3)
+	}
+	w.write(0, 1) // code lengths for the whole alphabet
+
+	for _, token := range tokens {
+		lengthCodes.writeSymbol(w, token.symbol)
+		w.write(token.extra, token.bits)
+	}
+}
+
+// huffmanLengths returns the code lengths of an optimal prefix code limited to
+// maxLength bits. Small counts are raised until the tree is shallow enough.
+func huffmanLengths(histogram []int, maxLength int) []uint8 {
+
+	lengths := make([]uint8, len(histogram))
+
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	switch len(used) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[used[0]] = 1
+		return lengths
+	}
+
+	type node struct {
+		weight      int
+		symbol      int // of leaves, -1 for inner nodes
+		left, right int
+	}
+
+	for countMin := 1; ; countMin *= 2 {
+
+		nodes := make([]node, 0, 2*len(used))
+		for _, symbol := range used {
+			nodes = append(nodes, node{weight: max(histogram[symbol], countMin), symbol: symbol})
+		}
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+
+		// Two queues: the sorted leaves and the inner nodes, which are created in weight order
+		leaf, inner := 0, len(nodes)
+		pop := func() int {
+			if leaf < len(used) && (inner >= len(nodes) || nodes[leaf].weight <= nodes[inner].weight) {
+				leaf++
+				return leaf - 1
+			}
+			inner++
+			return inner - 1
+		}
+		for n := 1; n < len(used); n++ {
+			a, b := pop(), pop()
+			nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, symbol: -1, left: a, right: b})
+		}
+
+		depths := make([]int, len(nodes))
+		deepest := 0
+		for i := len(nodes) - 1; i >= 0; i-- {
+			if nodes[i].symbol >= 0 {
+				lengths[nodes[i].symbol] = uint8(depths[i])
+				deepest = max(deepest, depths[i])
+				continue
+			}
+			depths[nodes[i].left] = depths[i] + 1
+			depths[nodes[i].right] = depths[i] + 1
+		}
+
+		if deepest <= maxLength {
+			return lengths
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestWebPEncoder(t *testing.T) {
+
+	gradient := image.NewNRGBA(image.Rect(0, 0, 70, 45))
+	noise := image.NewNRGBA(image.Rect(0, 0, 33, 17))
+	translucent := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	seed := uint32(1)
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 70; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 3), G: uint8(y * 5), B: uint8(x + y), A: 255})
+			if x < 33 && y < 17 {
+				seed = seed*1103515245 + 12345
+				noise.SetNRGBA(x, y, color.NRGBA{R: uint8(seed >> 24), G: uint8(seed >> 16), B: uint8(seed >> 8), A: 255})
+			}
+			if x < 20 && y < 20 {
+				translucent.SetNRGBA(x, y, color.NRGBA{R: 200, G: uint8(x * 10), B: 40, A: uint8(y * 12)})
+			}
+		}
+	}
+
+	tests := []struct {
+		name         string
+		img          image.Image
+		nearLossless int
+	}{
+		{name: "gradient", img: gradient},
+		{name: "noise", img: noise},
+		{name: "alpha", img: translucent},
+		{name: "faces", img: faceImage(testFace{x: 20, y: 20, size: 40, skin: lightSkin, eyes: true})},
+		{name: "uniform", img: faceImage()},
+		{name: "one pixel", img: image.NewNRGBA(image.Rect(0, 0, 1, 1))},
+		{name: "offset bounds", img: gradient.SubImage(image.Rect(10, 5, 41, 30))},
+		{name: "near lossless gradient", img: gradient, nearLossless: 3},
+		{name: "near lossless noise", img: noise, nearLossless: 4},
+		{name: "near lossless alpha", img: translucent, nearLossless: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := (&WebPEncoder{NearLossless: test.nearLossless}).Encode(context.Background(), test.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := webp.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+
+			// Colors may move by less than a step, alpha is always kept
+			tolerance := 1<<test.nearLossless - 1
+			near := func(a uint8, b uint8) bool { return abs(int(a)-int(b)) <= tolerance }
+
+			bounds := test.img.Bounds()
+			if decoded.Bounds().Dx() != bounds.Dx() || decoded.Bounds().Dy() != bounds.Dy() {
+				t.Fatalf("size = %v, want %v", decoded.Bounds().Size(), bounds.Size())
+			}
+			for y := 0; y < bounds.Dy(); y++ {
+				for x := 0; x < bounds.Dx(); x++ {
+					want := color.NRGBAModel.Convert(test.img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+					got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+					if !near(got.R, want.R) || !near(got.G, want.G) || !near(got.B, want.B) || got.A != want.A {
+						t.Fatalf("pixel %d,%d = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -1081,7 +1081,7 @@ func (ps *PhotoStorage) rebuildIndex() error {
 		// Verify asset file exists
 		assetPath := filepath.Join(ps.config.AssetsDir, asset.Filename)
 		if _, err := os.Stat(assetPath); err != nil {
-			log.Printf("Asset file missing for %d: %s", id, asset.ID)
+			log.Printf("Asset file missing for %d: %s", id, asset.Filename)
 			continue
 		}
 